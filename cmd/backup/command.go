@@ -4,11 +4,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/offen/docker-volume-backup/internal/errwrap"
 	"github.com/robfig/cron/v3"
@@ -19,47 +25,68 @@ type command struct {
 	schedules []cron.EntryID
 	cr        *cron.Cron
 	reload    chan struct{}
+
+	mu             sync.Mutex
+	configurations []*Config
+	runs           map[string]*runStatus
 }
 
 func newCommand() *command {
 	return &command{
 		logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		runs:   map[string]*runStatus{},
 	}
 }
 
 // runAsCommand executes a backup run for each configuration that is available
-// and then returns
-func (c *command) runAsCommand() error {
-	configurations, err := sourceConfiguration(configStrategyEnv)
+// and then returns. In dry run mode, runs only report what they would do.
+func (c *command) runAsCommand(dryRun bool) error {
+	strategy := configStrategyEnv
+	if _, ok := lookupConfigFile(); ok {
+		strategy = configStrategyFile
+	}
+	configurations, err := sourceConfiguration(strategy)
 	if err != nil {
-		return errwrap.Wrap(err, "error loading env vars")
+		return errwrap.Wrap(err, "error loading configuration")
 	}
 
+	var degraded bool
 	for _, config := range configurations {
-		if err := runScript(config); err != nil {
+		config.dryRun = dryRun
+		stats, err := runScript(config)
+		if err != nil {
 			return errwrap.Wrap(err, "error running script")
 		}
+		degraded = degraded || stats.Degraded
 	}
 
+	if degraded {
+		return errDegraded
+	}
 	return nil
 }
 
+// errDegraded signals that all runs completed, but storage backends failed
+// in a way that is permitted by the configured success policy.
+var errDegraded = errors.New("backup completed in degraded state")
+
+// scheduleParser parses the cron expressions used for scheduling backups.
+var scheduleParser = cron.NewParser(
+	cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
 type foregroundOpts struct {
 	profileCronExpression string
+	httpAddr              string
+	httpToken             string
 }
 
 // runInForeground starts the program as a long running process, scheduling
 // a job for each configuration that is available.
 func (c *command) runInForeground(opts foregroundOpts) error {
-	c.cr = cron.New(
-		cron.WithParser(
-			cron.NewParser(
-				cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
-			),
-		),
-	)
-
-	if err := c.schedule(configStrategyConfd); err != nil {
+	c.cr = cron.New(cron.WithParser(scheduleParser))
+
+	if err := c.schedule(configStrategyFile); err != nil {
 		return errwrap.Wrap(err, "error scheduling")
 	}
 
@@ -70,39 +97,118 @@ func (c *command) runInForeground(opts foregroundOpts) error {
 	}
 
 	var quit = make(chan os.Signal, 1)
+	var hangup = make(chan os.Signal, 1)
 	c.reload = make(chan struct{}, 1)
 	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(hangup, syscall.SIGHUP)
 	c.cr.Start()
 
+	watched := []string{confdDirectory}
+	if location, ok := lookupConfigFile(); ok {
+		watched = append(watched, filepath.Dir(location))
+	}
+	for _, directory := range watched {
+		stopWatching, err := c.watchConfiguration(directory, 2*time.Second)
+		if err != nil {
+			return errwrap.Wrap(err, "error watching configuration")
+		}
+		defer func() {
+			if err := stopWatching(); err != nil {
+				c.logger.Warn(fmt.Sprintf("Error stopping file watcher: %v", err))
+			}
+		}()
+	}
+
+	var srv *http.Server
+	if opts.httpAddr != "" {
+		if opts.httpToken == "" {
+			return errwrap.Wrap(nil, "refusing to start the HTTP API without a token")
+		}
+		srv = &http.Server{
+			Addr:              opts.httpAddr,
+			Handler:           c.newServer(opts.httpToken),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				c.logger.Error(
+					fmt.Sprintf("Unexpected error running HTTP API: %v", errwrap.Unwrap(err)),
+					"error",
+					err,
+				)
+			}
+		}()
+		c.logger.Info(fmt.Sprintf("Serving HTTP API on %s", opts.httpAddr))
+	}
+
 	for {
 		select {
 		case <-quit:
+			if srv != nil {
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				if err := srv.Shutdown(shutdownCtx); err != nil {
+					c.logger.Warn(fmt.Sprintf("Error shutting down HTTP API: %v", err))
+				}
+				cancel()
+			}
 			ctx := c.cr.Stop()
 			<-ctx.Done()
 			return nil
+		case <-hangup:
+			c.logger.Info("Received SIGHUP, reloading configuration.")
+			c.requestReload()
 		case <-c.reload:
-			if err := c.schedule(configStrategyConfd); err != nil {
-				return errwrap.Wrap(err, "error reloading configuration")
+			if err := c.schedule(configStrategyFile); err != nil {
+				c.logger.Error(
+					fmt.Sprintf("Error reloading configuration, keeping previous schedules: %v", errwrap.Unwrap(err)),
+					"error",
+					err,
+				)
 			}
 		}
 	}
 }
 
-// schedule wipes all existing schedules and enqueues all schedules available
-// using the given configuration strategy
-func (c *command) schedule(strategy configStrategy) error {
-	for _, id := range c.schedules {
-		c.cr.Remove(id)
-	}
-
+// schedule enqueues all schedules available using the given configuration
+// strategy and wipes all previously existing schedules. In case the
+// configuration cannot be loaded or scheduled, previously existing schedules
+// are left untouched.
+func (c *command) schedule(strategy configStrategy) (err error) {
 	configurations, err := sourceConfiguration(strategy)
 	if err != nil {
 		return errwrap.Wrap(err, "error sourcing configuration")
 	}
 
+	var schedules []cron.EntryID
+	defer func() {
+		if err != nil {
+			for _, id := range schedules {
+				c.cr.Remove(id)
+			}
+			return
+		}
+		for _, id := range c.schedules {
+			c.cr.Remove(id)
+		}
+		c.schedules = schedules
+		c.mu.Lock()
+		c.configurations = configurations
+		c.mu.Unlock()
+	}()
+
 	for _, cfg := range configurations {
 		config := cfg
 		id, err := c.cr.AddFunc(config.BackupCronExpression, func() {
+			start, ok := c.startRun(config)
+			if !ok {
+				c.logger.Warn(
+					fmt.Sprintf(
+						"Skipped running script on schedule %s, run already in progress",
+						config.BackupCronExpression,
+					),
+				)
+				return
+			}
 			c.logger.Info(
 				fmt.Sprintf(
 					"Now running script on schedule %s",
@@ -110,7 +216,7 @@ func (c *command) schedule(strategy configStrategy) error {
 				),
 			)
 
-			if err := runScript(config); err != nil {
+			if _, err := c.finishRun(config, runTriggerSchedule, start); err != nil {
 				c.logger.Error(
 					fmt.Sprintf(
 						"Unexpected error running schedule %s: %v",
@@ -132,7 +238,8 @@ func (c *command) schedule(strategy configStrategy) error {
 				fmt.Sprintf("Scheduled cron expression %s will never run, is this intentional?", config.BackupCronExpression),
 			)
 		}
-		c.schedules = append(c.schedules, id)
+		c.checkHistory(config)
+		schedules = append(schedules, id)
 	}
 
 	return nil
@@ -141,6 +248,10 @@ func (c *command) schedule(strategy configStrategy) error {
 // must exits the program when passed an error. It should be the only
 // place where the application exits forcefully.
 func (c *command) must(err error) {
+	if errors.Is(err, errDegraded) {
+		c.logger.Warn("Backup completed, but some storage backends failed.")
+		os.Exit(2)
+	}
 	if err != nil {
 		c.logger.Error(
 			fmt.Sprintf("Fatal error running command: %v", errwrap.Unwrap(err)),
@@ -4,12 +4,17 @@
 package main
 
 import (
+	"bytes"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/offen/docker-volume-backup/internal/errwrap"
@@ -18,25 +23,14 @@ import (
 // Config holds all configuration values that are expected to be set
 // by users.
 type Config struct {
-	AwsS3BucketName               string          `split_words:"true"`
-	AwsS3Path                     string          `split_words:"true"`
-	AwsEndpoint                   string          `split_words:"true" default:"s3.amazonaws.com"`
-	AwsEndpointProto              string          `split_words:"true" default:"https"`
-	AwsEndpointInsecure           bool            `split_words:"true"`
-	AwsEndpointCACert             CertDecoder     `envconfig:"AWS_ENDPOINT_CA_CERT"`
-	AwsStorageClass               string          `split_words:"true"`
-	AwsAccessKeyID                string          `envconfig:"AWS_ACCESS_KEY_ID"`
-	AwsSecretAccessKey            string          `split_words:"true"`
-	AwsIamRoleEndpoint            string          `split_words:"true"`
-	AwsPartSize                   int64           `split_words:"true"`
+	StorageConfig
 	BackupCompression             CompressionType `split_words:"true" default:"gz"`
 	GzipParallelism               WholeNumber     `split_words:"true" default:"1"`
 	BackupSources                 string          `split_words:"true" default:"/backup"`
 	BackupFilename                string          `split_words:"true" default:"backup-%Y-%m-%dT%H-%M-%S.{{ .Extension }}"`
 	BackupFilenameExpand          bool            `split_words:"true"`
-	BackupLatestSymlink           string          `split_words:"true"`
-	BackupArchive                 string          `split_words:"true" default:"/archive"`
 	BackupCronExpression          string          `split_words:"true" default:"@daily"`
+	BackupRunMode                 RunMode         `split_words:"true" default:"all"`
 	BackupRetentionDays           int32           `split_words:"true" default:"-1"`
 	BackupPruningLeeway           time.Duration   `split_words:"true" default:"1m"`
 	BackupPruningPrefix           string          `split_words:"true"`
@@ -46,54 +40,148 @@ type Config struct {
 	BackupFromSnapshot            bool            `split_words:"true"`
 	BackupExcludeRegexp           RegexpDecoder   `split_words:"true"`
 	BackupSkipBackendsFromPrune   []string        `split_words:"true"`
-	GpgPassphrase                 string          `split_words:"true"`
-	GpgPublicKeyRing              string          `split_words:"true"`
-	AgePassphrase                 string          `split_words:"true"`
-	AgePublicKeys                 []string        `split_words:"true"`
-	NotificationURLs              []string        `envconfig:"NOTIFICATION_URLS"`
-	NotificationLevel             string          `split_words:"true" default:"error"`
-	EmailNotificationRecipient    string          `split_words:"true"`
-	EmailNotificationSender       string          `split_words:"true" default:"noreply@nohost"`
-	EmailSMTPHost                 string          `envconfig:"EMAIL_SMTP_HOST"`
-	EmailSMTPPort                 int             `envconfig:"EMAIL_SMTP_PORT" default:"587"`
-	EmailSMTPUsername             string          `envconfig:"EMAIL_SMTP_USERNAME"`
-	EmailSMTPPassword             string          `envconfig:"EMAIL_SMTP_PASSWORD"`
-	WebdavUrl                     string          `split_words:"true"`
-	WebdavUrlInsecure             bool            `split_words:"true"`
-	WebdavPath                    string          `split_words:"true" default:"/"`
-	WebdavUsername                string          `split_words:"true"`
-	WebdavPassword                string          `split_words:"true"`
-	SSHHostName                   string          `split_words:"true"`
-	SSHPort                       string          `split_words:"true" default:"22"`
-	SSHUser                       string          `split_words:"true"`
-	SSHPassword                   string          `split_words:"true"`
-	SSHIdentityFile               string          `split_words:"true" default:"/root/.ssh/id_rsa"`
-	SSHIdentityPassphrase         string          `split_words:"true"`
-	SSHRemotePath                 string          `split_words:"true"`
-	ExecLabel                     string          `split_words:"true"`
-	ExecForwardOutput             bool            `split_words:"true"`
-	LockTimeout                   time.Duration   `split_words:"true" default:"60m"`
-	AzureStorageAccountName       string          `split_words:"true"`
-	AzureStoragePrimaryAccountKey string          `split_words:"true"`
-	AzureStorageConnectionString  string          `split_words:"true"`
-	AzureStorageContainerName     string          `split_words:"true"`
-	AzureStoragePath              string          `split_words:"true"`
-	AzureStorageEndpoint          string          `split_words:"true" default:"https://{{ .AccountName }}.blob.core.windows.net/"`
-	AzureStorageAccessTier        string          `split_words:"true"`
-	DropboxEndpoint               string          `split_words:"true" default:"https://api.dropbox.com/"`
-	DropboxOAuth2Endpoint         string          `envconfig:"DROPBOX_OAUTH2_ENDPOINT" default:"https://api.dropbox.com/"`
-	DropboxRefreshToken           string          `split_words:"true"`
-	DropboxAppKey                 string          `split_words:"true"`
-	DropboxAppSecret              string          `split_words:"true"`
-	DropboxRemotePath             string          `split_words:"true"`
-	DropboxConcurrencyLevel       NaturalNumber   `split_words:"true" default:"6"`
-	GoogleDriveCredentialsJSON    string          `split_words:"true"`
-	GoogleDriveFolderID           string          `split_words:"true"`
-	GoogleDriveImpersonateSubject string          `split_words:"true"`
-	GoogleDriveEndpoint           string          `split_words:"true"`
-	GoogleDriveTokenURL           string          `split_words:"true"`
-	source                        string
-	additionalEnvVars             map[string]string
+	BackupSuccessPolicy           SuccessPolicy   `split_words:"true" default:"all"`
+	BackupRetryAttempts           NaturalNumber   `split_words:"true" default:"1"`
+	BackupRetryBackoff            time.Duration   `split_words:"true" default:"5s"`
+	BackupRetryMaxBackoff         time.Duration   `split_words:"true" default:"2m"`
+	BackupUploadSessionsDirectory string          `split_words:"true"`
+	BackupUploadSessionsMaxAge    time.Duration   `split_words:"true" default:"24h"`
+
+	BackupUploadBandwidthLimit           BandwidthLimit `split_words:"true"`
+	BackupUploadBandwidthLimitPerBackend BandwidthLimit `split_words:"true"`
+	BackupUploadConcurrency              WholeNumber    `split_words:"true" default:"0"`
+
+	BackupVerifyUploads           bool          `split_words:"true"`
+	BackupHistoryFile             string        `split_words:"true" default:"/var/lib/docker-volume-backup/history.jsonl"`
+	BackupHistoryMaxEntries       WholeNumber   `split_words:"true" default:"1000"`
+	BackupMetricsTextfile         string        `split_words:"true"`
+	GpgPassphrase                 string        `split_words:"true"`
+	GpgPublicKeyRing              string        `split_words:"true"`
+	GpgPublicKeyRingFiles         []string      `split_words:"true"`
+	AgePassphrase                 string        `split_words:"true"`
+	AgePublicKeys                 []string      `split_words:"true"`
+	AgeRecipientsFiles            []string      `split_words:"true"`
+	VaultTransitAddress           string        `split_words:"true"`
+	VaultTransitToken             string        `split_words:"true"`
+	VaultTransitNamespace         string        `split_words:"true"`
+	VaultTransitMountPath         string        `split_words:"true" default:"transit"`
+	VaultTransitKeyName           string        `split_words:"true"`
+	BackupSigningKey              string        `split_words:"true"`
+	BackupSigningKeyPassphrase    string        `split_words:"true"`
+	NotificationURLs              []string      `envconfig:"NOTIFICATION_URLS"`
+	NotificationLevel             string        `split_words:"true" default:"error"`
+	NotificationEvents            []string      `split_words:"true"`
+	NotificationLockWaitThreshold time.Duration `split_words:"true" default:"5m"`
+	EmailNotificationRecipient    string        `split_words:"true"`
+	EmailNotificationSender       string        `split_words:"true" default:"noreply@nohost"`
+	EmailSMTPHost                 string        `envconfig:"EMAIL_SMTP_HOST"`
+	EmailSMTPPort                 int           `envconfig:"EMAIL_SMTP_PORT" default:"587"`
+	EmailSMTPUsername             string        `envconfig:"EMAIL_SMTP_USERNAME"`
+	EmailSMTPPassword             string        `envconfig:"EMAIL_SMTP_PASSWORD"`
+	ExecLabel                     string        `split_words:"true"`
+	ExecForwardOutput             bool          `split_words:"true"`
+	LockTimeout                   time.Duration `split_words:"true" default:"60m"`
+
+	Storages          []NamedStorageConfig `ignored:"true"`
+	source            string
+	name              string
+	additionalEnvVars map[string]string
+	dryRun            bool
+}
+
+// StorageConfig holds the configuration of storage backends. It is used for
+// the backends configured using unprefixed keys as well as for each named
+// backend instance.
+type StorageConfig struct {
+	AwsS3BucketName               string        `split_words:"true"`
+	AwsS3Path                     string        `split_words:"true"`
+	AwsEndpoint                   string        `split_words:"true" default:"s3.amazonaws.com"`
+	AwsEndpointProto              string        `split_words:"true" default:"https"`
+	AwsEndpointInsecure           bool          `split_words:"true"`
+	AwsEndpointCACert             CertDecoder   `envconfig:"AWS_ENDPOINT_CA_CERT"`
+	AwsStorageClass               string        `split_words:"true"`
+	AwsAccessKeyID                string        `envconfig:"AWS_ACCESS_KEY_ID"`
+	AwsSecretAccessKey            string        `split_words:"true"`
+	AwsIamRoleEndpoint            string        `split_words:"true"`
+	AwsSessionToken               string        `split_words:"true"`
+	AwsRoleArn                    string        `split_words:"true"`
+	AwsRoleSessionName            string        `split_words:"true"`
+	AwsRoleExternalID             string        `envconfig:"AWS_ROLE_EXTERNAL_ID"`
+	AwsWebIdentityTokenFile       string        `split_words:"true"`
+	AwsStsEndpoint                string        `split_words:"true"`
+	AwsStsRegion                  string        `split_words:"true" default:"us-east-1"`
+	AwsSharedCredentialsFile      string        `split_words:"true"`
+	AwsProfile                    string        `split_words:"true"`
+	AwsPartSize                   int64         `split_words:"true"`
+	AwsS3ServerSideEncryption     string        `split_words:"true"`
+	AwsS3SseKmsKeyID              string        `envconfig:"AWS_S3_SSE_KMS_KEY_ID"`
+	AwsS3SseCustomerKey           CustomerKey   `envconfig:"AWS_S3_SSE_CUSTOMER_KEY"`
+	AwsS3ObjectTags               TemplateMap   `split_words:"true"`
+	AwsS3ObjectMetadata           TemplateMap   `split_words:"true"`
+	AwsS3ObjectLockMode           string        `split_words:"true"`
+	AwsS3ObjectLockLegalHold      bool          `split_words:"true"`
+	BackupLatestSymlink           string        `split_words:"true"`
+	BackupArchive                 string        `split_words:"true" default:"/archive"`
+	WebdavUrl                     string        `split_words:"true"`
+	WebdavUrlInsecure             bool          `split_words:"true"`
+	WebdavPath                    string        `split_words:"true" default:"/"`
+	WebdavUsername                string        `split_words:"true"`
+	WebdavPassword                string        `split_words:"true"`
+	SSHHostName                   string        `split_words:"true"`
+	SSHPort                       string        `split_words:"true" default:"22"`
+	SSHUser                       string        `split_words:"true"`
+	SSHPassword                   string        `split_words:"true"`
+	SSHIdentityFile               string        `split_words:"true" default:"/root/.ssh/id_rsa"`
+	SSHIdentityPassphrase         string        `split_words:"true"`
+	SSHRemotePath                 string        `split_words:"true"`
+	SSHKnownHosts                 string        `split_words:"true"`
+	SSHHostKeyFingerprint         []string      `split_words:"true"`
+	SSHHostKeyTrustOnFirstUse     bool          `split_words:"true"`
+	SSHAuthSock                   string        `split_words:"true"`
+	SSHCertificateFile            string        `split_words:"true"`
+	SSHProxyJump                  string        `split_words:"true"`
+	AzureStorageAccountName       string        `split_words:"true"`
+	AzureStoragePrimaryAccountKey string        `split_words:"true"`
+	AzureStorageConnectionString  string        `split_words:"true"`
+	AzureStorageContainerName     string        `split_words:"true"`
+	AzureStoragePath              string        `split_words:"true"`
+	AzureStorageEndpoint          string        `split_words:"true" default:"https://{{ .AccountName }}.blob.core.windows.net/"`
+	AzureStorageAccessTier        string        `split_words:"true"`
+	AzureStorageImmutabilityMode  string        `split_words:"true"`
+	AzureStorageLegalHold         bool          `split_words:"true"`
+	DropboxEndpoint               string        `split_words:"true" default:"https://api.dropbox.com/"`
+	DropboxOAuth2Endpoint         string        `envconfig:"DROPBOX_OAUTH2_ENDPOINT" default:"https://api.dropbox.com/"`
+	DropboxRefreshToken           string        `split_words:"true"`
+	DropboxAppKey                 string        `split_words:"true"`
+	DropboxAppSecret              string        `split_words:"true"`
+	DropboxRemotePath             string        `split_words:"true"`
+	DropboxConcurrencyLevel       NaturalNumber `split_words:"true" default:"6"`
+	GoogleDriveCredentialsJSON    string        `split_words:"true"`
+	GoogleDriveFolderID           string        `split_words:"true"`
+	GoogleDriveImpersonateSubject string        `split_words:"true"`
+	GoogleDriveEndpoint           string        `split_words:"true"`
+	GoogleDriveTokenURL           string        `split_words:"true"`
+}
+
+// NamedStorageConfig configures a named instance of a storage backend of the
+// given type, allowing to use multiple backends of the same type.
+type NamedStorageConfig struct {
+	Name string      `ignored:"true"`
+	Type StorageType `required:"true"`
+	StorageConfig
+}
+
+// StorageType is a type that can be used to decode the type of a storage
+// backend.
+type StorageType string
+
+func (t *StorageType) Decode(v string) error {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if !slices.Contains(storageTypes, StorageType(v)) {
+		return errwrap.Wrap(nil, fmt.Sprintf("unknown storage type %s", v))
+	}
+	*t = StorageType(v)
+	return nil
 }
 
 type CompressionType string
@@ -112,6 +200,60 @@ func (c *CompressionType) String() string {
 	return string(*c)
 }
 
+// RunMode is a type that can be used to decode which phases of a backup run
+// are executed.
+type RunMode string
+
+const (
+	runModeAll    RunMode = "all"
+	runModeBackup RunMode = "backup"
+	runModePrune  RunMode = "prune"
+)
+
+func (m *RunMode) Decode(v string) error {
+	switch RunMode(v) {
+	case runModeAll, runModeBackup, runModePrune:
+		*m = RunMode(v)
+		return nil
+	default:
+		return errwrap.Wrap(nil, fmt.Sprintf("error decoding run mode %s, expected one of all, backup or prune", v))
+	}
+}
+
+// backsUp returns whether an archive is created and uploaded.
+func (m RunMode) backsUp() bool {
+	return m != runModePrune
+}
+
+// prunes returns whether existing backups are pruned.
+func (m RunMode) prunes() bool {
+	return m != runModeBackup
+}
+
+// checkRunMode returns an error in case the configured run mode would not
+// do anything.
+func (c *Config) checkRunMode() error {
+	if c.BackupRunMode == runModePrune && c.BackupRetentionDays < 0 {
+		return errwrap.Wrap(nil, "BACKUP_RUN_MODE prune requires BACKUP_RETENTION_DAYS to be set")
+	}
+	return nil
+}
+
+// checkRetention returns an error in case a storage backend is configured
+// to protect uploads for the retention period, but no retention period is
+// configured.
+func (c *Config) checkRetention() error {
+	if c.BackupRetentionDays > 0 {
+		return nil
+	}
+	for _, s := range c.configuredStorages() {
+		if (s.Type == "s3" && s.AwsS3ObjectLockMode != "") || (s.Type == "azure" && s.AzureStorageImmutabilityMode != "") {
+			return errwrap.Wrap(nil, "AWS_S3_OBJECT_LOCK_MODE and AZURE_STORAGE_IMMUTABILITY_MODE require BACKUP_RETENTION_DAYS to be greater than 0")
+		}
+	}
+	return nil
+}
+
 type CertDecoder struct {
 	Cert *x509.Certificate
 }
@@ -133,6 +275,86 @@ func (c *CertDecoder) Decode(v string) error {
 	return nil
 }
 
+func (c *CertDecoder) String() string {
+	if c.Cert == nil {
+		return ""
+	}
+	return c.Cert.Subject.String()
+}
+
+// CustomerKey is a base64 encoded 256 bit key.
+type CustomerKey []byte
+
+func (k *CustomerKey) Decode(v string) error {
+	if v == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return errwrap.Wrap(err, "error decoding customer key")
+	}
+	if len(key) != 32 {
+		return errwrap.Wrap(nil, fmt.Sprintf("customer key needs to be 32 bytes long, got %d", len(key)))
+	}
+	*k = key
+	return nil
+}
+
+func (k *CustomerKey) String() string {
+	if len(*k) == 0 {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(*k)
+}
+
+// TemplateMap is a comma separated list of `key=value` pairs whose values are
+// templates that are rendered for each run.
+type TemplateMap struct {
+	raw       string
+	templates map[string]*template.Template
+}
+
+func (m *TemplateMap) Decode(v string) error {
+	if v == "" {
+		return nil
+	}
+	templates := map[string]*template.Template{}
+	for _, pair := range strings.Split(v, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return errwrap.Wrap(nil, fmt.Sprintf("expected `key=value`, got `%s`", pair))
+		}
+		tmpl, err := template.New(key).Funcs(templateHelpers).Parse(strings.TrimSpace(value))
+		if err != nil {
+			return errwrap.Wrap(err, fmt.Sprintf("error parsing template for `%s`", key))
+		}
+		templates[key] = tmpl
+	}
+	*m = TemplateMap{raw: v, templates: templates}
+	return nil
+}
+
+func (m *TemplateMap) String() string {
+	return m.raw
+}
+
+// Execute renders all values using the given data.
+func (m *TemplateMap) Execute(data any) (map[string]string, error) {
+	if len(m.templates) == 0 {
+		return nil, nil
+	}
+	result := map[string]string{}
+	for key, tmpl := range m.templates {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, errwrap.Wrap(err, fmt.Sprintf("error rendering template for `%s`", key))
+		}
+		result[key] = buf.String()
+	}
+	return result, nil
+}
+
 type RegexpDecoder struct {
 	Re *regexp.Regexp
 }
@@ -149,6 +371,209 @@ func (r *RegexpDecoder) Decode(v string) error {
 	return nil
 }
 
+func (r *RegexpDecoder) String() string {
+	if r.Re == nil {
+		return ""
+	}
+	return r.Re.String()
+}
+
+// SuccessPolicy defines which storage backends need to succeed for a run to
+// be considered successful. The zero value requires all backends to succeed.
+type SuccessPolicy struct {
+	AtLeast  int
+	Required []string
+}
+
+func (p *SuccessPolicy) Decode(v string) error {
+	if v == "" || v == "all" {
+		*p = SuccessPolicy{}
+		return nil
+	}
+	if value, ok := strings.CutPrefix(v, "at-least:"); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return errwrap.Wrap(nil, fmt.Sprintf("expected a natural number in success policy %s", v))
+		}
+		*p = SuccessPolicy{AtLeast: n}
+		return nil
+	}
+	if value, ok := strings.CutPrefix(v, "required:"); ok {
+		var required []string
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				required = append(required, name)
+			}
+		}
+		if len(required) == 0 {
+			return errwrap.Wrap(nil, fmt.Sprintf("expected at least one backend in success policy %s", v))
+		}
+		*p = SuccessPolicy{Required: required}
+		return nil
+	}
+	return errwrap.Wrap(nil, fmt.Sprintf("error decoding success policy %s", v))
+}
+
+func (p *SuccessPolicy) String() string {
+	switch {
+	case p.AtLeast > 0:
+		return fmt.Sprintf("at-least:%d", p.AtLeast)
+	case len(p.Required) > 0:
+		return fmt.Sprintf("required:%s", strings.Join(p.Required, ","))
+	default:
+		return "all"
+	}
+}
+
+// Satisfied returns whether the policy is met when the given backends
+// out of all configured backends succeeded.
+func (p *SuccessPolicy) Satisfied(all, succeeded []string) bool {
+	switch {
+	case p.AtLeast > 0:
+		return len(succeeded) >= p.AtLeast
+	case len(p.Required) > 0:
+		for _, name := range p.Required {
+			if !slices.ContainsFunc(succeeded, func(s string) bool {
+				return strings.EqualFold(s, name)
+			}) {
+				return false
+			}
+		}
+		return true
+	default:
+		return len(succeeded) == len(all)
+	}
+}
+
+// BandwidthLimit is a type that can be used to decode a rate in bytes per
+// second, e.g. `10MiB`, optionally depending on the time of day, e.g.
+// `08:00-18:00=1MB,50MB`. A rate of zero means the bandwidth is not limited.
+type BandwidthLimit struct {
+	Default  uint64
+	Schedule []BandwidthWindow
+}
+
+// BandwidthWindow is a daily time window in which a different rate applies.
+// Windows that end before they start span midnight.
+type BandwidthWindow struct {
+	From, To time.Duration
+	Rate     uint64
+}
+
+func (b *BandwidthLimit) Decode(v string) error {
+	*b = BandwidthLimit{}
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		window, rate, isWindow := strings.Cut(entry, "=")
+		if !isWindow {
+			r, err := parseRate(entry)
+			if err != nil {
+				return err
+			}
+			b.Default = r
+			continue
+		}
+		from, to, ok := strings.Cut(window, "-")
+		if !ok {
+			return errwrap.Wrap(nil, fmt.Sprintf("expected a time window like 08:00-18:00, got %s", window))
+		}
+		var w BandwidthWindow
+		var err error
+		if w.From, err = parseTimeOfDay(from); err != nil {
+			return err
+		}
+		if w.To, err = parseTimeOfDay(to); err != nil {
+			return err
+		}
+		if w.Rate, err = parseRate(rate); err != nil {
+			return err
+		}
+		b.Schedule = append(b.Schedule, w)
+	}
+	return nil
+}
+
+// At returns the rate in bytes per second that applies at the given time.
+// The first matching window takes precedence.
+func (b *BandwidthLimit) At(t time.Time) uint64 {
+	h, m, sec := t.Clock()
+	now := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second
+	for _, w := range b.Schedule {
+		if w.From <= w.To && now >= w.From && now < w.To {
+			return w.Rate
+		}
+		if w.From > w.To && (now >= w.From || now < w.To) {
+			return w.Rate
+		}
+	}
+	return b.Default
+}
+
+func (b *BandwidthLimit) String() string {
+	var entries []string
+	for _, w := range b.Schedule {
+		entries = append(entries, fmt.Sprintf("%s-%s=%d", formatTimeOfDay(w.From), formatTimeOfDay(w.To), w.Rate))
+	}
+	if b.Default != 0 || len(entries) != 0 {
+		entries = append(entries, strconv.FormatUint(b.Default, 10))
+	}
+	return strings.Join(entries, ",")
+}
+
+// Limited returns whether the bandwidth is limited at any time.
+func (b *BandwidthLimit) Limited() bool {
+	if b.Default != 0 {
+		return true
+	}
+	for _, w := range b.Schedule {
+		if w.Rate != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+var rateUnits = []struct {
+	suffix string
+	factor uint64
+}{
+	// longer suffixes need to be checked first
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+func parseRate(v string) (uint64, error) {
+	number, factor := strings.TrimSpace(v), uint64(1)
+	for _, unit := range rateUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix))
+			factor = unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return 0, errwrap.Wrap(nil, fmt.Sprintf("expected a rate like 10MiB, got %s", v))
+	}
+	return n * factor, nil
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+func parseTimeOfDay(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(v))
+	if err != nil {
+		return 0, errwrap.Wrap(nil, fmt.Sprintf("expected a time of day like 08:00, got %s", v))
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
 // NaturalNumber is a type that can be used to decode a positive, non-zero natural number
 type NaturalNumber int
 
@@ -0,0 +1,310 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"github.com/offen/docker-volume-backup/internal/storage"
+	"github.com/offen/envconfig"
+)
+
+// runConfig implements the `config` subcommand which validates or prints
+// all available configurations.
+func (c *command) runConfig(args []string) error {
+	if len(args) == 0 {
+		return errwrap.Wrap(nil, "expected a subcommand, one of validate or show")
+	}
+	switch args[0] {
+	case "validate":
+		return c.runConfigValidate(args[1:])
+	case "show":
+		return c.runConfigShow(args[1:])
+	default:
+		return errwrap.Wrap(nil, fmt.Sprintf("unknown subcommand %s, expected one of validate or show", args[0]))
+	}
+}
+
+// runConfigValidate checks all available configurations for errors that
+// would otherwise only surface once a backup runs.
+func (c *command) runConfigValidate(args []string) error {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	name := fs.String("config", "", "only validate the configuration with the given name")
+	connect := fs.Bool("connect", false, "connect to each storage backend and check its destination can be accessed, SSH and Dropbox backends are only validated when connecting")
+	if err := fs.Parse(args); err != nil {
+		return errwrap.Wrap(err, "error parsing flags")
+	}
+
+	configurations, err := c.selectConfigurations(*name)
+	if err != nil {
+		return err
+	}
+
+	var invalid int
+	for _, config := range configurations {
+		problems := validateConfig(config, *connect)
+		if len(problems) == 0 {
+			fmt.Printf("%s (%s): OK\n", config.name, config.source)
+			continue
+		}
+		invalid++
+		fmt.Printf("%s (%s): %d problem(s)\n", config.name, config.source, len(problems))
+		for _, problem := range problems {
+			fmt.Printf("  - %v\n", errwrap.Unwrap(problem))
+		}
+	}
+	if invalid != 0 {
+		return errwrap.Wrap(nil, fmt.Sprintf("%d of %d configuration(s) are invalid", invalid, len(configurations)))
+	}
+	return nil
+}
+
+// validateConfig runs the validation that would be performed when running
+// a backup using the given configuration and returns all problems found.
+// Backends that connect to the remote when they are created are only
+// created when connect is true.
+func validateConfig(c *Config, connect bool) []error {
+	unset, err := c.applyEnv()
+	if err != nil {
+		return []error{errwrap.Wrap(err, "error applying env")}
+	}
+	defer func() {
+		_ = unset()
+	}()
+
+	s := newScript(c)
+	s.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var problems []error
+	if _, err := scheduleParser.Parse(c.BackupCronExpression); err != nil {
+		problems = append(problems, errwrap.Wrap(err, fmt.Sprintf("error parsing cron expression %s", c.BackupCronExpression)))
+	} else if !checkCronSchedule(c.BackupCronExpression) {
+		problems = append(problems, errwrap.Wrap(nil, fmt.Sprintf("cron expression %s will never run", c.BackupCronExpression)))
+	}
+	if err := s.resolveFile(); err != nil {
+		problems = append(problems, err)
+	}
+	if err := c.checkRunMode(); err != nil {
+		problems = append(problems, err)
+	}
+	if err := c.checkRetention(); err != nil {
+		problems = append(problems, err)
+	}
+	if _, _, err := s.newEncryptor(); err != nil {
+		problems = append(problems, err)
+	}
+	if _, _, err := s.newSigner(); err != nil {
+		problems = append(problems, err)
+	}
+	if err := s.initNotifications(); err != nil {
+		problems = append(problems, err)
+	}
+
+	var names []string
+	complete := true
+	for _, cfg := range c.configuredStorages() {
+		if !connect && slices.Contains(connectingStorageTypes, cfg.Type) {
+			complete = false
+			continue
+		}
+		backend, err := cfg.newStorageBackend(func(storage.LogLevel, string, string, ...any) {}, nil)
+		if err != nil {
+			problems = append(problems, err)
+			complete = false
+			continue
+		}
+		names = append(names, backend.Name())
+		if checkable, ok := backend.(storage.CheckableBackend); ok && connect {
+			if err := checkable.Check(); err != nil {
+				problems = append(problems, errwrap.Wrap(err, fmt.Sprintf("error checking storage backend %s", backend.Name())))
+			}
+		}
+	}
+	// Names of backends that have not been created are not known.
+	if complete {
+		for _, required := range c.BackupSuccessPolicy.Required {
+			if !slices.ContainsFunc(names, func(name string) bool { return strings.EqualFold(name, required) }) {
+				problems = append(problems, errwrap.Wrap(nil, fmt.Sprintf("success policy requires backend %s, which is not configured", required)))
+			}
+		}
+	}
+	return problems
+}
+
+// runConfigShow prints all available configurations with secrets masked.
+func (c *command) runConfigShow(args []string) error {
+	fs := flag.NewFlagSet("config show", flag.ContinueOnError)
+	name := fs.String("config", "", "only show the configuration with the given name")
+	if err := fs.Parse(args); err != nil {
+		return errwrap.Wrap(err, "error parsing flags")
+	}
+
+	configurations, err := c.selectConfigurations(*name)
+	if err != nil {
+		return err
+	}
+	for i, config := range configurations {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("# %s (%s)\n", config.name, config.source)
+		if err := writeConfig(os.Stdout, config); err != nil {
+			return errwrap.Wrap(err, fmt.Sprintf("error printing configuration %s", config.name))
+		}
+	}
+	return nil
+}
+
+// selectConfigurations returns all available configurations, or only the
+// one with the given name in case it is not empty.
+func (c *command) selectConfigurations(name string) ([]*Config, error) {
+	configurations, err := sourceConfiguration(configStrategyFile)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error sourcing configuration")
+	}
+	if name == "" {
+		return configurations, nil
+	}
+	for _, config := range configurations {
+		if config.name == name {
+			return []*Config{config}, nil
+		}
+	}
+	return nil, errwrap.Wrap(nil, fmt.Sprintf("unknown configuration %s", name))
+}
+
+// writeConfig writes all settings of the given configuration in the format
+// of an env file. Values of secrets are masked.
+func writeConfig(w io.Writer, c *Config) error {
+	values, err := configValues("", c)
+	if err != nil {
+		return err
+	}
+	for _, named := range c.Storages {
+		storageValues, err := configValues(fmt.Sprintf("STORAGE_%s", named.Name), &named)
+		if err != nil {
+			return err
+		}
+		values = append(values, storageValues...)
+	}
+	for _, v := range values {
+		if _, err := fmt.Fprintf(w, "%s=%q\n", v.key, redact(v.key, v.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type configValue struct {
+	key   string
+	value string
+}
+
+// configValues returns the keys and values of all settings of the given
+// specification, in the order they are defined in.
+func configValues(prefix string, spec any) ([]configValue, error) {
+	var values []configValue
+	tmpl, err := template.New("values").Funcs(template.FuncMap{
+		"collect": func(key string, field reflect.Value) string {
+			values = append(values, configValue{key: key, value: formatField(field)})
+			return ""
+		},
+	}).Parse(`{{ range . }}{{ collect .Key .Field }}{{ end }}`)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error parsing template")
+	}
+	if err := envconfig.Usaget(prefix, spec, io.Discard, tmpl); err != nil {
+		return nil, errwrap.Wrap(err, "error collecting values")
+	}
+	return values, nil
+}
+
+func formatField(field reflect.Value) string {
+	if field.CanAddr() {
+		if s, ok := field.Addr().Interface().(fmt.Stringer); ok {
+			return s.String()
+		}
+	}
+	if field.Kind() == reflect.Slice {
+		var items []string
+		for i := 0; i < field.Len(); i++ {
+			items = append(items, formatField(field.Index(i)))
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+var (
+	secretKey = regexp.MustCompile(`PASSWORD|PASSPHRASE|SECRET|TOKEN|CONNECTION_STRING|CREDENTIALS_JSON|ACCOUNT_KEY|ACCESS_KEY_ID|APP_KEY|SIGNING_KEY|CUSTOMER_KEY`)
+	urlKey    = regexp.MustCompile(`_URLS?$`)
+)
+
+const redacted = "******"
+
+// redact masks the value of the given key in case it is a secret. URLs are
+// stripped of credentials, and notification URLs are reduced to their
+// scheme.
+func redact(key, value string) string {
+	if value == "" {
+		return value
+	}
+	if secretKey.MatchString(key) {
+		return redacted
+	}
+	if key == "NOTIFICATION_URLS" {
+		var urls []string
+		for _, raw := range strings.Split(value, ",") {
+			urls = append(urls, redactNotificationURL(raw))
+		}
+		return strings.Join(urls, ",")
+	}
+	if urlKey.MatchString(key) {
+		var urls []string
+		for _, raw := range strings.Split(value, ",") {
+			urls = append(urls, redactURL(raw))
+		}
+		return strings.Join(urls, ",")
+	}
+	return value
+}
+
+// redactNotificationURL only keeps the scheme of the given URL, as services
+// keep secrets in any part of the URL, e.g. `gotify://host/token`.
+func redactNotificationURL(raw string) string {
+	scheme, _, ok := strings.Cut(strings.TrimSpace(raw), "://")
+	if !ok {
+		return redacted
+	}
+	return fmt.Sprintf("%s://%s", scheme, redacted)
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	query := u.Query()
+	for param := range query {
+		if secretKey.MatchString(strings.ToUpper(param)) {
+			query.Set(param, redacted)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,347 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"github.com/offen/envconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileLocations are the locations a structured configuration file is
+// looked up at, unless BACKUP_CONFIG_FILE is set.
+var configFileLocations = []string{
+	"/etc/dockervolumebackup/config.yml",
+	"/etc/dockervolumebackup/config.yaml",
+	"/etc/dockervolumebackup/config.toml",
+}
+
+// lookupConfigFile returns the location of the structured configuration
+// file in case one exists.
+func lookupConfigFile() (string, bool) {
+	if location, ok := os.LookupEnv("BACKUP_CONFIG_FILE"); ok {
+		return location, true
+	}
+	for _, location := range configFileLocations {
+		if _, err := os.Stat(location); err == nil {
+			return location, true
+		}
+	}
+	return "", false
+}
+
+// configSection describes how the keys in a section of a configuration file
+// translate into configuration keys. Keys are upper cased and prefixed,
+// unless an alias is defined.
+type configSection struct {
+	prefix  string
+	aliases map[string]string
+}
+
+var configSections = map[string]configSection{
+	"sources": {prefix: "BACKUP_", aliases: map[string]string{
+		"path":    "BACKUP_SOURCES",
+		"exclude": "BACKUP_EXCLUDE_REGEXP",
+	}},
+	"archive": {prefix: "BACKUP_", aliases: map[string]string{
+		"gzip_parallelism": "GZIP_PARALLELISM",
+	}},
+	"retention": {prefix: "BACKUP_", aliases: map[string]string{
+		"days":          "BACKUP_RETENTION_DAYS",
+		"skip_backends": "BACKUP_SKIP_BACKENDS_FROM_PRUNE",
+	}},
+	"encryption": {},
+	"notifications": {prefix: "NOTIFICATION_", aliases: map[string]string{
+		"urls": "NOTIFICATION_URLS",
+	}},
+}
+
+// jobAliases are the aliases of keys on the top level of a job, which
+// otherwise use the configuration keys in lower case.
+var jobAliases = map[string]string{
+	"schedule": "BACKUP_CRON_EXPRESSION",
+	"mode":     "BACKUP_RUN_MODE",
+}
+
+// storagePrefixes are the prefixes of the configuration keys of each type
+// of storage backend, which are omitted in configuration files.
+var storagePrefixes = map[StorageType]string{
+	"s3":          "AWS_",
+	"webdav":      "WEBDAV_",
+	"ssh":         "SSH_",
+	"local":       "BACKUP_",
+	"azure":       "AZURE_STORAGE_",
+	"dropbox":     "DROPBOX_",
+	"googledrive": "GOOGLE_DRIVE_",
+}
+
+// loadConfigsFromFile creates a config object for each job that is defined
+// in the given YAML or TOML file.
+func loadConfigsFromFile(location string) ([]*Config, error) {
+	b, err := os.ReadFile(location)
+	if err != nil {
+		return nil, errwrap.Wrap(err, fmt.Sprintf("error reading %s", location))
+	}
+
+	var doc map[string]any
+	switch filepath.Ext(location) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(b, &doc)
+	case ".toml":
+		err = toml.Unmarshal(b, &doc)
+	default:
+		return nil, errwrap.Wrap(nil, fmt.Sprintf("unsupported file type %s", filepath.Ext(location)))
+	}
+	if err != nil {
+		return nil, errwrap.Wrap(err, fmt.Sprintf("error parsing %s", location))
+	}
+
+	for key := range doc {
+		if key != "defaults" && key != "jobs" {
+			return nil, errwrap.Wrap(nil, fmt.Sprintf("unknown key %s, expected defaults or jobs", key))
+		}
+	}
+
+	known := configKeys()
+	storageKnown := storageKeys()
+	defaults := map[string]string{}
+	if doc["defaults"] != nil {
+		section, ok := doc["defaults"].(map[string]any)
+		if !ok {
+			return nil, errwrap.Wrap(nil, "expected defaults to be a map")
+		}
+		if _, ok := section["name"]; ok {
+			return nil, errwrap.Wrap(nil, "defaults cannot define a name")
+		}
+		if defaults, err = flattenJob(section, known, storageKnown); err != nil {
+			return nil, errwrap.Wrap(err, "error reading defaults")
+		}
+	}
+
+	jobs, err := asMaps(doc["jobs"])
+	if err != nil || len(jobs) == 0 {
+		return nil, errwrap.Wrap(err, "expected jobs to be a non-empty list")
+	}
+
+	var configs []*Config
+	var names []string
+	for i, job := range jobs {
+		name, ok := job["name"].(string)
+		if !ok || name == "" {
+			return nil, errwrap.Wrap(nil, fmt.Sprintf("job %d does not define a name", i+1))
+		}
+		if slices.Contains(names, name) {
+			return nil, errwrap.Wrap(nil, fmt.Sprintf("job %s is defined more than once", name))
+		}
+		names = append(names, name)
+
+		values, err := flattenJob(job, known, storageKnown)
+		if err != nil {
+			return nil, errwrap.Wrap(err, fmt.Sprintf("error reading job %s", name))
+		}
+		env := map[string]string{}
+		for key, value := range defaults {
+			env[key] = value
+		}
+		for key, value := range values {
+			env[key] = value
+		}
+
+		lookup := func(key string) (string, bool) {
+			if value, ok := env[key]; ok {
+				return value, true
+			}
+			return os.LookupEnv(key)
+		}
+		keys := environKeys()
+		for key := range env {
+			keys = append(keys, key)
+		}
+		c, err := loadConfig(lookup, keys)
+		if err != nil {
+			return nil, errwrap.Wrap(err, fmt.Sprintf("error loading job %s", name))
+		}
+		c.source = fmt.Sprintf("%s (job %s)", filepath.Base(location), name)
+		c.name = name
+		c.additionalEnvVars = env
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
+
+// flattenJob translates the given job into configuration keys and values.
+// Keys of backends are checked against the given storage keys only.
+func flattenJob(job map[string]any, known, storageKnown map[string]bool) (map[string]string, error) {
+	env := map[string]string{}
+	for key, value := range job {
+		switch section, isSection := configSections[key]; {
+		case key == "name":
+			continue
+		case key == "backends":
+			backends, err := asMaps(value)
+			if err != nil {
+				return nil, errwrap.Wrap(err, "expected backends to be a list")
+			}
+			if err := flattenBackends(backends, storageKnown, env); err != nil {
+				return nil, err
+			}
+		case isSection:
+			values, ok := value.(map[string]any)
+			if !ok {
+				return nil, errwrap.Wrap(nil, fmt.Sprintf("expected %s to be a map", key))
+			}
+			if err := flattenSection(values, section, known, env); err != nil {
+				return nil, errwrap.Wrap(err, fmt.Sprintf("error reading %s", key))
+			}
+		default:
+			if err := flattenSection(map[string]any{key: value}, configSection{aliases: jobAliases}, known, env); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return env, nil
+}
+
+// flattenBackends translates the given list of storage backends into
+// configuration keys. Backends without a name configure the default backend
+// of their type, all others are named backend instances.
+func flattenBackends(backends []map[string]any, known map[string]bool, env map[string]string) error {
+	var unnamed []StorageType
+	for i, backend := range backends {
+		var t StorageType
+		rawType, _ := backend["type"].(string)
+		if err := t.Decode(rawType); err != nil {
+			return errwrap.Wrap(err, fmt.Sprintf("error reading type of backend %d", i+1))
+		}
+
+		values := map[string]any{}
+		for key, value := range backend {
+			if key != "type" && key != "name" {
+				values[key] = value
+			}
+		}
+
+		name, _ := backend["name"].(string)
+		if name == "" {
+			if slices.Contains(unnamed, t) {
+				return errwrap.Wrap(nil, fmt.Sprintf("backends of type %s need to be given a name when using more than one", t))
+			}
+			unnamed = append(unnamed, t)
+			if err := flattenSection(values, configSection{prefix: storagePrefixes[t]}, known, env); err != nil {
+				return errwrap.Wrap(err, fmt.Sprintf("error reading backend %d", i+1))
+			}
+			continue
+		}
+
+		prefixed := map[string]string{}
+		if err := flattenSection(values, configSection{prefix: storagePrefixes[t]}, known, prefixed); err != nil {
+			return errwrap.Wrap(err, fmt.Sprintf("error reading backend %s", name))
+		}
+		prefix := fmt.Sprintf("STORAGE_%s_", strings.ToUpper(name))
+		env[prefix+"TYPE"] = string(t)
+		for key, value := range prefixed {
+			env[prefix+key] = value
+		}
+	}
+	return nil
+}
+
+// flattenSection translates the given values into configuration keys using
+// the given section, adding them to env.
+func flattenSection(values map[string]any, section configSection, known map[string]bool, env map[string]string) error {
+	for key, value := range values {
+		name, isFile := strings.CutSuffix(key, "_file")
+		envKey, ok := section.aliases[name]
+		if !ok {
+			envKey = section.prefix + strings.ToUpper(name)
+		}
+		if !known[envKey] {
+			return errwrap.Wrap(nil, fmt.Sprintf("unknown key %s", key))
+		}
+		if isFile {
+			envKey += "_FILE"
+		}
+		formatted, err := formatValue(value)
+		if err != nil {
+			return errwrap.Wrap(err, fmt.Sprintf("error reading %s", key))
+		}
+		env[envKey] = formatted
+	}
+	return nil
+}
+
+// formatValue formats the given value the way it would be passed in an
+// environment variable.
+func formatValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []any:
+		var items []string
+		for _, item := range v {
+			formatted, err := formatValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, formatted)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		return "", errwrap.Wrap(nil, "unexpected nested map")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// asMaps converts the given list of maps as decoded from YAML or TOML.
+func asMaps(value any) ([]map[string]any, error) {
+	switch v := value.(type) {
+	case []map[string]any:
+		return v, nil
+	case []any:
+		var result []map[string]any
+		for _, item := range v {
+			m, ok := item.(map[string]any)
+			if !ok {
+				return nil, errwrap.Wrap(nil, "expected a list of maps")
+			}
+			result = append(result, m)
+		}
+		return result, nil
+	default:
+		return nil, errwrap.Wrap(nil, "expected a list of maps")
+	}
+}
+
+// configKeys returns all keys that are used when loading a configuration.
+func configKeys() map[string]bool {
+	return keysOf(&Config{})
+}
+
+// storageKeys returns all keys that are used when loading the configuration
+// of storage backends.
+func storageKeys() map[string]bool {
+	return keysOf(&StorageConfig{})
+}
+
+// keysOf returns all keys that are looked up when processing the given
+// specification.
+func keysOf(spec any) map[string]bool {
+	keys := map[string]bool{}
+	envconfig.Lookup = func(key string) (string, bool) {
+		keys[key] = true
+		return "", false
+	}
+	_ = envconfig.Process("", spec)
+	return keys
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigsFromFile(t *testing.T) {
+	t.Run("yaml", func(t *testing.T) {
+		configs, err := loadConfigsFromFile("testdata/config.yml")
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if len(configs) != 2 {
+			t.Fatalf("Expected 2 configurations, got %d", len(configs))
+		}
+
+		app, db := configs[0], configs[1]
+		if app.name != "app" || db.name != "db" {
+			t.Errorf("Unexpected names %s and %s", app.name, db.name)
+		}
+		if app.BackupCronExpression != "0 2 * * *" || db.BackupCronExpression != "@daily" {
+			t.Errorf("Unexpected schedules %s and %s", app.BackupCronExpression, db.BackupCronExpression)
+		}
+		if app.BackupSources != "/backup/app" || app.BackupExcludeRegexp.Re.String() != `\.log$` || app.BackupCompression != "zst" {
+			t.Errorf("Unexpected sources %#v", app)
+		}
+		if app.BackupRetentionDays != 7 || db.BackupRetentionDays != 30 {
+			t.Errorf("Unexpected retention %d and %d", app.BackupRetentionDays, db.BackupRetentionDays)
+		}
+		if !reflect.DeepEqual(app.NotificationURLs, []string{"generic://example.com", "generic://example.org"}) || app.NotificationLevel != "info" {
+			t.Errorf("Unexpected notifications %v %s", app.NotificationURLs, app.NotificationLevel)
+		}
+		if !reflect.DeepEqual(app.AgePublicKeys, []string{"age1xyz", "age1abc"}) {
+			t.Errorf("Unexpected age keys %v", app.AgePublicKeys)
+		}
+		if app.AwsS3BucketName != "bucket" || app.AwsAccessKeyID != "key" || app.AwsSecretAccessKey != "secret" {
+			t.Errorf("Unexpected s3 configuration %#v", app.StorageConfig)
+		}
+		if len(app.Storages) != 1 || app.Storages[0].Name != "EU" || app.Storages[0].AwsS3BucketName != "bucket-eu" {
+			t.Errorf("Unexpected named storages %#v", app.Storages)
+		}
+		if app.LockTimeout != 10*time.Minute {
+			t.Errorf("Unexpected lock timeout %v", app.LockTimeout)
+		}
+		if db.AwsS3BucketName != "" || len(db.Storages) != 0 {
+			t.Errorf("Expected backends not to leak into other jobs, got %#v", db.StorageConfig)
+		}
+	})
+
+	t.Run("toml", func(t *testing.T) {
+		configs, err := loadConfigsFromFile("testdata/config.toml")
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if len(configs) != 1 {
+			t.Fatalf("Expected 1 configuration, got %d", len(configs))
+		}
+		c := configs[0]
+		if c.BackupSources != "/backup/app" || c.BackupRetentionDays != 7 {
+			t.Errorf("Unexpected configuration %#v", c)
+		}
+		if len(c.Storages) != 1 || c.Storages[0].Type != "ssh" || c.Storages[0].SSHHostName != "offsite.example.com" || c.Storages[0].SSHPort != "2222" {
+			t.Errorf("Unexpected named storages %#v", c.Storages)
+		}
+	})
+
+	invalid := []struct {
+		name     string
+		contents string
+	}{
+		{"unknown top level key", "jobs: [{name: a}]\nfoo: bar\n"},
+		{"no jobs", "defaults: {}\n"},
+		{"missing name", "jobs: [{schedule: '@daily'}]\n"},
+		{"duplicate name", "jobs: [{name: a}, {name: a}]\n"},
+		{"unknown key", "jobs: [{name: a, retention: {weeks: 2}}]\n"},
+		{"unknown backend key", "jobs: [{name: a, backends: [{type: s3, bucket: b}]}]\n"},
+		{"non storage key in backend", "jobs: [{name: a, backends: [{type: local, cron_expression: '@daily'}]}]\n"},
+		{"non storage key in named backend", "jobs: [{name: a, backends: [{type: local, name: b, retention_days: 7}]}]\n"},
+		{"unknown backend type", "jobs: [{name: a, backends: [{type: tape}]}]\n"},
+		{"duplicate unnamed backend", "jobs: [{name: a, backends: [{type: s3}, {type: s3}]}]\n"},
+		{"nested value", "jobs: [{name: a, sources: {path: {a: b}}}]\n"},
+	}
+	for _, test := range invalid {
+		t.Run(test.name, func(t *testing.T) {
+			location := filepath.Join(t.TempDir(), "config.yml")
+			if err := os.WriteFile(location, []byte(test.contents), 0644); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if _, err := loadConfigsFromFile(location); err == nil {
+				t.Error("Expected an error, got nil")
+			}
+		})
+	}
+}
@@ -8,6 +8,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/joho/godotenv"
@@ -21,8 +24,11 @@ type configStrategy string
 const (
 	configStrategyEnv   configStrategy = "env"
 	configStrategyConfd configStrategy = "confd"
+	configStrategyFile  configStrategy = "file"
 )
 
+const confdDirectory = "/etc/dockervolumebackup/conf.d"
+
 // sourceConfiguration returns a list of config objects using the given
 // strategy. It should be the single entrypoint for retrieving configuration
 // for all consumers.
@@ -32,7 +38,7 @@ func sourceConfiguration(strategy configStrategy) ([]*Config, error) {
 		c, err := loadConfigFromEnvVars()
 		return []*Config{c}, err
 	case configStrategyConfd:
-		cs, err := loadConfigsFromEnvFiles("/etc/dockervolumebackup/conf.d")
+		cs, err := loadConfigsFromEnvFiles(confdDirectory)
 		if err != nil {
 			if os.IsNotExist(err) {
 				return sourceConfiguration(configStrategyEnv)
@@ -40,6 +46,16 @@ func sourceConfiguration(strategy configStrategy) ([]*Config, error) {
 			return nil, errwrap.Wrap(err, "error loading config files")
 		}
 		return cs, nil
+	case configStrategyFile:
+		location, ok := lookupConfigFile()
+		if !ok {
+			return sourceConfiguration(configStrategyConfd)
+		}
+		cs, err := loadConfigsFromFile(location)
+		if err != nil {
+			return nil, errwrap.Wrap(err, fmt.Sprintf("error loading config file %s", location))
+		}
+		return cs, nil
 	default:
 		return nil, errwrap.Wrap(nil, fmt.Sprintf("received unknown config strategy: %v", strategy))
 	}
@@ -48,9 +64,29 @@ func sourceConfiguration(strategy configStrategy) ([]*Config, error) {
 // envProxy is a function that mimics os.LookupEnv but can read values from any other source
 type envProxy func(string) (string, bool)
 
-// loadConfig creates a config object using the given lookup function
-func loadConfig(lookup envProxy) (*Config, error) {
-	envconfig.Lookup = func(key string) (string, bool) {
+// loadConfig creates a config object using the given lookup function. The
+// given keys are all keys that are available for lookup.
+func loadConfig(lookup envProxy, keys []string) (*Config, error) {
+	envconfig.Lookup = withFileLookup(lookup)
+
+	var c = &Config{}
+	if err := envconfig.Process("", c); err != nil {
+		return nil, errwrap.Wrap(err, "failed to process configuration values")
+	}
+
+	storages, err := loadNamedStorages(lookup, keys)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "failed to process storage backend instances")
+	}
+	c.Storages = storages
+
+	return c, nil
+}
+
+// withFileLookup returns a lookup function that also resolves keys by
+// reading the file given in the respective `_FILE` key.
+func withFileLookup(lookup envProxy) envProxy {
+	return func(key string) (string, bool) {
 		value, okValue := lookup(key)
 		location, okFile := lookup(key + "_FILE")
 
@@ -69,21 +105,69 @@ func loadConfig(lookup envProxy) (*Config, error) {
 			return "", false
 		}
 	}
+}
 
-	var c = &Config{}
-	if err := envconfig.Process("", c); err != nil {
-		return nil, errwrap.Wrap(err, "failed to process configuration values")
+var storageTypeKey = regexp.MustCompile(`^STORAGE_(.+)_TYPE$`)
+
+// loadNamedStorages creates a configuration for each named storage backend
+// instance that is declared using a key like `STORAGE_<NAME>_TYPE`. All
+// other settings of the instance use the same keys as unnamed backends,
+// prefixed with `STORAGE_<NAME>_`.
+func loadNamedStorages(lookup envProxy, keys []string) ([]NamedStorageConfig, error) {
+	var names []string
+	for _, key := range keys {
+		match := storageTypeKey.FindStringSubmatch(key)
+		if match == nil || slices.Contains(names, match[1]) {
+			continue
+		}
+		name := match[1]
+		if slices.Contains(storageTypes, StorageType(strings.ToLower(name))) {
+			return nil, errwrap.Wrap(nil, fmt.Sprintf("storage backend instance %s clashes with the name of a storage type", name))
+		}
+		if slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, name) }) {
+			return nil, errwrap.Wrap(nil, fmt.Sprintf("storage backend instance %s is declared more than once", name))
+		}
+		names = append(names, name)
 	}
+	sort.Strings(names)
+
+	var storages []NamedStorageConfig
+	for _, name := range names {
+		prefix := fmt.Sprintf("STORAGE_%s", name)
+		// Settings of unnamed backends must not leak into named instances,
+		// which could happen for keys that envconfig looks up without prefix.
+		envconfig.Lookup = withFileLookup(func(key string) (string, bool) {
+			if !strings.HasPrefix(key, prefix+"_") {
+				return "", false
+			}
+			return lookup(key)
+		})
+		storage := NamedStorageConfig{Name: name}
+		if err := envconfig.Process(prefix, &storage); err != nil {
+			return nil, errwrap.Wrap(err, fmt.Sprintf("failed to process configuration of storage backend instance %s", name))
+		}
+		storages = append(storages, storage)
+	}
+	return storages, nil
+}
 
-	return c, nil
+// environKeys returns the keys of all environment variables.
+func environKeys() []string {
+	var keys []string
+	for _, env := range os.Environ() {
+		key, _, _ := strings.Cut(env, "=")
+		keys = append(keys, key)
+	}
+	return keys
 }
 
 func loadConfigFromEnvVars() (*Config, error) {
-	c, err := loadConfig(os.LookupEnv)
+	c, err := loadConfig(os.LookupEnv, environKeys())
 	if err != nil {
 		return nil, errwrap.Wrap(err, "error loading config from environment")
 	}
 	c.source = "from environment"
+	c.name = "default"
 	return c, nil
 }
 
@@ -113,11 +197,16 @@ func loadConfigsFromEnvFiles(directory string) ([]*Config, error) {
 			}
 			return os.LookupEnv(key)
 		}
-		c, err := loadConfig(lookup)
+		keys := environKeys()
+		for key := range envFile {
+			keys = append(keys, key)
+		}
+		c, err := loadConfig(lookup, keys)
 		if err != nil {
 			return nil, errwrap.Wrap(err, fmt.Sprintf("error loading config from file %s", p))
 		}
 		c.source = item.Name()
+		c.name = strings.TrimSuffix(item.Name(), filepath.Ext(item.Name()))
 		c.additionalEnvVars = envFile
 		configs = append(configs, c)
 	}
@@ -77,3 +77,91 @@ func TestSource(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadNamedStorages(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           map[string]string
+		expectError   bool
+		expectedNames []string
+		check         func(t *testing.T, storages []NamedStorageConfig)
+	}{
+		{
+			"no instances",
+			map[string]string{"AWS_S3_BUCKET_NAME": "bucket"},
+			false,
+			nil,
+			nil,
+		},
+		{
+			"multiple instances",
+			map[string]string{
+				"AWS_ACCESS_KEY_ID":                   "default",
+				"STORAGE_US_TYPE":                     "S3",
+				"STORAGE_US_AWS_S3_BUCKET_NAME":       "us-bucket",
+				"STORAGE_EU_WEST_TYPE":                "s3",
+				"STORAGE_EU_WEST_AWS_S3_BUCKET_NAME":  "eu-bucket",
+				"STORAGE_EU_WEST_AWS_ACCESS_KEY_ID":   "eu",
+				"STORAGE_BACKUP_HOST_TYPE":            "ssh",
+				"STORAGE_BACKUP_HOST_SSH_HOST_NAME":   "backup.example.com",
+				"STORAGE_BACKUP_HOST_SSH_REMOTE_PATH": "/data",
+				"STORAGE_BACKUP_HOST_SSH_PORT":        "2222",
+				"STORAGE_BACKUP_HOST_BACKUP_ARCHIVE":  "/nas",
+			},
+			false,
+			[]string{"BACKUP_HOST", "EU_WEST", "US"},
+			func(t *testing.T, storages []NamedStorageConfig) {
+				host, eu, us := storages[0], storages[1], storages[2]
+				if host.Type != "ssh" || host.SSHHostName != "backup.example.com" || host.SSHPort != "2222" || host.BackupArchive != "/nas" {
+					t.Errorf("Unexpected ssh instance %#v", host)
+				}
+				if eu.Type != "s3" || eu.AwsS3BucketName != "eu-bucket" || eu.AwsAccessKeyID != "eu" {
+					t.Errorf("Unexpected s3 instance %#v", eu)
+				}
+				if us.AwsS3BucketName != "us-bucket" || us.AwsAccessKeyID != "" || us.AwsEndpoint != "s3.amazonaws.com" {
+					t.Errorf("Expected defaults but no unprefixed values, got %#v", us)
+				}
+			},
+		},
+		{
+			"unknown type",
+			map[string]string{"STORAGE_US_TYPE": "tape"},
+			true,
+			nil,
+			nil,
+		},
+		{
+			"name of a type",
+			map[string]string{"STORAGE_S3_TYPE": "s3"},
+			true,
+			nil,
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var keys []string
+			for key := range test.env {
+				keys = append(keys, key)
+			}
+			storages, err := loadNamedStorages(func(key string) (string, bool) {
+				v, ok := test.env[key]
+				return v, ok
+			}, keys)
+			if (err != nil) != test.expectError {
+				t.Fatalf("Unexpected error value %v", err)
+			}
+			var names []string
+			for _, storage := range storages {
+				names = append(names, storage.Name)
+			}
+			if !reflect.DeepEqual(test.expectedNames, names) {
+				t.Fatalf("Expected names %v, got %v", test.expectedNames, names)
+			}
+			if test.check != nil {
+				test.check(t, storages)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+func TestSuccessPolicy(t *testing.T) {
+	all := []string{"S3", "WebDAV", "SSH"}
+	tests := []struct {
+		name        string
+		value       string
+		succeeded   []string
+		expectError bool
+		expected    bool
+	}{
+		{"all succeeded", "all", all, false, true},
+		{"all with failure", "all", []string{"S3", "SSH"}, false, false},
+		{"default", "", []string{"S3"}, false, false},
+		{"at least satisfied", "at-least:2", []string{"S3", "SSH"}, false, true},
+		{"at least not satisfied", "at-least:2", []string{"SSH"}, false, false},
+		{"required satisfied", "required:s3, ssh", []string{"S3", "SSH"}, false, true},
+		{"required not satisfied", "required:S3,WebDAV", []string{"S3", "SSH"}, false, false},
+		{"invalid number", "at-least:0", nil, true, false},
+		{"empty required", "required:", nil, true, false},
+		{"unknown policy", "some", nil, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var p SuccessPolicy
+			err := p.Decode(test.value)
+			if (err != nil) != test.expectError {
+				t.Fatalf("Unexpected error value %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if result := p.Satisfied(all, test.succeeded); result != test.expected {
+				t.Errorf("Expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestBandwidthLimit(t *testing.T) {
+	at := func(clock string) time.Time {
+		t, _ := time.Parse("15:04", clock)
+		return t
+	}
+	tests := []struct {
+		name        string
+		value       string
+		expectError bool
+		expected    map[string]uint64
+	}{
+		{"empty", "", false, map[string]uint64{"12:00": 0}},
+		{"plain bytes", "2048", false, map[string]uint64{"12:00": 2048}},
+		{"binary unit", "10MiB", false, map[string]uint64{"03:00": 10 << 20}},
+		{"decimal unit", "5 KB", false, map[string]uint64{"03:00": 5000}},
+		{
+			"schedule with default", "08:00-18:00=1MB, 50MB", false,
+			map[string]uint64{"07:59": 50000000, "08:00": 1000000, "17:59": 1000000, "18:00": 50000000},
+		},
+		{
+			"window spanning midnight", "22:00-06:00=0,1KiB", false,
+			map[string]uint64{"23:00": 0, "05:00": 0, "12:00": 1024},
+		},
+		{"invalid rate", "fast", true, nil},
+		{"invalid unit", "10TB", true, nil},
+		{"invalid window", "08:00=1MB", true, nil},
+		{"invalid time", "8am-6pm=1MB", true, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b BandwidthLimit
+			err := b.Decode(test.value)
+			if (err != nil) != test.expectError {
+				t.Fatalf("Unexpected error value %v", err)
+			}
+			for clock, expected := range test.expected {
+				if result := b.At(at(clock)); result != expected {
+					t.Errorf("Expected %d at %s, got %d", expected, clock, result)
+				}
+			}
+		})
+	}
+}
+
+func TestTemplateMap(t *testing.T) {
+	data := uploadTemplateData{
+		ConfigName: "daily",
+		Hostname:   "host",
+		Stats:      &Stats{BackupFile: BackupFileStats{Name: "backup.tar.gz"}},
+	}
+	tests := []struct {
+		name        string
+		value       string
+		expectError bool
+		expected    map[string]string
+	}{
+		{"empty", "", false, nil},
+		{"static", "retention=30d", false, map[string]string{"retention": "30d"}},
+		{
+			"templates",
+			"config={{ .ConfigName }}, host = {{ .Hostname }},file={{ .Stats.BackupFile.Name }}",
+			false,
+			map[string]string{"config": "daily", "host": "host", "file": "backup.tar.gz"},
+		},
+		{"empty value", "retention=", false, map[string]string{"retention": ""}},
+		{"missing value", "retention", true, nil},
+		{"missing key", "=30d", true, nil},
+		{"invalid template", "config={{ .ConfigName", true, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var m TemplateMap
+			err := m.Decode(test.value)
+			if (err != nil) != test.expectError {
+				t.Fatalf("Unexpected error value %v", err)
+			}
+			if err != nil {
+				return
+			}
+			result, err := m.Execute(data)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if !maps.Equal(result, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
@@ -4,17 +4,38 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"os"
 	"path"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/offen/docker-volume-backup/internal/errwrap"
-	"golang.org/x/sync/errgroup"
+	"github.com/offen/docker-volume-backup/internal/storage"
 )
 
 // copyArchive makes sure the backup file is copied to both local and remote locations
 // as per the given configuration.
 func (s *script) copyArchive() error {
 	_, name := path.Split(s.file)
+	if s.c.dryRun {
+		for _, backend := range s.storages {
+			s.logger.Info(
+				fmt.Sprintf("Would upload `%s` to backend %s at %s.", name, backend.Name(), s.destinations[backend.Name()]),
+			)
+			for _, companion := range s.companionFiles {
+				s.logger.Info(
+					fmt.Sprintf("Would upload `%s` to backend %s.", path.Base(companion), backend.Name()),
+				)
+			}
+		}
+		return nil
+	}
 	if stat, err := os.Stat(s.file); err != nil {
 		return errwrap.Wrap(err, "unable to stat backup file")
 	} else {
@@ -26,16 +47,249 @@ func (s *script) copyArchive() error {
 		}
 	}
 
-	eg := errgroup.Group{}
+	pending, err := s.loadPendingUploads()
+	if err != nil {
+		return errwrap.Wrap(err, "error loading pending uploads")
+	}
+	// resumed holds the names of the backends each pending upload has been
+	// completed for.
+	resumed := make([][]string, len(pending))
+
+	// Templates are rendered while uploads update stats concurrently, so
+	// digests are computed upfront instead of while holding the lock.
+	if s.c.usesUploadTemplates() {
+		files := append([]string{s.file}, s.companionFiles...)
+		for _, upload := range pending {
+			files = append(files, upload.files()...)
+		}
+		for _, file := range files {
+			digest, err := storage.Digest(file, sha256.New())
+			if err != nil {
+				return errwrap.Wrap(err, fmt.Sprintf("error computing checksum of %s", path.Base(file)))
+			}
+			s.digests[file] = hex.EncodeToString(digest)
+		}
+	}
+
+	// Uploads happen concurrently and each backend is given the chance to
+	// finish, so the success policy can be applied to the outcomes.
+	var wg sync.WaitGroup
+	failed := map[string]error{}
+	throttled := s.c.BackupUploadBandwidthLimit.Limited() || s.c.BackupUploadBandwidthLimitPerBackend.Limited()
+	global := newThrottle(s.c.BackupUploadBandwidthLimit)
+	// A nil channel never blocks, so uploads are only limited when configured.
+	var slots chan struct{}
+	if n := s.c.BackupUploadConcurrency.Int(); n > 0 {
+		slots = make(chan struct{}, n)
+	}
 	for _, backend := range s.storages {
 		b := backend
-		eg.Go(func() error {
-			return b.Copy(s.file)
+		// Backends that report progress count the bytes actually sent, others
+		// are assumed to have sent the entire file once they succeed. The
+		// counter is reset before each attempt at uploading the backup file,
+		// so retries, companion files and resumed uploads are not included.
+		var sent atomic.Uint64
+		observable, ok := b.(storage.ObservableBackend)
+		if ok {
+			observable.ObserveReads(func(n int) {
+				sent.Add(uint64(n))
+			})
+			if throttled {
+				perBackend := newThrottle(s.c.BackupUploadBandwidthLimitPerBackend)
+				observable.LimitReadSize(throttleReadSize)
+				observable.ObserveReads(func(n int) {
+					perBackend.wait(n)
+					global.wait(n)
+				})
+			}
+		} else if throttled {
+			s.logger.Warn(fmt.Sprintf("Backend %s does not support limiting bandwidth, uploading without limit.", b.Name()))
+		}
+		wg.Go(func() {
+			if slots != nil {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+			}
+			for i, upload := range pending {
+				if !slices.Contains(upload.Backends, b.Name()) {
+					continue
+				}
+				if err := s.resumePendingUpload(b, upload); err != nil {
+					s.logger.Warn(
+						fmt.Sprintf("Unable to resume upload of `%s` to backend %s: %v", path.Base(upload.File), b.Name(), errwrap.Unwrap(err)),
+					)
+					continue
+				}
+				s.stats.Lock()
+				resumed[i] = append(resumed[i], b.Name())
+				s.stats.Unlock()
+			}
+
+			var verification *storage.Verification
+			var duration time.Duration
+			var sentBytes uint64
+			attempts, err := s.withRetry(b, "upload", func() error {
+				// Companion files are uploaded first so that backends that
+				// link to the latest upload link to the backup file.
+				for _, companion := range s.companionFiles {
+					if err := b.Copy(companion); err != nil {
+						return errwrap.Wrap(err, fmt.Sprintf("error uploading %s", path.Base(companion)))
+					}
+					if _, err := s.verifyUpload(b, companion); err != nil {
+						return errwrap.Wrap(err, fmt.Sprintf("error verifying %s", path.Base(companion)))
+					}
+				}
+				sent.Store(0)
+				start := time.Now()
+				if err := b.Copy(s.file); err != nil {
+					return err
+				}
+				duration, sentBytes = time.Since(start), sent.Load()
+				var err error
+				verification, err = s.verifyUpload(b, s.file)
+				return err
+			})
+			s.stats.Lock()
+			defer s.stats.Unlock()
+			stats := s.stats.Storages[b.Name()]
+			stats.Uploaded = err == nil
+			stats.UploadAttempts = attempts
+			if verification != nil {
+				stats.Verified = true
+				stats.VerifyMethod = verification.Method
+			}
+			if err != nil {
+				stats.UploadError = err.Error()
+				failed[b.Name()] = err
+			} else {
+				stats.UploadDuration = duration
+				stats.UploadBytes = sentBytes
+				if !ok {
+					stats.UploadBytes = s.stats.BackupFile.Size
+				}
+				if duration > 0 {
+					stats.UploadThroughput = uint64(float64(stats.UploadBytes) / duration.Seconds())
+				}
+			}
+			s.stats.Storages[b.Name()] = stats
 		})
 	}
-	if err := eg.Wait(); err != nil {
+	wg.Wait()
+
+	var failedNames []string
+	var errs []error
+	for _, backend := range s.storages {
+		if err, ok := failed[backend.Name()]; ok {
+			failedNames = append(failedNames, backend.Name())
+			errs = append(errs, errwrap.Wrap(err, backend.Name()))
+		}
+	}
+	if len(failed) != 0 && len(failed) < len(s.storages) {
+		s.notifyEvent(notificationEventBackendFailure, errors.Join(errs...), failedNames...)
+	}
+	if s.c.BackupUploadSessionsDirectory != "" {
+		for i := range pending {
+			pending[i].Backends = slices.DeleteFunc(pending[i].Backends, func(name string) bool {
+				return slices.Contains(resumed[i], name)
+			})
+		}
+		if len(failed) != 0 {
+			upload, err := s.keepPendingUpload(failedNames)
+			if err != nil {
+				s.logger.Warn(fmt.Sprintf("Unable to keep backup for resuming its upload in the next run: %v", errwrap.Unwrap(err)))
+				s.removePendingFiles(upload)
+			} else {
+				s.logger.Info(fmt.Sprintf("Kept `%s` for resuming its upload in the next run.", name))
+				pending = append(pending, upload)
+			}
+		}
+		if err := s.storePendingUploads(pending); err != nil {
+			s.logger.Warn(fmt.Sprintf("Unable to store pending uploads: %v", errwrap.Unwrap(err)))
+		}
+	}
+	if err := s.applySuccessPolicy(failed); err != nil {
 		return errwrap.Wrap(err, "error copying archive")
 	}
 
 	return nil
 }
+
+// resumePendingUpload uploads the files of an upload of a previous run to
+// the given backend, which continues where the previous run stopped.
+func (s *script) resumePendingUpload(b storage.Backend, upload pendingUpload) error {
+	_, err := s.withRetry(b, "upload", func() error {
+		for _, file := range upload.files() {
+			if err := b.Copy(file); err != nil {
+				return errwrap.Wrap(err, fmt.Sprintf("error uploading %s", path.Base(file)))
+			}
+			if _, err := s.verifyUpload(b, file); err != nil {
+				return errwrap.Wrap(err, fmt.Sprintf("error verifying %s", path.Base(file)))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.logger.Info(fmt.Sprintf("Resumed upload of `%s` of a previous run to backend %s.", path.Base(upload.File), b.Name()))
+	return nil
+}
+
+// verifyUpload makes the given backend verify the stored copy of the given
+// file in case it supports doing so. It returns nil in case the file has
+// not been verified.
+func (s *script) verifyUpload(b storage.Backend, file string) (*storage.Verification, error) {
+	verifiable, ok := b.(storage.VerifiableBackend)
+	if !ok || !s.c.BackupVerifyUploads {
+		return nil, nil
+	}
+	verification, err := verifiable.Verify(file)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error verifying upload")
+	}
+	s.logger.Info(
+		fmt.Sprintf("Verified `%s` stored by backend %s using %s.", path.Base(file), b.Name(), verification.Method),
+	)
+	return verification, nil
+}
+
+// uploadTemplateData is the data that is available when rendering templates
+// for uploading a file, e.g. object tags.
+type uploadTemplateData struct {
+	ConfigName string
+	Hostname   string
+	Stats      *Stats
+	File       uploadedFile
+}
+
+// uploadedFile is the file that is uploaded, i.e. the backup file or one of
+// its companion files.
+type uploadedFile struct {
+	Name   string
+	Path   string
+	SHA256 string
+}
+
+// Size returns the size of the file in bytes.
+func (f uploadedFile) Size() (uint64, error) {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return 0, errwrap.Wrap(err, "error reading file")
+	}
+	return uint64(info.Size()), nil
+}
+
+// renderUploadTemplate renders the given templates for uploading the given
+// file. Stats are locked while rendering as other uploads might update them
+// concurrently.
+func (s *script) renderUploadTemplate(file string, m *TemplateMap) (map[string]string, error) {
+	hostname, _ := os.Hostname()
+	s.stats.Lock()
+	defer s.stats.Unlock()
+	return m.Execute(uploadTemplateData{
+		ConfigName: s.c.name,
+		Hostname:   hostname,
+		Stats:      s.stats,
+		File:       uploadedFile{Name: path.Base(file), Path: file, SHA256: s.digests[file]},
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/offen/docker-volume-backup/internal/storage"
+)
+
+// flakyBackend reads uploaded files through the observed reader and fails
+// the given number of uploads of the backup file after reading it.
+type flakyBackend struct {
+	*storage.StorageBackend
+	failures int
+}
+
+func (b *flakyBackend) Copy(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(io.Discard, b.Reader(f)); err != nil {
+		return err
+	}
+	if strings.HasSuffix(file, ".tar.gz") && b.failures > 0 {
+		b.failures--
+		return errors.New("connection reset")
+	}
+	return nil
+}
+
+func (b *flakyBackend) Prune(time.Time, string) (*storage.PruneStats, error) {
+	return &storage.PruneStats{}, nil
+}
+
+func (b *flakyBackend) Name() string {
+	return "Flaky"
+}
+
+func TestCopyArchiveRetryStats(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "backup.tar.gz")
+	if err := os.WriteFile(file, make([]byte, 4096), 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	companion := filepath.Join(dir, "backup.tar.gz.sig")
+	if err := os.WriteFile(companion, make([]byte, 512), 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	c := mustLoadConfig(t, map[string]string{
+		"BACKUP_RETRY_ATTEMPTS": "2",
+		"BACKUP_RETRY_BACKOFF":  "0s",
+	})
+	s := newScript(c)
+	s.file = file
+	s.companionFiles = []string{companion}
+	s.storages = []storage.Backend{&flakyBackend{
+		StorageBackend: &storage.StorageBackend{Log: func(storage.LogLevel, string, string, ...any) {}},
+		failures:       1,
+	}}
+	if err := s.copyArchive(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	stats := s.stats.Storages["Flaky"]
+	if !stats.Uploaded {
+		t.Errorf("Expected upload to succeed, got %v", stats.UploadError)
+	}
+	if stats.UploadAttempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", stats.UploadAttempts)
+	}
+	if stats.UploadBytes != 4096 {
+		t.Errorf("Expected 4096 bytes to be reported, got %d", stats.UploadBytes)
+	}
+}
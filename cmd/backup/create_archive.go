@@ -24,39 +24,35 @@ func (s *script) createArchive() error {
 		s.logger.Warn(
 			"Please use `archive-pre` and `archive-post` commands to prepare your backup sources. Refer to the documentation for an upgrade guide.",
 		)
-		backupSources = filepath.Join("/tmp", s.c.BackupSources)
-		// copy before compressing guard against a situation where backup folder's content are still growing.
-		s.registerHook(hookLevelPlumbing, func(error) error {
-			if err := remove(backupSources); err != nil {
-				return errwrap.Wrap(err, "error removing snapshot")
+		snapshot := filepath.Join("/tmp", s.c.BackupSources)
+		if s.c.dryRun {
+			s.logger.Info(
+				fmt.Sprintf("Would create snapshot of `%s` at `%s`.", s.c.BackupSources, snapshot),
+			)
+		} else {
+			backupSources = snapshot
+			// copy before compressing guard against a situation where backup folder's content are still growing.
+			s.registerHook(hookLevelPlumbing, func(error) error {
+				if err := remove(backupSources); err != nil {
+					return errwrap.Wrap(err, "error removing snapshot")
+				}
+				s.logger.Info(
+					fmt.Sprintf("Removed snapshot `%s`.", backupSources),
+				)
+				return nil
+			})
+			if err := copy.Copy(s.c.BackupSources, backupSources, copy.Options{
+				PreserveTimes: true,
+				PreserveOwner: true,
+			}); err != nil {
+				return errwrap.Wrap(err, "error creating snapshot")
 			}
 			s.logger.Info(
-				fmt.Sprintf("Removed snapshot `%s`.", backupSources),
+				fmt.Sprintf("Created snapshot of `%s` at `%s`.", s.c.BackupSources, backupSources),
 			)
-			return nil
-		})
-		if err := copy.Copy(s.c.BackupSources, backupSources, copy.Options{
-			PreserveTimes: true,
-			PreserveOwner: true,
-		}); err != nil {
-			return errwrap.Wrap(err, "error creating snapshot")
 		}
-		s.logger.Info(
-			fmt.Sprintf("Created snapshot of `%s` at `%s`.", s.c.BackupSources, backupSources),
-		)
 	}
 
-	tarFile := s.file
-	s.registerHook(hookLevelPlumbing, func(error) error {
-		if err := remove(tarFile); err != nil {
-			return errwrap.Wrap(err, "error removing tar file")
-		}
-		s.logger.Info(
-			fmt.Sprintf("Removed tar file `%s`.", tarFile),
-		)
-		return nil
-	})
-
 	backupPath, err := filepath.Abs(stripTrailingSlashes(backupSources))
 	if err != nil {
 		return errwrap.Wrap(err, "error getting absolute path")
@@ -77,6 +73,27 @@ func (s *script) createArchive() error {
 		return errwrap.Wrap(err, "error walking filesystem tree")
 	}
 
+	tarFile := s.file
+	if s.c.dryRun {
+		for _, file := range filesEligibleForBackup {
+			s.logger.Info(fmt.Sprintf("Would archive `%s`.", file))
+		}
+		s.logger.Info(
+			fmt.Sprintf("Would create backup of %d file(s) in `%s` at `%s`.", len(filesEligibleForBackup), backupSources, tarFile),
+		)
+		return nil
+	}
+
+	s.registerHook(hookLevelPlumbing, func(error) error {
+		if err := remove(tarFile); err != nil {
+			return errwrap.Wrap(err, "error removing tar file")
+		}
+		s.logger.Info(
+			fmt.Sprintf("Removed tar file `%s`.", tarFile),
+		)
+		return nil
+	})
+
 	if err := createArchive(filesEligibleForBackup, backupSources, tarFile, s.c.BackupCompression.String(), s.c.GzipParallelism.Int()); err != nil {
 		return errwrap.Wrap(err, "error compressing backup folder")
 	}
@@ -0,0 +1,365 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	openpgp "github.com/ProtonMail/go-crypto/openpgp/v2"
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"github.com/offen/docker-volume-backup/internal/storage"
+	"golang.org/x/crypto/ssh"
+)
+
+// keyFilesFlag collects the values of a flag that can be given multiple
+// times.
+type keyFilesFlag []string
+
+func (k *keyFilesFlag) String() string {
+	return strings.Join(*k, ",")
+}
+
+func (k *keyFilesFlag) Set(value string) error {
+	*k = append(*k, value)
+	return nil
+}
+
+// runDecrypt implements the `decrypt` subcommand which decrypts a backup file
+// using the matching key out of the given key files.
+func (c *command) runDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	var keyFiles keyFilesFlag
+	fs.Var(&keyFiles, "identity", "location of a file containing age identities, an OpenSSH private key or armored OpenPGP private keys, can be given multiple times")
+	passphraseFile := fs.String("passphrase-file", "", "location of a file containing the passphrase the backup or the given keys are protected with")
+	metadataFile := fs.String("metadata", "", "location of the encryption metadata, defaults to the backup file with a .meta.json extension")
+	output := fs.String("o", "", "location of the decrypted backup, defaults to the backup file without its .age or .gpg extension")
+	if err := fs.Parse(args); err != nil {
+		return errwrap.Wrap(err, "error parsing flags")
+	}
+	if fs.NArg() != 1 {
+		return errwrap.Wrap(nil, "usage: backup decrypt [-identity <key file>]... [-passphrase-file <file>] [-metadata <file>] [-o <output>] <backup file>")
+	}
+	file := fs.Arg(0)
+
+	var keys [][]byte
+	for _, keyFile := range keyFiles {
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			return errwrap.Wrap(err, fmt.Sprintf("error reading key %s", keyFile))
+		}
+		keys = append(keys, b)
+	}
+	var passphrase []byte
+	if *passphraseFile != "" {
+		b, err := os.ReadFile(*passphraseFile)
+		if err != nil {
+			return errwrap.Wrap(err, fmt.Sprintf("error reading passphrase %s", *passphraseFile))
+		}
+		passphrase = bytes.TrimRight(b, "\r\n")
+	}
+
+	if *metadataFile == "" {
+		candidate := fmt.Sprintf("%s.%s", file, metadataExtension)
+		if _, err := os.Stat(candidate); err == nil {
+			*metadataFile = candidate
+		}
+	}
+	var metadata *encryptionMetadata
+	if *metadataFile != "" {
+		var err error
+		if metadata, err = readEncryptionMetadata(*metadataFile); err != nil {
+			return err
+		}
+	}
+
+	format := strings.TrimPrefix(path.Ext(file), ".")
+	if metadata != nil {
+		format = metadata.Format
+	}
+	if *output == "" {
+		if ext := path.Ext(file); ext == ".age" || ext == ".gpg" {
+			*output = strings.TrimSuffix(file, ext)
+		} else {
+			return errwrap.Wrap(nil, fmt.Sprintf("unable to derive output location from %s, pass -o", file))
+		}
+	}
+
+	used, err := decryptFile(file, *output, format, metadata, keys, passphrase)
+	if err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error decrypting %s", file))
+	}
+	fmt.Printf("%s: decrypted to %s using %s\n", file, *output, used)
+	return nil
+}
+
+// decryptFile decrypts the given file and writes the plaintext to dst. In
+// case metadata is given, only the keys the file has been encrypted for are
+// used. It returns a description of the keys used.
+func decryptFile(src, dst, format string, metadata *encryptionMetadata, keys [][]byte, passphrase []byte) (_ string, outerr error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", errwrap.Wrap(err, fmt.Sprintf("error opening %s", src))
+	}
+	defer in.Close()
+
+	var plaintext io.Reader
+	var used string
+	switch format {
+	case "age":
+		plaintext, used, err = decryptWithAge(in, metadata, keys, passphrase)
+	case "gpg":
+		plaintext, used, err = decryptWithGPG(in, metadata, keys, passphrase)
+	default:
+		return "", errwrap.Wrap(nil, fmt.Sprintf("unknown encryption format %q", format))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", errwrap.Wrap(err, fmt.Sprintf("error creating %s", dst))
+	}
+	defer func() {
+		if err := out.Close(); err != nil {
+			outerr = errors.Join(outerr, errwrap.Wrap(err, fmt.Sprintf("error closing %s", dst)))
+		}
+		if outerr != nil {
+			_ = os.Remove(dst)
+		}
+	}()
+	if _, err := io.Copy(out, plaintext); err != nil {
+		return "", errwrap.Wrap(err, "error writing plaintext")
+	}
+	return used, nil
+}
+
+// ageCandidate is an identity that may be able to decrypt an age file.
+type ageCandidate struct {
+	identity age.Identity
+	key      encryptionKey
+}
+
+func decryptWithAge(r io.Reader, metadata *encryptionMetadata, keys [][]byte, passphrase []byte) (io.Reader, string, error) {
+	var candidates []ageCandidate
+	for _, b := range keys {
+		parsed, err := parseAgeIdentities(b, passphrase)
+		if err != nil {
+			return nil, "", err
+		}
+		candidates = append(candidates, parsed...)
+	}
+	if len(passphrase) != 0 {
+		identity, err := age.NewScryptIdentity(string(passphrase))
+		if err != nil {
+			return nil, "", errwrap.Wrap(err, "error creating scrypt identity from passphrase")
+		}
+		candidates = append(candidates, ageCandidate{identity, encryptionKey{Type: keyTypePassphrase}})
+	}
+	if metadata != nil {
+		for _, key := range metadata.Keys {
+			if key.Type != keyTypeVaultTransit || key.WrappedKey == "" {
+				continue
+			}
+			identity, err := unwrapVaultTransitKey(key)
+			if err != nil {
+				return nil, "", err
+			}
+			candidates = append(candidates, ageCandidate{identity, key})
+		}
+	}
+
+	selected, used := selectKeys(candidates, metadata, func(c ageCandidate) encryptionKey { return c.key })
+	if len(selected) == 0 {
+		return nil, "", noMatchingKeyError(metadata)
+	}
+	identities := make([]age.Identity, len(selected))
+	for i, c := range selected {
+		identities[i] = c.identity
+	}
+	plaintext, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, "", errwrap.Wrap(err, "error decrypting age file")
+	}
+	return plaintext, strings.Join(storage.Names(used, encryptionKey.String), ", "), nil
+}
+
+// parseAgeIdentities parses the given key file, which can either be an
+// identity file as accepted by `age -i` or an OpenSSH private key. Identity
+// files that are encrypted using a passphrase, like the recovery key stored
+// alongside a backup, are decrypted first.
+func parseAgeIdentities(b []byte, passphrase []byte) ([]ageCandidate, error) {
+	if bytes.HasPrefix(b, []byte("age-encryption.org/v1\n")) {
+		if len(passphrase) == 0 {
+			return nil, errwrap.Wrap(nil, "identity file is encrypted, but no passphrase was given")
+		}
+		identity, err := age.NewScryptIdentity(string(passphrase))
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error creating scrypt identity from passphrase")
+		}
+		r, err := age.Decrypt(bytes.NewReader(b), identity)
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error decrypting identity file")
+		}
+		if b, err = io.ReadAll(r); err != nil {
+			return nil, errwrap.Wrap(err, "error decrypting identity file")
+		}
+	}
+
+	if bytes.Contains(b, []byte("PRIVATE KEY-----")) {
+		raw, err := ssh.ParseRawPrivateKey(b)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) && len(passphrase) != 0 {
+			raw, err = ssh.ParseRawPrivateKeyWithPassphrase(b, passphrase)
+		}
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error parsing SSH private key")
+		}
+		var identity age.Identity
+		switch k := raw.(type) {
+		case *ed25519.PrivateKey:
+			identity, err = agessh.NewEd25519Identity(*k)
+		case ed25519.PrivateKey:
+			identity, err = agessh.NewEd25519Identity(k)
+		case *rsa.PrivateKey:
+			identity, err = agessh.NewRSAIdentity(k)
+		default:
+			return nil, errwrap.Wrap(nil, fmt.Sprintf("unsupported SSH key type %T", raw))
+		}
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error creating identity from SSH private key")
+		}
+		signer, err := ssh.NewSignerFromKey(raw)
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error reading SSH public key")
+		}
+		pk := signer.PublicKey()
+		return []ageCandidate{{identity, encryptionKey{Type: pk.Type(), Fingerprint: ssh.FingerprintSHA256(pk)}}}, nil
+	}
+
+	identities, err := age.ParseIdentities(bytes.NewReader(b))
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error parsing age identities")
+	}
+	var candidates []ageCandidate
+	for _, identity := range identities {
+		key := encryptionKey{Type: keyTypeX25519}
+		if x25519, ok := identity.(*age.X25519Identity); ok {
+			key.Fingerprint = x25519.Recipient().String()
+		}
+		candidates = append(candidates, ageCandidate{identity, key})
+	}
+	return candidates, nil
+}
+
+func decryptWithGPG(r io.Reader, metadata *encryptionMetadata, keys [][]byte, passphrase []byte) (io.Reader, string, error) {
+	var entityList openpgp.EntityList
+	for _, b := range keys {
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(b))
+		if err != nil {
+			return nil, "", errwrap.Wrap(err, "error parsing armored key ring")
+		}
+		entityList = append(entityList, entities...)
+	}
+	candidates, _ := selectKeys(entityList, metadata, func(e *openpgp.Entity) encryptionKey {
+		return encryptionKey{Type: keyTypeOpenPGP, Fingerprint: gpgFingerprint(e)}
+	})
+	usePassphrase := len(passphrase) != 0 && (metadata == nil || slices.ContainsFunc(metadata.Keys, func(k encryptionKey) bool { return k.Type == keyTypePassphrase }))
+	if len(candidates) == 0 && !usePassphrase {
+		return nil, "", noMatchingKeyError(metadata)
+	}
+	for _, entity := range candidates {
+		if entity.PrivateKey == nil {
+			return nil, "", errwrap.Wrap(nil, fmt.Sprintf("key %s does not contain a private key", gpgFingerprint(entity)))
+		}
+		if entity.PrivateKey.Encrypted {
+			if err := entity.DecryptPrivateKeys(passphrase); err != nil {
+				return nil, "", errwrap.Wrap(err, fmt.Sprintf("error decrypting key %s", gpgFingerprint(entity)))
+			}
+		}
+	}
+
+	// Backups encrypted using public keys are armored, while backups
+	// encrypted using a passphrase only are not.
+	br := bufio.NewReader(r)
+	var body io.Reader = br
+	if head, _ := br.Peek(5); string(head) == "-----" {
+		block, err := armor.Decode(br)
+		if err != nil {
+			return nil, "", errwrap.Wrap(err, "error decoding armored file")
+		}
+		body = block.Body
+	}
+
+	// The prompt is called again in case the passphrase is wrong, so it is
+	// only returned once.
+	prompted := false
+	md, err := openpgp.ReadMessage(body, openpgp.EntityList(candidates), func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if !symmetric || !usePassphrase || prompted {
+			return nil, errwrap.Wrap(nil, "none of the given keys or passphrase can decrypt the file")
+		}
+		prompted = true
+		return passphrase, nil
+	}, nil)
+	if err != nil {
+		return nil, "", errwrap.Wrap(err, "error decrypting gpg file")
+	}
+	used := keyTypePassphrase
+	if md.DecryptedWith.Entity != nil {
+		used = encryptionKey{Type: keyTypeOpenPGP, Fingerprint: gpgFingerprint(md.DecryptedWith.Entity)}.String()
+	}
+	return md.UnverifiedBody, used, nil
+}
+
+// selectKeys returns the candidates matching any of the keys listed in the
+// given metadata, as well as the matching keys. In case no metadata is given,
+// all candidates are returned.
+func selectKeys[T any](candidates []T, metadata *encryptionMetadata, key func(T) encryptionKey) ([]T, []encryptionKey) {
+	var selected []T
+	var keys []encryptionKey
+	for _, candidate := range candidates {
+		k := key(candidate)
+		if metadata == nil {
+			selected = append(selected, candidate)
+			keys = append(keys, k)
+			continue
+		}
+		for _, m := range metadata.Keys {
+			if k.Type == keyTypePassphrase && m.Type == keyTypePassphrase ||
+				k.Fingerprint != "" && k.Fingerprint == m.Fingerprint {
+				selected = append(selected, candidate)
+				keys = append(keys, m)
+				break
+			}
+		}
+	}
+	return selected, keys
+}
+
+func noMatchingKeyError(metadata *encryptionMetadata) error {
+	if metadata == nil {
+		return errwrap.Wrap(nil, "no keys given")
+	}
+	return errwrap.Wrap(
+		nil,
+		fmt.Sprintf(
+			"none of the given keys match, the backup was encrypted for: %s",
+			strings.Join(storage.Names(metadata.Keys, encryptionKey.String), ", "),
+		),
+	)
+}
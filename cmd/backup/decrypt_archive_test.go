@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	openpgp "github.com/ProtonMail/go-crypto/openpgp/v2"
+)
+
+func TestDecryptFile(t *testing.T) {
+	oldIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	newIdentity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	oldEntity, err := openpgp.NewEntity("old", "", "old@example.com", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	newEntity, err := openpgp.NewEntity("new", "", "new@example.com", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	ageKeyRing := []byte(fmt.Sprintf("# old\n%s\n# new\n%s\n", oldIdentity, newIdentity))
+	gpgKeyRing := armoredKeyRing(t, "PGP PRIVATE KEY BLOCK", func(e *openpgp.Entity, w *bytes.Buffer) error {
+		return e.SerializePrivate(w, nil)
+	}, oldEntity, newEntity)
+	oldKeyRing := armoredKeyRing(t, "PGP PRIVATE KEY BLOCK", func(e *openpgp.Entity, w *bytes.Buffer) error {
+		return e.SerializePrivate(w, nil)
+	}, oldEntity)
+	newPublicKey := armoredKeyRing(t, "PGP PUBLIC KEY BLOCK", func(e *openpgp.Entity, w *bytes.Buffer) error {
+		return e.Serialize(w)
+	}, newEntity)
+
+	transit := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		var payload map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Wrapped keys are not actually encrypted here, but prefixed like Vault does.
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/backup":
+			fmt.Fprintf(w, `{"data":{"ciphertext":"vault:v1:%s"}}`, payload["plaintext"])
+		case "/v1/transit/decrypt/backup":
+			fmt.Fprintf(w, `{"data":{"plaintext":"%s"}}`, strings.TrimPrefix(payload["ciphertext"], "vault:v1:"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer transit.Close()
+	t.Setenv("VAULT_ADDR", transit.URL)
+	t.Setenv("VAULT_TOKEN", "token")
+
+	tests := []struct {
+		name          string
+		config        Config
+		keys          func(backup string) [][]byte
+		passphrase    string
+		expectedUsed  string
+		expectedError string
+	}{
+		{
+			"age key ring",
+			Config{AgePublicKeys: []string{newIdentity.Recipient().String()}},
+			func(string) [][]byte { return [][]byte{ageKeyRing} },
+			"",
+			newIdentity.Recipient().String(),
+			"",
+		},
+		{
+			"age rotated key",
+			Config{AgePublicKeys: []string{newIdentity.Recipient().String()}},
+			func(string) [][]byte { return [][]byte{[]byte(oldIdentity.String())} },
+			"",
+			"",
+			"backup was encrypted for: X25519 key " + newIdentity.Recipient().String(),
+		},
+		{
+			"age recovery key",
+			Config{AgePublicKeys: []string{oldIdentity.Recipient().String()}, AgePassphrase: "test"},
+			func(backup string) [][]byte {
+				b, err := os.ReadFile(backup + ".key")
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				return [][]byte{b}
+			},
+			"test",
+			"X25519 recovery key",
+			"",
+		},
+		{
+			"vault transit",
+			Config{
+				VaultTransitAddress:   transit.URL,
+				VaultTransitToken:     "token",
+				VaultTransitMountPath: "transit",
+				VaultTransitKeyName:   "backup",
+			},
+			func(string) [][]byte { return nil },
+			"",
+			"vault-transit key transit/backup",
+			"",
+		},
+		{
+			"gpg key ring",
+			Config{GpgPublicKeyRing: string(newPublicKey)},
+			func(string) [][]byte { return [][]byte{gpgKeyRing} },
+			"",
+			gpgFingerprint(newEntity),
+			"",
+		},
+		{
+			"gpg passphrase",
+			Config{GpgPassphrase: "test"},
+			func(string) [][]byte { return nil },
+			"test",
+			"passphrase",
+			"",
+		},
+		{
+			"gpg rotated key",
+			Config{GpgPublicKeyRing: string(newPublicKey)},
+			func(string) [][]byte { return [][]byte{oldKeyRing} },
+			"",
+			"",
+			"backup was encrypted for: openpgp RSA key " + gpgFingerprint(newEntity),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, "backup.tar.gz")
+			if err := os.WriteFile(file, []byte("backup"), 0644); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			s := newScript(&test.config)
+			s.file = file
+			if err := s.encryptArchive(); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			metadata, err := readEncryptionMetadata(s.file + ".meta.json")
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			output := filepath.Join(dir, "restored.tar.gz")
+			used, err := decryptFile(s.file, output, metadata.Format, metadata, test.keys(s.file), []byte(test.passphrase))
+			if test.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+					t.Fatalf("Expected error containing %s, got %v", test.expectedError, err)
+				}
+				if _, err := os.Stat(output); !os.IsNotExist(err) {
+					t.Errorf("Expected no output to be written, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if !strings.Contains(used, test.expectedUsed) {
+				t.Errorf("Expected %s to be used, got %s", test.expectedUsed, used)
+			}
+			if plaintext, err := os.ReadFile(output); err != nil || string(plaintext) != "backup" {
+				t.Errorf("Unexpected plaintext %s, %v", plaintext, err)
+			}
+		})
+	}
+}
+
+func armoredKeyRing(t *testing.T, blockType string, serialize func(*openpgp.Entity, *bytes.Buffer) error, entities ...*openpgp.Entity) []byte {
+	t.Helper()
+	var raw bytes.Buffer
+	for _, entity := range entities {
+		if err := serialize(entity, &raw); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	var armored bytes.Buffer
+	w, err := armor.Encode(&armored, blockType, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err := w.Write(raw.Bytes()); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return armored.Bytes()
+}
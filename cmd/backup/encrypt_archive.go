@@ -8,15 +8,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"filippo.io/age"
 	"filippo.io/age/agessh"
 	"github.com/ProtonMail/go-crypto/openpgp/armor"
 	openpgp "github.com/ProtonMail/go-crypto/openpgp/v2"
 	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"golang.org/x/crypto/ssh"
 )
 
 func countTrue(b ...bool) int {
@@ -29,98 +32,323 @@ func countTrue(b ...bool) int {
 	return c
 }
 
-// encryptArchive encrypts the backup file using PGP and the configured passphrase or publickey(s).
-// In case no passphrase or publickey is given it returns early, leaving the backup file
-// untouched.
+// encryptor wraps the writer for the encrypted file, returning a writer for
+// the plaintext.
+type encryptor func(ciphertextWriter io.Writer) (io.WriteCloser, error)
+
+// encryptArchive encrypts the backup file using PGP or age and the configured
+// passphrase and/or public keys. In case no passphrase or public key is given
+// it returns early, leaving the backup file untouched.
 func (s *script) encryptArchive() error {
-	useGPGSymmetric := s.c.GpgPassphrase != ""
-	useGPGAsymmetric := s.c.GpgPublicKeyRing != ""
-	useAgeSymmetric := s.c.AgePassphrase != ""
-	useAgeAsymmetric := len(s.c.AgePublicKeys) > 0
-	switch nconfigured := countTrue(
-		useGPGSymmetric,
-		useGPGAsymmetric,
-		useAgeSymmetric,
-		useAgeAsymmetric,
-	); nconfigured {
-	case 0:
+	extension, enc, err := s.newEncryptor()
+	if err != nil {
+		return err
+	}
+	if enc == nil {
+		return nil
+	}
+	if s.c.dryRun {
+		s.file = fmt.Sprintf("%s.%s", s.file, extension)
+		s.logger.Info(fmt.Sprintf("Would encrypt backup, resulting in `%s`.", s.file))
+		s.companionFiles = append(s.companionFiles, fmt.Sprintf("%s.%s", s.file, metadataExtension))
+		s.logger.Info(fmt.Sprintf("Would save encryption metadata as `%s.%s`.", s.file, metadataExtension))
+		if s.ageRecoveryIdentity != nil {
+			s.companionFiles = append(s.companionFiles, fmt.Sprintf("%s.key", s.file))
+			s.logger.Info(fmt.Sprintf("Would save passphrase protected recovery key as `%s.key`.", s.file))
+		}
 		return nil
+	}
+	if err := s.doEncrypt(extension, enc); err != nil {
+		return err
+	}
+	if err := s.writeEncryptionMetadata(extension); err != nil {
+		return err
+	}
+	if s.ageRecoveryIdentity != nil {
+		return s.writeAgeRecoveryKey()
+	}
+	return nil
+}
+
+// newEncryptor validates the configured encryption method and returns an
+// encryptor as well as the extension of the encrypted file. The keys used
+// for encrypting are recorded, so they can be written to the metadata file.
+// In case no encryption is configured, the returned encryptor is nil.
+func (s *script) newEncryptor() (string, encryptor, error) {
+	useGPG := s.c.GpgPassphrase != "" || s.c.GpgPublicKeyRing != "" || len(s.c.GpgPublicKeyRingFiles) > 0
+	useAge := s.c.AgePassphrase != "" || len(s.c.AgePublicKeys) > 0 || len(s.c.AgeRecipientsFiles) > 0
+	useVaultTransit := s.c.VaultTransitAddress != "" || s.c.VaultTransitKeyName != ""
+	switch nconfigured := countTrue(useGPG, useAge, useVaultTransit); nconfigured {
+	case 0:
+		return "", nil, nil
 	case 1:
 		// ok!
 	default:
-		return fmt.Errorf(
+		return "", nil, fmt.Errorf(
 			"error in selecting archive encryption method: expected 0 or 1 to be configured, %d methods are configured",
 			nconfigured,
 		)
 	}
 
-	if useGPGSymmetric {
-		return s.encryptWithGPGSymmetric()
-	} else if useGPGAsymmetric {
-		return s.encryptWithGPGAsymmetric()
-	} else if useAgeSymmetric || useAgeAsymmetric {
-		ar, err := s.getConfiguredAgeRecipients()
+	if useVaultTransit {
+		if s.c.VaultTransitAddress == "" || s.c.VaultTransitToken == "" || s.c.VaultTransitKeyName == "" {
+			return "", nil, errwrap.Wrap(nil, "VAULT_TRANSIT_ADDRESS, VAULT_TRANSIT_TOKEN and VAULT_TRANSIT_KEY_NAME are required for encrypting using Vault Transit")
+		}
+		return "age", s.encryptWithVaultTransit(newVaultTransit(
+			s.c.VaultTransitAddress,
+			s.c.VaultTransitToken,
+			s.c.VaultTransitNamespace,
+			s.c.VaultTransitMountPath,
+			s.c.VaultTransitKeyName,
+		)), nil
+	}
+
+	if useGPG {
+		entityList, err := s.getConfiguredGPGRecipients()
 		if err != nil {
-			return errwrap.Wrap(err, "failed to get configured age recipients")
+			return "", nil, errwrap.Wrap(err, "failed to get configured gpg recipients")
 		}
-		return s.encryptWithAge(ar)
+		s.encryptionKeys = describeGPGRecipients(entityList)
+		if s.c.GpgPassphrase != "" {
+			s.encryptionKeys = append(s.encryptionKeys, encryptionKey{Type: keyTypePassphrase})
+		}
+		if len(entityList) == 0 {
+			return "gpg", s.encryptWithGPGSymmetric, nil
+		}
+		return "gpg", s.encryptWithGPGAsymmetric(entityList), nil
 	}
-	return nil
+	ar, keys, err := s.getConfiguredAgeRecipients()
+	if err != nil {
+		return "", nil, errwrap.Wrap(err, "failed to get configured age recipients")
+	}
+	s.encryptionKeys = keys
+	return "age", s.encryptWithAge(ar), nil
 }
 
-func (s *script) getConfiguredAgeRecipients() ([]age.Recipient, error) {
-	if s.c.AgePassphrase == "" && len(s.c.AgePublicKeys) == 0 {
-		return nil, fmt.Errorf("no age recipients configured")
+// getConfiguredGPGRecipients returns the public keys of all configured key
+// rings.
+func (s *script) getConfiguredGPGRecipients() (openpgp.EntityList, error) {
+	var keyRings [][]byte
+	if s.c.GpgPublicKeyRing != "" {
+		keyRings = append(keyRings, []byte(s.c.GpgPublicKeyRing))
+	}
+	for _, source := range s.c.GpgPublicKeyRingFiles {
+		b, err := readKeySource(source)
+		if err != nil {
+			return nil, err
+		}
+		keyRings = append(keyRings, b)
+	}
+
+	var entityList openpgp.EntityList
+	for _, keyRing := range keyRings {
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keyRing))
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error parsing armored keyring")
+		}
+		entityList = append(entityList, entities...)
+	}
+	return entityList, nil
+}
+
+// getConfiguredAgeRecipients returns all configured age recipients. As age
+// does not allow combining a passphrase with other recipients, a one-off
+// recovery identity is added as a recipient in this case, which is then
+// saved alongside the backup, protected by the passphrase. Next to the
+// recipients, a description of each key is returned.
+func (s *script) getConfiguredAgeRecipients() ([]age.Recipient, []encryptionKey, error) {
+	if s.c.AgePassphrase == "" && len(s.c.AgePublicKeys) == 0 && len(s.c.AgeRecipientsFiles) == 0 {
+		return nil, nil, fmt.Errorf("no age recipients configured")
 	}
 	recipients := []age.Recipient{}
-	if len(s.c.AgePublicKeys) > 0 {
-		for _, pk := range s.c.AgePublicKeys {
-			pkr, err := parseAgeRecipient(pk)
-			if err != nil {
-				return nil, errwrap.Wrap(err, "failed to parse age public key")
-			}
-			recipients = append(recipients, pkr)
+	keys := []encryptionKey{}
+	for _, pk := range s.c.AgePublicKeys {
+		pkr, key, err := parseAgeRecipient(pk)
+		if err != nil {
+			return nil, nil, errwrap.Wrap(err, "failed to parse age public key")
+		}
+		recipients = append(recipients, pkr)
+		keys = append(keys, key)
+	}
+	for _, source := range s.c.AgeRecipientsFiles {
+		b, err := readKeySource(source)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileRecipients, fileKeys, err := s.parseAgeRecipientsFile(b)
+		if err != nil {
+			return nil, nil, errwrap.Wrap(err, fmt.Sprintf("failed to parse age recipients file %s", source))
 		}
+		recipients = append(recipients, fileRecipients...)
+		keys = append(keys, fileKeys...)
 	}
+
 	if s.c.AgePassphrase != "" {
-		if len(recipients) != 0 {
-			return nil, fmt.Errorf("age encryption must only be enabled via passphrase or public key, not both")
+		if len(recipients) == 0 {
+			r, err := age.NewScryptRecipient(s.c.AgePassphrase)
+			if err != nil {
+				return nil, nil, errwrap.Wrap(err, "failed to create scrypt identity from age passphrase")
+			}
+			return []age.Recipient{r}, []encryptionKey{{Type: keyTypePassphrase}}, nil
 		}
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			return nil, nil, errwrap.Wrap(err, "failed to generate recovery identity")
+		}
+		s.ageRecoveryIdentity = identity
+		recipients = append(recipients, identity.Recipient())
+		keys = append(keys, encryptionKey{
+			Type:        keyTypeX25519,
+			Fingerprint: identity.Recipient().String(),
+			Recovery:    true,
+		})
+	}
+	return recipients, keys, nil
+}
 
-		r, err := age.NewScryptRecipient(s.c.AgePassphrase)
+// parseAgeRecipientsFile parses a recipients file in the format accepted by
+// `age -R`, i.e. one recipient per line, ignoring empty lines and comments.
+// This also allows using the SSH public keys of a GitHub user, in which case
+// keys of types age does not support are skipped.
+func (s *script) parseAgeRecipientsFile(b []byte) ([]age.Recipient, []encryptionKey, error) {
+	var recipients []age.Recipient
+	var keys []encryptionKey
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "ecdsa-") || strings.HasPrefix(line, "sk-") {
+			s.logger.Warn(fmt.Sprintf("Skipping SSH key of unsupported type on line %d of age recipients file.", i+1))
+			continue
+		}
+		r, key, err := parseAgeRecipient(line)
 		if err != nil {
-			return nil, errwrap.Wrap(err, "failed to create scrypt identity from age passphrase")
+			return nil, nil, errwrap.Wrap(err, fmt.Sprintf("error parsing line %d", i+1))
 		}
 		recipients = append(recipients, r)
+		keys = append(keys, key)
+	}
+	if len(recipients) == 0 {
+		return nil, nil, errwrap.Wrap(nil, "file does not contain any recipients")
 	}
-	return recipients, nil
+	return recipients, keys, nil
 }
 
-func parseAgeRecipient(arg string) (age.Recipient, error) {
+func parseAgeRecipient(arg string) (age.Recipient, encryptionKey, error) {
 	// This logic is adapted from what the age CLI is doing
 	// stripping some special cases
 	switch {
 	case strings.HasPrefix(arg, "age1"):
-		return age.ParseX25519Recipient(arg)
+		r, err := age.ParseX25519Recipient(arg)
+		if err != nil {
+			return nil, encryptionKey{}, err
+		}
+		return r, encryptionKey{Type: keyTypeX25519, Fingerprint: r.String()}, nil
 	case strings.HasPrefix(arg, "ssh-"):
-		return agessh.ParseRecipient(arg)
+		r, err := agessh.ParseRecipient(arg)
+		if err != nil {
+			return nil, encryptionKey{}, err
+		}
+		pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(arg))
+		if err != nil {
+			return nil, encryptionKey{}, err
+		}
+		return r, encryptionKey{Type: pk.Type(), Fingerprint: ssh.FingerprintSHA256(pk)}, nil
 	}
-	return nil, fmt.Errorf("unknown recipient type: %q", arg)
+	return nil, encryptionKey{}, fmt.Errorf("unknown recipient type: %q", arg)
 }
 
-func (s *script) encryptWithAge(rec []age.Recipient) error {
-	return s.doEncrypt("age", func(ciphertextWriter io.Writer) (io.WriteCloser, error) {
-		return age.Encrypt(ciphertextWriter, rec...)
-	})
+// readKeySource reads the file at the given location, which can also be an
+// HTTP(S) URL, e.g. https://github.com/<user>.keys
+func readKeySource(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "https://") && !strings.HasPrefix(source, "http://") {
+		b, err := os.ReadFile(source)
+		if err != nil {
+			return nil, errwrap.Wrap(err, fmt.Sprintf("error reading %s", source))
+		}
+		return b, nil
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	res, err := client.Get(source)
+	if err != nil {
+		return nil, errwrap.Wrap(err, fmt.Sprintf("error fetching %s", source))
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errwrap.Wrap(nil, fmt.Sprintf("error fetching %s: received status %s", source, res.Status))
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, errwrap.Wrap(err, fmt.Sprintf("error reading response from %s", source))
+	}
+	return b, nil
 }
 
-func (s *script) encryptWithGPGSymmetric() error {
-	return s.doEncrypt("gpg", func(ciphertextWriter io.Writer) (io.WriteCloser, error) {
-		_, name := path.Split(s.file)
-		return openpgp.SymmetricallyEncrypt(ciphertextWriter, []byte(s.c.GpgPassphrase), &openpgp.FileHints{
-			FileName: name,
-		}, nil)
+// writeAgeRecoveryKey saves the recovery identity next to the encrypted
+// backup file, encrypted using the configured passphrase. It can be passed
+// to `age -d -i`, which asks for the passphrase.
+func (s *script) writeAgeRecoveryKey() (outerr error) {
+	keyFile := fmt.Sprintf("%s.key", s.file)
+	s.registerHook(hookLevelPlumbing, func(error) error {
+		if err := remove(keyFile); err != nil {
+			return errwrap.Wrap(err, "error removing recovery key file")
+		}
+		s.logger.Info(
+			fmt.Sprintf("Removed recovery key file `%s`.", keyFile),
+		)
+		return nil
 	})
+
+	r, err := age.NewScryptRecipient(s.c.AgePassphrase)
+	if err != nil {
+		return errwrap.Wrap(err, "failed to create scrypt identity from age passphrase")
+	}
+	outFile, err := os.Create(keyFile)
+	if err != nil {
+		return errwrap.Wrap(err, "error opening recovery key file")
+	}
+	defer func() {
+		if err := outFile.Close(); err != nil {
+			outerr = errors.Join(outerr, errwrap.Wrap(err, "error closing recovery key file"))
+		}
+	}()
+	w, err := age.Encrypt(outFile, r)
+	if err != nil {
+		return errwrap.Wrap(err, "error encrypting recovery key")
+	}
+	if _, err := fmt.Fprintf(
+		w,
+		"# recovery key for %s\n# public key: %s\n%s\n",
+		path.Base(s.file),
+		s.ageRecoveryIdentity.Recipient(),
+		s.ageRecoveryIdentity,
+	); err != nil {
+		return errwrap.Wrap(err, "error writing recovery key")
+	}
+	if err := w.Close(); err != nil {
+		return errwrap.Wrap(err, "error encrypting recovery key")
+	}
+
+	s.companionFiles = append(s.companionFiles, keyFile)
+	s.logger.Info(
+		fmt.Sprintf("Saved passphrase protected recovery key as %q", keyFile),
+	)
+	return nil
+}
+
+func (s *script) encryptWithAge(rec []age.Recipient) encryptor {
+	return func(ciphertextWriter io.Writer) (io.WriteCloser, error) {
+		return age.Encrypt(ciphertextWriter, rec...)
+	}
+}
+
+func (s *script) encryptWithGPGSymmetric(ciphertextWriter io.Writer) (io.WriteCloser, error) {
+	_, name := path.Split(s.file)
+	return openpgp.SymmetricallyEncrypt(ciphertextWriter, []byte(s.c.GpgPassphrase), &openpgp.FileHints{
+		FileName: name,
+	}, nil)
 }
 
 type closeAllWriter struct {
@@ -137,13 +365,8 @@ func (c *closeAllWriter) Close() (err error) {
 
 var _ io.WriteCloser = (*closeAllWriter)(nil)
 
-func (s *script) encryptWithGPGAsymmetric() error {
-	return s.doEncrypt("gpg", func(ciphertextWriter io.Writer) (_ io.WriteCloser, outerr error) {
-		entityList, err := openpgp.ReadArmoredKeyRing(bytes.NewReader([]byte(s.c.GpgPublicKeyRing)))
-		if err != nil {
-			return nil, errwrap.Wrap(err, "error parsing armored keyring")
-		}
-
+func (s *script) encryptWithGPGAsymmetric(entityList openpgp.EntityList) encryptor {
+	return func(ciphertextWriter io.Writer) (_ io.WriteCloser, outerr error) {
 		armoredWriter, err := armor.Encode(ciphertextWriter, "PGP MESSAGE", nil)
 		if err != nil {
 			return nil, errwrap.Wrap(err, "error preparing encryption")
@@ -155,9 +378,18 @@ func (s *script) encryptWithGPGAsymmetric() error {
 		}()
 
 		_, name := path.Split(s.file)
-		encWriter, err := openpgp.Encrypt(armoredWriter, entityList, nil, nil, &openpgp.FileHints{
-			FileName: name,
-		}, nil)
+		// A passphrase is used in addition to the public keys in case both
+		// are given.
+		var passwords [][]byte
+		if s.c.GpgPassphrase != "" {
+			passwords = append(passwords, []byte(s.c.GpgPassphrase))
+		}
+		encWriter, err := openpgp.EncryptWithParams(armoredWriter, entityList, nil, &openpgp.EncryptParams{
+			Hints: &openpgp.FileHints{
+				FileName: name,
+			},
+			Passwords: passwords,
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -165,12 +397,12 @@ func (s *script) encryptWithGPGAsymmetric() error {
 			Writer:  encWriter,
 			closers: []io.Closer{encWriter, armoredWriter},
 		}, nil
-	})
+	}
 }
 
 func (s *script) doEncrypt(
 	extension string,
-	encryptor func(ciphertextWriter io.Writer) (io.WriteCloser, error),
+	encrypt encryptor,
 ) (outerr error) {
 	encFile := fmt.Sprintf("%s.%s", s.file, extension)
 	s.registerHook(hookLevelPlumbing, func(error) error {
@@ -193,7 +425,7 @@ func (s *script) doEncrypt(
 		}
 	}()
 
-	dst, err := encryptor(outFile)
+	dst, err := encrypt(outFile)
 	if err != nil {
 		return errwrap.Wrap(err, "error encrypting backup file")
 	}
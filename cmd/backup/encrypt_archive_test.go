@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	openpgp "github.com/ProtonMail/go-crypto/openpgp/v2"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestEncryptArchiveAge(t *testing.T) {
+	first, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	second, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	sshPublic, sshPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	sshIdentity, err := agessh.NewEd25519Identity(sshPrivate)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	sshKey, err := ssh.NewPublicKey(sshPublic)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	recipientsFile := filepath.Join(t.TempDir(), "recipients.txt")
+	if err := os.WriteFile(recipientsFile, []byte(fmt.Sprintf("# ops team\n\n%s\n", second.Recipient())), 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTY=\n%s", ssh.MarshalAuthorizedKey(sshKey))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		config     Config
+		identities []age.Identity
+		expectKey  bool
+	}{
+		{
+			"recipients files",
+			Config{
+				AgePublicKeys:      []string{first.Recipient().String()},
+				AgeRecipientsFiles: []string{recipientsFile, server.URL + "/user.keys"},
+			},
+			[]age.Identity{first, second, sshIdentity},
+			false,
+		},
+		{
+			"passphrase and public key",
+			Config{
+				AgePublicKeys: []string{first.Recipient().String()},
+				AgePassphrase: "test",
+			},
+			[]age.Identity{first},
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "backup.tar.gz")
+			if err := os.WriteFile(file, []byte("backup"), 0644); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			s := newScript(&test.config)
+			s.file = file
+			if err := s.encryptArchive(); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if s.file != file+".age" {
+				t.Fatalf("Unexpected file %s", s.file)
+			}
+
+			expectedCompanions := []string{s.file + ".meta.json"}
+			if test.expectKey {
+				expectedCompanions = append(expectedCompanions, s.file+".key")
+			}
+			if !slices.Equal(s.companionFiles, expectedCompanions) {
+				t.Fatalf("Unexpected companion files %v", s.companionFiles)
+			}
+
+			identities := test.identities
+			if test.expectKey {
+				scrypt, err := age.NewScryptIdentity(test.config.AgePassphrase)
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				recovered := decryptAge(t, s.companionFiles[1], scrypt)
+				recoveryIdentities, err := age.ParseIdentities(bytes.NewReader(recovered))
+				if err != nil {
+					t.Fatalf("Unexpected error parsing recovery key %v", err)
+				}
+				identities = append(identities, recoveryIdentities...)
+			}
+
+			for _, identity := range identities {
+				if plaintext := decryptAge(t, s.file, identity); string(plaintext) != "backup" {
+					t.Errorf("Unexpected plaintext %s", plaintext)
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptArchiveGPG(t *testing.T) {
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	var public bytes.Buffer
+	w, err := armor.Encode(&public, "PGP PUBLIC KEY BLOCK", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	w.Close()
+	keyRing := filepath.Join(t.TempDir(), "keyring.asc")
+	if err := os.WriteFile(keyRing, public.Bytes(), 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := os.WriteFile(file, []byte("backup"), 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	s := newScript(&Config{
+		GpgPassphrase:         "test",
+		GpgPublicKeyRingFiles: []string{keyRing},
+	})
+	s.file = file
+	if err := s.encryptArchive(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	for name, decrypt := range map[string]func(r io.Reader) (*openpgp.MessageDetails, error){
+		"private key": func(r io.Reader) (*openpgp.MessageDetails, error) {
+			return openpgp.ReadMessage(r, openpgp.EntityList{entity}, nil, nil)
+		},
+		"passphrase": func(r io.Reader) (*openpgp.MessageDetails, error) {
+			return openpgp.ReadMessage(r, openpgp.EntityList{}, func([]openpgp.Key, bool) ([]byte, error) {
+				return []byte("test"), nil
+			}, nil)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(s.file)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			defer f.Close()
+			block, err := armor.Decode(f)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			md, err := decrypt(block.Body)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if plaintext, err := io.ReadAll(md.UnverifiedBody); err != nil || string(plaintext) != "backup" {
+				t.Errorf("Unexpected plaintext %s, %v", plaintext, err)
+			}
+		})
+	}
+}
+
+func decryptAge(t *testing.T, file string, identity age.Identity) []byte {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer f.Close()
+	r, err := age.Decrypt(f, identity)
+	if err != nil {
+		t.Fatalf("Unexpected error decrypting %s: %v", file, err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return b
+}
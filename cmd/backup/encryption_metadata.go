@@ -0,0 +1,198 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	openpgp "github.com/ProtonMail/go-crypto/openpgp/v2"
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+)
+
+// metadataExtension is appended to the name of an encrypted backup file
+// for storing its encryption metadata.
+const metadataExtension = "meta.json"
+
+const (
+	keyTypePassphrase   = "passphrase"
+	keyTypeX25519       = "X25519"
+	keyTypeOpenPGP      = "openpgp"
+	keyTypeVaultTransit = "vault-transit"
+)
+
+// encryptionMetadata describes how a backup file has been encrypted, so
+// that the matching key can be picked when restoring it, even after the
+// configured keys have been rotated.
+type encryptionMetadata struct {
+	Version     int             `json:"version"`
+	Created     time.Time       `json:"created"`
+	Tool        string          `json:"tool"`
+	ToolVersion string          `json:"toolVersion"`
+	Format      string          `json:"format"`
+	Algorithm   string          `json:"algorithm"`
+	Library     string          `json:"library,omitempty"`
+	Keys        []encryptionKey `json:"keys"`
+}
+
+// encryptionKey describes a single key a backup file has been encrypted
+// for. For X25519 keys, the fingerprint is the public key itself, SSH keys
+// use their SHA256 fingerprint and OpenPGP keys the fingerprint of their
+// primary key. Vault Transit keys are identified by their mount path and
+// name. Passphrases do not have a fingerprint.
+type encryptionKey struct {
+	Type        string `json:"type"`
+	Algorithm   string `json:"algorithm,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Recovery is set for the one-off key that is stored alongside the
+	// backup, protected by the passphrase.
+	Recovery bool `json:"recovery,omitempty"`
+	// WrappedKey is the data key as wrapped by Vault Transit.
+	WrappedKey string `json:"wrappedKey,omitempty"`
+}
+
+func (k encryptionKey) String() string {
+	switch {
+	case k.Fingerprint == "":
+		return k.Type
+	case k.Recovery:
+		return fmt.Sprintf("%s recovery key %s", k.Type, k.Fingerprint)
+	case k.Algorithm != "":
+		return fmt.Sprintf("%s %s key %s", k.Type, k.Algorithm, k.Fingerprint)
+	}
+	return fmt.Sprintf("%s key %s", k.Type, k.Fingerprint)
+}
+
+// writeEncryptionMetadata saves the metadata of the encrypted backup file
+// next to it.
+func (s *script) writeEncryptionMetadata(extension string) error {
+	metadata := encryptionMetadata{
+		Version:     1,
+		Created:     time.Now().UTC(),
+		Tool:        "docker-volume-backup",
+		ToolVersion: toolVersion(),
+		Format:      extension,
+		Keys:        s.encryptionKeys,
+	}
+	switch extension {
+	case "age":
+		metadata.Algorithm = "age-encryption.org/v1"
+		metadata.Library = moduleVersion("filippo.io/age")
+	case "gpg":
+		metadata.Algorithm = "OpenPGP"
+		metadata.Library = moduleVersion("github.com/ProtonMail/go-crypto")
+	}
+
+	b, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return errwrap.Wrap(err, "error marshaling encryption metadata")
+	}
+
+	metadataFile := fmt.Sprintf("%s.%s", s.file, metadataExtension)
+	s.registerHook(hookLevelPlumbing, func(error) error {
+		if err := remove(metadataFile); err != nil {
+			return errwrap.Wrap(err, "error removing encryption metadata file")
+		}
+		s.logger.Info(
+			fmt.Sprintf("Removed encryption metadata file `%s`.", metadataFile),
+		)
+		return nil
+	})
+	if err := os.WriteFile(metadataFile, append(b, '\n'), 0644); err != nil {
+		return errwrap.Wrap(err, "error writing encryption metadata file")
+	}
+
+	s.companionFiles = append(s.companionFiles, metadataFile)
+	s.logger.Info(
+		fmt.Sprintf("Saved encryption metadata as %q", metadataFile),
+	)
+	return nil
+}
+
+// readEncryptionMetadata reads the metadata stored at the given location.
+func readEncryptionMetadata(file string) (*encryptionMetadata, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errwrap.Wrap(err, fmt.Sprintf("error reading encryption metadata %s", file))
+	}
+	var metadata encryptionMetadata
+	if err := json.Unmarshal(b, &metadata); err != nil {
+		return nil, errwrap.Wrap(err, fmt.Sprintf("error parsing encryption metadata %s", file))
+	}
+	if metadata.Version != 1 {
+		return nil, errwrap.Wrap(nil, fmt.Sprintf("unsupported encryption metadata version %d", metadata.Version))
+	}
+	return &metadata, nil
+}
+
+// describeGPGRecipients returns a description of each of the given entities.
+func describeGPGRecipients(entityList openpgp.EntityList) []encryptionKey {
+	var keys []encryptionKey
+	for _, entity := range entityList {
+		keys = append(keys, encryptionKey{
+			Type:        keyTypeOpenPGP,
+			Algorithm:   publicKeyAlgorithmName(entity.PrimaryKey.PubKeyAlgo),
+			Fingerprint: gpgFingerprint(entity),
+		})
+	}
+	return keys
+}
+
+func gpgFingerprint(entity *openpgp.Entity) string {
+	return fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)
+}
+
+func publicKeyAlgorithmName(algo packet.PublicKeyAlgorithm) string {
+	switch algo {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSAEncryptOnly, packet.PubKeyAlgoRSASignOnly:
+		return "RSA"
+	case packet.PubKeyAlgoElGamal:
+		return "ElGamal"
+	case packet.PubKeyAlgoDSA:
+		return "DSA"
+	case packet.PubKeyAlgoECDH:
+		return "ECDH"
+	case packet.PubKeyAlgoECDSA:
+		return "ECDSA"
+	case packet.PubKeyAlgoEdDSA:
+		return "EdDSA"
+	case packet.PubKeyAlgoX25519:
+		return "X25519"
+	case packet.PubKeyAlgoX448:
+		return "X448"
+	case packet.PubKeyAlgoEd25519:
+		return "Ed25519"
+	case packet.PubKeyAlgoEd448:
+		return "Ed448"
+	}
+	return fmt.Sprintf("algorithm %d", algo)
+}
+
+// toolVersion returns the version of the running binary as recorded at
+// build time.
+func toolVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// moduleVersion returns the given module and the version it has been built
+// with.
+func moduleVersion(path string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return path
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			return fmt.Sprintf("%s %s", path, dep.Version)
+		}
+	}
+	return path
+}
@@ -14,6 +14,7 @@ import (
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/cosiner/argv"
 	"github.com/docker/docker/api/types/container"
@@ -174,6 +175,10 @@ func (s *script) runLabeledCommands(label string) error {
 			userLabelName := fmt.Sprintf("%s.user", label)
 			user := c.Labels[userLabelName]
 
+			if s.c.dryRun {
+				s.logger.Info(fmt.Sprintf("Would run %s command %s for container %s", label, cmd, strings.TrimPrefix(c.Names[0], "/")))
+				return nil
+			}
 			s.logger.Info(fmt.Sprintf("Running %s command %s for container %s", label, cmd, strings.TrimPrefix(c.Names[0], "/")))
 			stdout, stderr, err := s.exec(c.ID, cmd, user)
 			if s.c.ExecForwardOutput {
@@ -208,9 +213,9 @@ const (
 
 func (s *script) withLabeledCommands(step lifecyclePhase, cb func() error) func() error {
 	if s.cli == nil {
-		return cb
+		return s.withPhaseStats(step, cb)
 	}
-	return func() (err error) {
+	return s.withPhaseStats(step, func() (err error) {
 		if err = s.runLabeledCommands(fmt.Sprintf("docker-volume-backup.%s-pre", step)); err != nil {
 			err = errwrap.Wrap(err, fmt.Sprintf("error running %s-pre commands", step))
 			return
@@ -222,5 +227,18 @@ func (s *script) withLabeledCommands(step lifecyclePhase, cb func() error) func(
 		}()
 		err = cb()
 		return
+	})
+}
+
+// withPhaseStats records the time it takes to run the given phase.
+func (s *script) withPhaseStats(step lifecyclePhase, cb func() error) func() error {
+	return func() error {
+		start := time.Now()
+		defer func() {
+			s.stats.Lock()
+			s.stats.Phases[string(step)] = PhaseStats{Duration: time.Since(start)}
+			s.stats.Unlock()
+		}()
+		return cb()
 	}
 }
@@ -0,0 +1,341 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+)
+
+// repeatedFailuresThreshold is the number of consecutive failed runs after
+// which warnings about repeated failures are logged.
+const repeatedFailuresThreshold = 3
+
+type runOutcome string
+
+const (
+	runOutcomeSuccess  runOutcome = "success"
+	runOutcomeDegraded runOutcome = "degraded"
+	runOutcomeFailure  runOutcome = "failure"
+)
+
+// outcomeOf returns the outcome of a run given its stats and the error it
+// returned.
+func outcomeOf(stats *Stats, err error) runOutcome {
+	switch {
+	case err != nil:
+		return runOutcomeFailure
+	case stats != nil && stats.Degraded:
+		return runOutcomeDegraded
+	default:
+		return runOutcomeSuccess
+	}
+}
+
+// historyEntry is a single run as recorded in the history journal.
+type historyEntry struct {
+	Config     string
+	Source     string
+	StartTime  time.Time
+	EndTime    time.Time
+	Outcome    runOutcome
+	Error      string `json:",omitempty"`
+	BackupFile BackupFileStats
+	Storages   map[string]StorageStats
+}
+
+// recordHistory appends an entry describing the current run to the configured
+// history journal. In case the run failed and previous runs have been failing
+// too, a warning is logged.
+func (s *script) recordHistory(runErr error) error {
+	if s.c.BackupHistoryFile == "" {
+		return nil
+	}
+
+	entry := historyEntry{
+		Config:     s.c.name,
+		Source:     s.c.source,
+		StartTime:  s.stats.StartTime,
+		EndTime:    s.stats.EndTime,
+		Outcome:    outcomeOf(s.stats, runErr),
+		BackupFile: s.stats.BackupFile,
+		Storages:   map[string]StorageStats{},
+	}
+	if entry.EndTime.IsZero() {
+		entry.EndTime = time.Now()
+	}
+	if runErr != nil {
+		entry.Error = runErr.Error()
+	}
+	for _, backend := range s.storages {
+		entry.Storages[backend.Name()] = s.stats.Storages[backend.Name()]
+	}
+
+	if err := appendHistory(s.c.BackupHistoryFile, entry, s.c.BackupHistoryMaxEntries.Int()); err != nil {
+		return errwrap.Wrap(err, "error appending to history")
+	}
+
+	if runErr == nil {
+		return nil
+	}
+	entries, err := readHistory(s.c.BackupHistoryFile)
+	if err != nil {
+		return errwrap.Wrap(err, "error reading history")
+	}
+	if n := consecutiveFailures(entries, s.c.name); n >= repeatedFailuresThreshold {
+		s.logger.Warn(fmt.Sprintf("The last %d runs of this configuration have failed.", n))
+	}
+	return nil
+}
+
+// historyMu serializes writes to history journals, as runs of different
+// configurations might share a journal.
+var historyMu sync.Mutex
+
+// appendHistory appends the given entry to the journal at the given location,
+// creating the file and its parent directories if needed. In case maxEntries
+// is not zero, older entries are dropped so the journal holds at most
+// maxEntries entries.
+func appendHistory(file string, entry historyEntry, maxEntries int) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	if err := writeHistoryEntry(file, entry); err != nil {
+		return err
+	}
+	if maxEntries == 0 {
+		return nil
+	}
+	return trimHistory(file, maxEntries)
+}
+
+func writeHistoryEntry(file string, entry historyEntry) (err error) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return errwrap.Wrap(err, "error marshaling entry")
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error creating directory for %s", file))
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error opening %s", file))
+	}
+	defer func() {
+		err = errors.Join(err, f.Close())
+	}()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error writing to %s", file))
+	}
+	return nil
+}
+
+// trimHistory rewrites the journal at the given location so it only holds
+// the given number of most recent entries. The journal is replaced
+// atomically, so it is never left partially written.
+func trimHistory(file string, maxEntries int) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error reading %s", file))
+	}
+	lines := slices.DeleteFunc(bytes.Split(b, []byte("\n")), func(line []byte) bool {
+		return len(line) == 0
+	})
+	if len(lines) <= maxEntries {
+		return nil
+	}
+	lines = lines[len(lines)-maxEntries:]
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, append(bytes.Join(lines, []byte("\n")), '\n'), 0644); err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error writing %s", tmp))
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error replacing %s", file))
+	}
+	return nil
+}
+
+// readHistory reads all entries from the journal at the given location.
+// A journal that does not exist yet is considered empty.
+func readHistory(file string) ([]historyEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errwrap.Wrap(err, fmt.Sprintf("error opening %s", file))
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A partially written line is skipped instead of rendering the
+			// entire journal unusable.
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errwrap.Wrap(err, fmt.Sprintf("error reading %s", file))
+	}
+	return entries, nil
+}
+
+// consecutiveFailures returns the number of most recent runs for the given
+// configuration that have failed in a row.
+func consecutiveFailures(entries []historyEntry, config string) int {
+	var n int
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Config != config {
+			continue
+		}
+		if entries[i].Outcome != runOutcomeFailure {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// checkHistory logs warnings in case the history journal indicates runs
+// of the given configuration have been missed or have been failing repeatedly.
+func (c *command) checkHistory(config *Config) {
+	if config.BackupHistoryFile == "" {
+		return
+	}
+	entries, err := readHistory(config.BackupHistoryFile)
+	if err != nil {
+		c.logger.Warn(fmt.Sprintf("Unable to read history for %s: %v", config.source, err))
+		return
+	}
+
+	var last *historyEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Config == config.name {
+			last = &entries[i]
+			break
+		}
+	}
+	if last == nil {
+		return
+	}
+
+	if sched, err := scheduleParser.Parse(config.BackupCronExpression); err == nil {
+		next := sched.Next(last.StartTime)
+		if !next.IsZero() && next.Before(time.Now().Add(-time.Minute)) {
+			c.logger.Warn(
+				fmt.Sprintf(
+					"Backup %s last ran at %s and was expected to run again at %s, but did not. Runs might have been missed while the process was not running.",
+					config.source,
+					last.StartTime.Format(time.RFC3339),
+					next.Format(time.RFC3339),
+				),
+			)
+		}
+	}
+
+	if n := consecutiveFailures(entries, config.name); n >= repeatedFailuresThreshold {
+		c.logger.Warn(fmt.Sprintf("The last %d runs of backup %s have failed.", n, config.source))
+	}
+}
+
+// runHistory implements the `history` subcommand which prints the runs
+// recorded in the history journal.
+func (c *command) runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	limit := fs.Int("n", 20, "number of most recent runs to show, use 0 to show all runs")
+	name := fs.String("config", "", "only show runs of the configuration with the given name")
+	file := fs.String("file", "", "read the given journal instead of the ones that are configured")
+	asJSON := fs.Bool("json", false, "print entries as JSON lines")
+	if err := fs.Parse(args); err != nil {
+		return errwrap.Wrap(err, "error parsing flags")
+	}
+
+	var files []string
+	if *file != "" {
+		files = append(files, *file)
+	} else {
+		configurations, err := sourceConfiguration(configStrategyFile)
+		if err != nil {
+			return errwrap.Wrap(err, "error sourcing configuration")
+		}
+		for _, config := range configurations {
+			if config.BackupHistoryFile != "" && !slices.Contains(files, config.BackupHistoryFile) {
+				files = append(files, config.BackupHistoryFile)
+			}
+		}
+	}
+
+	var entries []historyEntry
+	for _, f := range files {
+		fileEntries, err := readHistory(f)
+		if err != nil {
+			return errwrap.Wrap(err, "error reading history")
+		}
+		for _, entry := range fileEntries {
+			if *name != "" && entry.Config != *name && entry.Source != *name {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartTime.Before(entries[j].StartTime)
+	})
+	if *limit > 0 && len(entries) > *limit {
+		entries = entries[len(entries)-*limit:]
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, entry := range entries {
+			if err := enc.Encode(entry); err != nil {
+				return errwrap.Wrap(err, "error encoding entry")
+			}
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tCONFIG\tOUTCOME\tDURATION\tARCHIVE\tSIZE\tERROR")
+	for _, entry := range entries {
+		archive, size := "-", "-"
+		if entry.BackupFile.Name != "" {
+			archive = entry.BackupFile.Name
+			size = formatBytes(entry.BackupFile.Size, false)
+		}
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.StartTime.Format(time.RFC3339),
+			entry.Config,
+			entry.Outcome,
+			entry.EndTime.Sub(entry.StartTime).Round(time.Second),
+			archive,
+			size,
+			entry.Error,
+		)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutcomeOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		stats    *Stats
+		err      error
+		expected runOutcome
+	}{
+		{"success", &Stats{}, nil, runOutcomeSuccess},
+		{"no stats", nil, nil, runOutcomeSuccess},
+		{"degraded", &Stats{Degraded: true}, nil, runOutcomeDegraded},
+		{"failure", &Stats{}, errors.New("boom"), runOutcomeFailure},
+		{"degraded failure", &Stats{Degraded: true}, errors.New("boom"), runOutcomeFailure},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := outcomeOf(test.stats, test.err); result != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestConsecutiveFailures(t *testing.T) {
+	entry := func(config string, outcome runOutcome) historyEntry {
+		return historyEntry{Config: config, Outcome: outcome}
+	}
+	tests := []struct {
+		name     string
+		entries  []historyEntry
+		expected int
+	}{
+		{"empty", nil, 0},
+		{
+			"last run succeeded",
+			[]historyEntry{entry("a", runOutcomeFailure), entry("a", runOutcomeSuccess)},
+			0,
+		},
+		{
+			"failures after success",
+			[]historyEntry{entry("a", runOutcomeSuccess), entry("a", runOutcomeFailure), entry("a", runOutcomeFailure)},
+			2,
+		},
+		{
+			"other configs are ignored",
+			[]historyEntry{
+				entry("a", runOutcomeFailure),
+				entry("b", runOutcomeSuccess),
+				entry("a", runOutcomeFailure),
+				entry("b", runOutcomeFailure),
+			},
+			2,
+		},
+		{
+			"degraded runs are not failures",
+			[]historyEntry{entry("a", runOutcomeFailure), entry("a", runOutcomeDegraded), entry("a", runOutcomeFailure)},
+			1,
+		},
+		{
+			"no runs of config",
+			[]historyEntry{entry("b", runOutcomeFailure)},
+			0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := consecutiveFailures(test.entries, "a"); result != test.expected {
+				t.Errorf("Expected %d, got %d", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestReadHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{"missing", "", nil},
+		{
+			"complete",
+			"{\"Config\":\"a\"}\n{\"Config\":\"b\"}\n",
+			[]string{"a", "b"},
+		},
+		{
+			"truncated last line",
+			"{\"Config\":\"a\"}\n{\"Config\":\"b\"}\n{\"Config\":\"c\",\"Outc",
+			[]string{"a", "b"},
+		},
+		{
+			"blank lines",
+			"{\"Config\":\"a\"}\n\n{\"Config\":\"b\"}\n",
+			[]string{"a", "b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "history.jsonl")
+			if test.content != "" {
+				if err := os.WriteFile(file, []byte(test.content), 0644); err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+			}
+			entries, err := readHistory(file)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			var configs []string
+			for _, entry := range entries {
+				configs = append(configs, entry.Config)
+			}
+			if strings.Join(configs, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("Expected entries %v, got %v", test.expected, configs)
+			}
+		})
+	}
+}
+
+func TestAppendHistory(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		expected   []string
+	}{
+		{"unlimited", 0, []string{"0", "1", "2", "3", "4"}},
+		{"capped", 3, []string{"2", "3", "4"}},
+		{"not reached", 10, []string{"0", "1", "2", "3", "4"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "history", "history.jsonl")
+			for i := range 5 {
+				entry := historyEntry{Config: strconv.Itoa(i), Outcome: runOutcomeSuccess}
+				if err := appendHistory(file, entry, test.maxEntries); err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+			}
+			entries, err := readHistory(file)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			var configs []string
+			for _, entry := range entries {
+				configs = append(configs, entry.Config)
+			}
+			if strings.Join(configs, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("Expected entries %v, got %v", test.expected, configs)
+			}
+		})
+	}
+}
+
+func TestCheckHistory(t *testing.T) {
+	tests := []struct {
+		name           string
+		cronExpression string
+		entries        []historyEntry
+		expectMissed   bool
+		expectFailures bool
+	}{
+		{
+			"no runs",
+			"@hourly",
+			[]historyEntry{{Config: "other", StartTime: time.Now().Add(-3 * time.Hour)}},
+			false,
+			false,
+		},
+		{
+			"run missed",
+			"0 * * * *",
+			[]historyEntry{{Config: "test", StartTime: time.Now().Add(-3 * time.Hour)}},
+			true,
+			false,
+		},
+		{
+			"next run pending",
+			"0 * * * *",
+			[]historyEntry{{Config: "test", StartTime: time.Now().Add(-30 * time.Second)}},
+			false,
+			false,
+		},
+		{
+			"six fields run missed",
+			"*/10 * * * * *",
+			[]historyEntry{{Config: "test", StartTime: time.Now().Add(-5 * time.Minute)}},
+			true,
+			false,
+		},
+		{
+			"six fields next run pending",
+			"0 0 * * * *",
+			[]historyEntry{{Config: "test", StartTime: time.Now().Add(-30 * time.Second)}},
+			false,
+			false,
+		},
+		{
+			"only last run of config counts",
+			"0 * * * *",
+			[]historyEntry{
+				{Config: "test", StartTime: time.Now().Add(-3 * time.Hour)},
+				{Config: "test", StartTime: time.Now().Add(-30 * time.Second)},
+				{Config: "other", StartTime: time.Now().Add(-3 * time.Hour)},
+			},
+			false,
+			false,
+		},
+		{
+			"repeated failures",
+			"0 * * * *",
+			[]historyEntry{
+				{Config: "test", Outcome: runOutcomeFailure, StartTime: time.Now().Add(-3 * time.Minute)},
+				{Config: "test", Outcome: runOutcomeFailure, StartTime: time.Now().Add(-2 * time.Minute)},
+				{Config: "test", Outcome: runOutcomeFailure, StartTime: time.Now().Add(-30 * time.Second)},
+			},
+			false,
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "history.jsonl")
+			for _, entry := range test.entries {
+				if err := appendHistory(file, entry, 0); err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+			}
+			config := mustLoadConfig(t, map[string]string{
+				"BACKUP_HISTORY_FILE":    file,
+				"BACKUP_CRON_EXPRESSION": test.cronExpression,
+			})
+			config.name = "test"
+
+			var buf bytes.Buffer
+			c := &command{logger: slog.New(slog.NewTextHandler(&buf, nil))}
+			c.checkHistory(config)
+
+			if missed := strings.Contains(buf.String(), "Runs might have been missed"); missed != test.expectMissed {
+				t.Errorf("Expected missed runs warning to be %v, got %s", test.expectMissed, buf.String())
+			}
+			if failures := strings.Contains(buf.String(), "have failed"); failures != test.expectFailures {
+				t.Errorf("Expected repeated failures warning to be %v, got %s", test.expectFailures, buf.String())
+			}
+		})
+	}
+}
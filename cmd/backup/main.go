@@ -5,20 +5,49 @@ package main
 
 import (
 	"flag"
+	"fmt"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
 )
 
 func main() {
 	foreground := flag.Bool("foreground", false, "run the tool in the foreground")
 	profile := flag.String("profile", "", "collect runtime metrics and log them periodically on the given cron expression")
+	httpAddr := flag.String("http-addr", "", "serve an HTTP API for triggering runs and querying status on the given address when running in the foreground")
+	httpToken := flag.String("http-token", "", "token required for authenticating requests against the HTTP API")
+	dryRun := flag.Bool("dry-run", false, "report what a backup run would do without making any changes")
 	flag.Parse()
 
 	c := newCommand()
+	switch flag.Arg(0) {
+	case "":
+	case "history":
+		c.must(c.runHistory(flag.Args()[1:]))
+		return
+	case "config":
+		c.must(c.runConfig(flag.Args()[1:]))
+		return
+	case "verify":
+		c.must(c.runVerify(flag.Args()[1:]))
+		return
+	case "decrypt":
+		c.must(c.runDecrypt(flag.Args()[1:]))
+		return
+	default:
+		c.must(errwrap.Wrap(nil, fmt.Sprintf("unknown command %s", flag.Arg(0))))
+	}
+
 	if *foreground {
+		if *dryRun {
+			c.must(errwrap.Wrap(nil, "-dry-run cannot be used when running in the foreground"))
+		}
 		opts := foregroundOpts{
 			profileCronExpression: *profile,
+			httpAddr:              *httpAddr,
+			httpToken:             *httpToken,
 		}
 		c.must(c.runInForeground(opts))
 	} else {
-		c.must(c.runAsCommand())
+		c.must(c.runAsCommand(*dryRun))
 	}
 }
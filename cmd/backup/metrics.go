@@ -0,0 +1,283 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+)
+
+const metricsNamespace = "docker_volume_backup"
+
+// metric is a single family of metrics in the Prometheus text format.
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	samples []metricSample
+}
+
+type metricSample struct {
+	labels []string
+	value  float64
+}
+
+func newMetric(kind, name, help string) *metric {
+	return &metric{
+		name: fmt.Sprintf("%s_%s", metricsNamespace, name),
+		help: help,
+		kind: kind,
+	}
+}
+
+// add adds a sample using the given value. Labels are passed as
+// alternating names and values.
+func (m *metric) add(value float64, labels ...string) {
+	m.samples = append(m.samples, metricSample{labels: labels, value: value})
+}
+
+func (m *metric) write(w io.Writer) error {
+	if len(m.samples) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind); err != nil {
+		return err
+	}
+	for _, sample := range m.samples {
+		var labels []string
+		for i := 0; i+1 < len(sample.labels); i += 2 {
+			labels = append(labels, fmt.Sprintf("%s=%s", sample.labels[i], escapeLabelValue(sample.labels[i+1])))
+		}
+		if _, err := fmt.Fprintf(
+			w, "%s{%s} %s\n", m.name, strings.Join(labels, ","), strconv.FormatFloat(sample.value, 'f', -1, 64),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(v string) string {
+	return fmt.Sprintf(`"%s"`, labelValueEscaper.Replace(v))
+}
+
+// runMetrics is the data about the most recent run of a single
+// configuration that is exposed as metrics.
+type runMetrics struct {
+	config      string
+	stats       *Stats
+	failed      bool
+	lastSuccess time.Time
+	// counters are only available when running in the foreground
+	outcomes     map[runOutcome]uint
+	uploadErrors map[string]uint
+}
+
+// writeMetrics writes metrics about the given runs in the Prometheus
+// text exposition format.
+func writeMetrics(w io.Writer, runs []runMetrics) error {
+	var (
+		lastRun         = newMetric("gauge", "last_run_timestamp_seconds", "Time the most recent run started.")
+		lastRunOK       = newMetric("gauge", "last_run_success", "Whether the most recent run succeeded.")
+		lastRunDegraded = newMetric("gauge", "last_run_degraded", "Whether the most recent run succeeded although some backends failed.")
+		lastSuccess     = newMetric("gauge", "last_success_timestamp_seconds", "Time the most recent successful or degraded run finished.")
+		duration        = newMetric("gauge", "last_run_duration_seconds", "Duration of the most recent run.")
+		lockWait        = newMetric("gauge", "last_run_lock_wait_seconds", "Time the most recent run waited for the lock.")
+		phaseDuration   = newMetric("gauge", "phase_duration_seconds", "Duration of each phase of the most recent run.")
+		archiveSize     = newMetric("gauge", "archive_size_bytes", "Size of the archive created by the most recent run.")
+		stopped         = newMetric("gauge", "containers_stopped", "Number of containers stopped during the most recent run.")
+		scaledDown      = newMetric("gauge", "services_scaled_down", "Number of services scaled down during the most recent run.")
+		uploadOK        = newMetric("gauge", "upload_success", "Whether uploading to the backend succeeded in the most recent run.")
+		uploadTime      = newMetric("gauge", "upload_duration_seconds", "Duration of uploading to the backend in the most recent run.")
+		uploadBytes     = newMetric("gauge", "upload_bytes", "Bytes uploaded to the backend in the most recent run.")
+		uploadVerified  = newMetric("gauge", "upload_verified", "Whether the upload to the backend has been verified in the most recent run.")
+		backups         = newMetric("gauge", "backups", "Number of backups found in the backend when pruning in the most recent run.")
+		pruned          = newMetric("gauge", "pruned_backups", "Number of backups pruned from the backend in the most recent run.")
+		runsTotal       = newMetric("counter", "runs_total", "Number of runs since the process started.")
+		uploadErrors    = newMetric("counter", "upload_errors_total", "Number of failed uploads since the process started.")
+	)
+
+	for _, run := range runs {
+		if !run.lastSuccess.IsZero() {
+			lastSuccess.add(float64(run.lastSuccess.Unix()), "config", run.config)
+		}
+		for _, outcome := range []runOutcome{runOutcomeSuccess, runOutcomeDegraded, runOutcomeFailure} {
+			if run.outcomes != nil {
+				runsTotal.add(float64(run.outcomes[outcome]), "config", run.config, "outcome", string(outcome))
+			}
+		}
+
+		stats := run.stats
+		if stats == nil {
+			continue
+		}
+		lastRun.add(float64(stats.StartTime.Unix()), "config", run.config)
+		lastRunOK.add(boolToFloat(!run.failed), "config", run.config)
+		lastRunDegraded.add(boolToFloat(!run.failed && stats.Degraded), "config", run.config)
+		duration.add(stats.TookTime.Seconds(), "config", run.config)
+		lockWait.add(stats.LockedTime.Seconds(), "config", run.config)
+		if stats.BackupFile.Name != "" {
+			archiveSize.add(float64(stats.BackupFile.Size), "config", run.config)
+		}
+		stopped.add(float64(stats.Containers.Stopped), "config", run.config)
+		scaledDown.add(float64(stats.Services.ScaledDown), "config", run.config)
+
+		for _, phase := range sortedKeys(stats.Phases) {
+			phaseDuration.add(stats.Phases[phase].Duration.Seconds(), "config", run.config, "phase", phase)
+		}
+
+		for _, backend := range sortedKeys(stats.Storages) {
+			storageStats := stats.Storages[backend]
+			// Backends that have not been operated upon in this run are skipped.
+			if storageStats.UploadDuration > 0 {
+				uploadOK.add(boolToFloat(storageStats.Uploaded), "config", run.config, "backend", backend)
+				uploadTime.add(storageStats.UploadDuration.Seconds(), "config", run.config, "backend", backend)
+				uploadBytes.add(float64(storageStats.UploadBytes), "config", run.config, "backend", backend)
+				uploadVerified.add(boolToFloat(storageStats.Verified), "config", run.config, "backend", backend)
+			}
+			if storageStats.Total > 0 {
+				backups.add(float64(storageStats.Total), "config", run.config, "backend", backend)
+				pruned.add(float64(storageStats.Pruned), "config", run.config, "backend", backend)
+			}
+		}
+		for _, backend := range sortedKeys(run.uploadErrors) {
+			uploadErrors.add(float64(run.uploadErrors[backend]), "config", run.config, "backend", backend)
+		}
+	}
+
+	for _, m := range []*metric{
+		lastRun, lastRunOK, lastRunDegraded, lastSuccess, duration, lockWait, phaseDuration, archiveSize,
+		stopped, scaledDown, uploadOK, uploadTime, uploadBytes, uploadVerified, backups, pruned,
+		runsTotal, uploadErrors,
+	} {
+		if err := m.write(w); err != nil {
+			return errwrap.Wrap(err, fmt.Sprintf("error writing metric %s", m.name))
+		}
+	}
+	return nil
+}
+
+// writeMetricsTextfile writes metrics about the current run to the configured
+// location, so it can be picked up by the textfile collector of the Prometheus
+// node exporter. The file is replaced atomically.
+func (s *script) writeMetricsTextfile(runErr error) (err error) {
+	if s.c.BackupMetricsTextfile == "" {
+		return nil
+	}
+
+	run := runMetrics{
+		config: s.c.name,
+		stats:  s.stats,
+		failed: runErr != nil,
+	}
+	if runErr == nil {
+		run.lastSuccess = s.stats.EndTime
+	} else if s.c.BackupHistoryFile != "" {
+		entries, err := readHistory(s.c.BackupHistoryFile)
+		if err != nil {
+			return errwrap.Wrap(err, "error reading history")
+		}
+		for _, entry := range entries {
+			if entry.Config == s.c.name && entry.Outcome != runOutcomeFailure {
+				run.lastSuccess = entry.EndTime
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeMetrics(&buf, []runMetrics{run}); err != nil {
+		return errwrap.Wrap(err, "error rendering metrics")
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.c.BackupMetricsTextfile), ".metrics-*.tmp")
+	if err != nil {
+		return errwrap.Wrap(err, "error creating temporary file")
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return errors.Join(errwrap.Wrap(err, "error writing metrics"), f.Close())
+	}
+	if err := f.Close(); err != nil {
+		return errwrap.Wrap(err, "error closing temporary file")
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return errwrap.Wrap(err, "error setting file permissions")
+	}
+	if err := os.Rename(f.Name(), s.c.BackupMetricsTextfile); err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error moving metrics to %s", s.c.BackupMetricsTextfile))
+	}
+	return nil
+}
+
+// handleMetrics exposes metrics about the most recent run of each
+// configuration in the Prometheus text format.
+func (c *command) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	var runs []runMetrics
+	for _, config := range c.configurations {
+		status, ok := c.runs[config.name]
+		if !ok {
+			continue
+		}
+		run := runMetrics{
+			config:       config.name,
+			stats:        status.stats,
+			lastSuccess:  status.lastSuccess,
+			outcomes:     map[runOutcome]uint{},
+			uploadErrors: map[string]uint{},
+		}
+		if status.lastRun != nil {
+			run.failed = status.lastRun.Error != ""
+		}
+		for k, v := range status.outcomes {
+			run.outcomes[k] = v
+		}
+		for k, v := range status.uploadErrors {
+			run.uploadErrors[k] = v
+		}
+		runs = append(runs, run)
+	}
+	c.mu.Unlock()
+
+	var buf bytes.Buffer
+	if err := writeMetrics(&buf, runs); err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{Error: err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
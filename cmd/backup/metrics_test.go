@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	stats := &Stats{
+		StartTime:  start,
+		TookTime:   90 * time.Second,
+		BackupFile: BackupFileStats{Name: "backup.tar.gz", Size: 2048},
+		Phases: map[string]PhaseStats{
+			"archive": {Duration: 30 * time.Second},
+		},
+		Storages: map[string]StorageStats{
+			"S3":    {Uploaded: true, UploadDuration: 2 * time.Second, UploadBytes: 2048, Verified: true, Total: 3, Pruned: 1},
+			"Local": {},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		runs        []runMetrics
+		expected    []string
+		notExpected []string
+	}{
+		{
+			"successful run",
+			[]runMetrics{{config: "default", stats: stats, lastSuccess: start.Add(time.Minute)}},
+			[]string{
+				"# TYPE docker_volume_backup_last_run_success gauge\n",
+				`docker_volume_backup_last_run_success{config="default"} 1` + "\n",
+				`docker_volume_backup_last_success_timestamp_seconds{config="default"} 1700000060` + "\n",
+				`docker_volume_backup_last_run_duration_seconds{config="default"} 90` + "\n",
+				`docker_volume_backup_phase_duration_seconds{config="default",phase="archive"} 30` + "\n",
+				`docker_volume_backup_archive_size_bytes{config="default"} 2048` + "\n",
+				`docker_volume_backup_upload_bytes{config="default",backend="S3"} 2048` + "\n",
+				`docker_volume_backup_upload_verified{config="default",backend="S3"} 1` + "\n",
+				`docker_volume_backup_pruned_backups{config="default",backend="S3"} 1` + "\n",
+			},
+			[]string{
+				`backend="Local"`,
+				"docker_volume_backup_runs_total",
+			},
+		},
+		{
+			"failed run with counters",
+			[]runMetrics{{
+				config:       `we"ird`,
+				stats:        stats,
+				failed:       true,
+				outcomes:     map[runOutcome]uint{runOutcomeFailure: 2},
+				uploadErrors: map[string]uint{"S3": 2},
+			}},
+			[]string{
+				`docker_volume_backup_last_run_success{config="we\"ird"} 0` + "\n",
+				`docker_volume_backup_runs_total{config="we\"ird",outcome="success"} 0` + "\n",
+				`docker_volume_backup_runs_total{config="we\"ird",outcome="failure"} 2` + "\n",
+				`docker_volume_backup_upload_errors_total{config="we\"ird",backend="S3"} 2` + "\n",
+			},
+			[]string{
+				"docker_volume_backup_last_success_timestamp_seconds",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeMetrics(&buf, test.runs); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			for _, e := range test.expected {
+				if !strings.Contains(buf.String(), e) {
+					t.Errorf("Expected output to contain %q, got %s", e, buf.String())
+				}
+			}
+			for _, e := range test.notExpected {
+				if strings.Contains(buf.String(), e) {
+					t.Errorf("Expected output not to contain %q, got %s", e, buf.String())
+				}
+			}
+		})
+	}
+}
@@ -10,11 +10,13 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"text/template"
 	"time"
 
 	sTypes "github.com/containrrr/shoutrrr/pkg/types"
 	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"github.com/offen/docker-volume-backup/internal/storage"
 )
 
 //go:embed notifications.tmpl
@@ -22,18 +24,41 @@ var defaultNotifications string
 
 // NotificationData data to be passed to the notification templates
 type NotificationData struct {
-	Error  error
-	Config *Config
-	Stats  *Stats
+	Error    error
+	Config   *Config
+	Stats    *Stats
+	Backends []string
+}
+
+// notificationEvent is an event during a run that users can opt in to
+// being notified about, in addition to the outcome of the run.
+type notificationEvent string
+
+const (
+	notificationEventStarted        notificationEvent = "started"
+	notificationEventRestartFailure notificationEvent = "restart_failure"
+	notificationEventBackendFailure notificationEvent = "backend_failure"
+	notificationEventPruneRefused   notificationEvent = "prune_refused"
+	notificationEventLockWait       notificationEvent = "lock_wait"
+)
+
+var notificationEvents = []notificationEvent{
+	notificationEventStarted,
+	notificationEventRestartFailure,
+	notificationEventBackendFailure,
+	notificationEventPruneRefused,
+	notificationEventLockWait,
 }
 
 // notify sends a notification using the given title and body templates.
-// Automatically creates notification data, adding the given error
-func (s *script) notify(titleTemplate string, bodyTemplate string, err error) error {
+// Automatically creates notification data, adding the given error and
+// the names of the backends the notification relates to.
+func (s *script) notify(titleTemplate string, bodyTemplate string, err error, backends ...string) error {
 	params := NotificationData{
-		Error:  err,
-		Stats:  s.stats,
-		Config: s.c,
+		Error:    err,
+		Stats:    s.stats,
+		Config:   s.c,
+		Backends: backends,
 	}
 
 	titleBuf := &bytes.Buffer{}
@@ -62,6 +87,51 @@ func (s *script) notifySuccess() error {
 	return s.notify("title_success", "body_success", nil)
 }
 
+// notifyDegraded sends a notification about a backup run that succeeded
+// although some storage backends failed
+func (s *script) notifyDegraded() error {
+	var backends []string
+	for _, backend := range s.storages {
+		if stats := s.stats.Storages[backend.Name()]; stats.UploadError != "" || stats.PruneError != "" {
+			backends = append(backends, backend.Name())
+		}
+	}
+	return s.notify("title_degraded", "body_degraded", nil, backends...)
+}
+
+// notifyEvent sends a notification about the given event in case the user
+// opted in to receiving it. Failing to notify is logged only, as it must not
+// affect the outcome of the run.
+func (s *script) notifyEvent(event notificationEvent, err error, backends ...string) {
+	if s.sender == nil || !slices.Contains(s.c.NotificationEvents, string(event)) {
+		return
+	}
+	if nErr := s.notify(
+		fmt.Sprintf("title_%s", event), fmt.Sprintf("body_%s", event), err, backends...,
+	); nErr != nil {
+		s.logger.Warn(fmt.Sprintf("Unable to send notification about event %s: %v", event, errwrap.Unwrap(nErr)))
+	}
+}
+
+// reportHostKeyMismatch checks whether the given error was caused by a
+// remote host presenting an unexpected key. As this might be caused by an
+// attack, a notification is sent independently of NOTIFICATION_LEVEL and
+// NOTIFICATION_EVENTS. It returns whether the error was a host key mismatch.
+func (s *script) reportHostKeyMismatch(err error, backend string) bool {
+	var mismatch *storage.HostKeyMismatchError
+	if !errors.As(err, &mismatch) {
+		return false
+	}
+	s.logger.Error(fmt.Sprintf("Verifying the host key failed for backend %s: %v", backend, mismatch))
+	if s.sender == nil {
+		return true
+	}
+	if nErr := s.notify("title_host_key_mismatch", "body_host_key_mismatch", mismatch, backend); nErr != nil {
+		s.logger.Warn(fmt.Sprintf("Unable to send notification about host key mismatch: %v", errwrap.Unwrap(nErr)))
+	}
+	return true
+}
+
 // sendNotification sends a notification to all configured third party services
 func (s *script) sendNotification(title, body string) error {
 	var errs []error
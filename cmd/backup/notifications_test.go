@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestDefaultNotificationTemplates(t *testing.T) {
+	tmpl, err := template.New("").Funcs(templateHelpers).Parse(defaultNotifications)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing templates: %v", err)
+	}
+
+	data := NotificationData{
+		Error:    errors.New("something went wrong"),
+		Config:   &Config{},
+		Stats:    &Stats{StartTime: time.Now(), LogOutput: &bytes.Buffer{}},
+		Backends: []string{"S3", "SSH"},
+	}
+	names := []string{"success", "failure", "degraded"}
+	for _, event := range notificationEvents {
+		names = append(names, string(event))
+	}
+	for _, name := range names {
+		for _, prefix := range []string{"title_", "body_"} {
+			var buf bytes.Buffer
+			if err := tmpl.ExecuteTemplate(&buf, prefix+name, data); err != nil {
+				t.Errorf("Unexpected error executing %s%s: %v", prefix, name, err)
+			}
+			if buf.Len() == 0 {
+				t.Errorf("Expected %s%s to render non-empty output", prefix, name)
+			}
+		}
+	}
+}
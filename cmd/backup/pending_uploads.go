@@ -0,0 +1,154 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+)
+
+// pendingUploadsDirectory is where files of uploads that have failed are
+// kept until the next run resumes uploading them. It is located next to
+// the backup file, so keeping files does not require copying them.
+var pendingUploadsDirectory = "/tmp/pending-uploads"
+
+// pendingUpload describes files of a previous run that have not been
+// uploaded to all backends yet.
+type pendingUpload struct {
+	Config     string    `json:"config"`
+	File       string    `json:"file"`
+	Companions []string  `json:"companions,omitempty"`
+	Backends   []string  `json:"backends"`
+	Created    time.Time `json:"created"`
+}
+
+// files returns all files that belong to the upload.
+func (p pendingUpload) files() []string {
+	return append(slices.Clone(p.Companions), p.File)
+}
+
+func readPendingUploads() ([]pendingUpload, error) {
+	b, err := os.ReadFile(filepath.Join(pendingUploadsDirectory, "pending.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errwrap.Wrap(err, "error reading pending uploads")
+	}
+	// Corrupted state is not fatal, it only prevents uploads from being
+	// resumed.
+	var uploads []pendingUpload
+	if err := json.Unmarshal(b, &uploads); err != nil {
+		return nil, nil
+	}
+	return uploads, nil
+}
+
+func writePendingUploads(uploads []pendingUpload) error {
+	location := filepath.Join(pendingUploadsDirectory, "pending.json")
+	if len(uploads) == 0 {
+		return remove(location)
+	}
+	b, err := json.Marshal(uploads)
+	if err != nil {
+		return errwrap.Wrap(err, "error marshaling pending uploads")
+	}
+	if err := os.MkdirAll(pendingUploadsDirectory, 0700); err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error creating %s", pendingUploadsDirectory))
+	}
+	if err := os.WriteFile(location, b, 0600); err != nil {
+		return errwrap.Wrap(err, "error writing pending uploads")
+	}
+	return nil
+}
+
+// loadPendingUploads returns the uploads of previous runs of the current
+// configuration that are to be resumed. Uploads that are stale, have lost
+// their files or are superseded by the current backup file are discarded.
+func (s *script) loadPendingUploads() ([]pendingUpload, error) {
+	if s.c.BackupUploadSessionsDirectory == "" {
+		return nil, nil
+	}
+	uploads, err := readPendingUploads()
+	if err != nil {
+		return nil, err
+	}
+	var pending []pendingUpload
+	for _, upload := range uploads {
+		if upload.Config != s.c.name {
+			continue
+		}
+		_, statErr := os.Stat(upload.File)
+		stale := s.c.BackupUploadSessionsMaxAge > 0 && time.Since(upload.Created) > s.c.BackupUploadSessionsMaxAge
+		if statErr != nil || stale || path.Base(upload.File) == path.Base(s.file) {
+			s.logger.Warn(fmt.Sprintf("Discarding pending upload of `%s` of a previous run.", path.Base(upload.File)))
+			s.removePendingFiles(upload)
+			continue
+		}
+		pending = append(pending, upload)
+	}
+	return pending, nil
+}
+
+// storePendingUploads persists the given uploads of the current
+// configuration, replacing the ones that have been loaded before. Files of
+// uploads that do not have any backends left are removed.
+func (s *script) storePendingUploads(pending []pendingUpload) error {
+	uploads, err := readPendingUploads()
+	if err != nil {
+		return err
+	}
+	uploads = slices.DeleteFunc(uploads, func(upload pendingUpload) bool {
+		return upload.Config == s.c.name
+	})
+	for _, upload := range pending {
+		if len(upload.Backends) == 0 {
+			s.removePendingFiles(upload)
+			continue
+		}
+		uploads = append(uploads, upload)
+	}
+	return writePendingUploads(uploads)
+}
+
+// keepPendingUpload moves the current backup file and its companion files
+// aside, so that the next run can resume uploading them to the given
+// backends.
+func (s *script) keepPendingUpload(backends []string) (pendingUpload, error) {
+	upload := pendingUpload{
+		Config:   s.c.name,
+		Backends: backends,
+		Created:  time.Now(),
+	}
+	if err := os.MkdirAll(pendingUploadsDirectory, 0700); err != nil {
+		return upload, errwrap.Wrap(err, fmt.Sprintf("error creating %s", pendingUploadsDirectory))
+	}
+	for _, file := range append(slices.Clone(s.companionFiles), s.file) {
+		location := filepath.Join(pendingUploadsDirectory, path.Base(file))
+		if err := os.Rename(file, location); err != nil {
+			return upload, errwrap.Wrap(err, fmt.Sprintf("error keeping %s", path.Base(file)))
+		}
+		if file == s.file {
+			upload.File = location
+		} else {
+			upload.Companions = append(upload.Companions, location)
+		}
+	}
+	return upload, nil
+}
+
+func (s *script) removePendingFiles(upload pendingUpload) {
+	for _, file := range upload.files() {
+		if err := remove(file); err != nil {
+			s.logger.Warn(fmt.Sprintf("Unable to remove pending upload: %v", errwrap.Unwrap(err)))
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/offen/docker-volume-backup/internal/storage"
+)
+
+// resumableBackend fails the given number of uploads after saving an upload
+// session, and records uploads that resumed a saved session.
+type resumableBackend struct {
+	sessions *storage.UploadSessions
+	failures int
+	resumed  []string
+	uploaded []string
+}
+
+func (b *resumableBackend) ResumeUploads(sessions *storage.UploadSessions) {
+	b.sessions = sessions
+}
+
+func (b *resumableBackend) Copy(file string) error {
+	var offset int64
+	ok, err := b.sessions.Load(b.Name(), file, &offset)
+	if err != nil {
+		return err
+	}
+	if ok {
+		b.resumed = append(b.resumed, path.Base(file))
+	}
+	if b.failures > 0 {
+		b.failures--
+		if err := b.sessions.Save(b.Name(), file, int64(1024)); err != nil {
+			return err
+		}
+		return errors.New("connection reset")
+	}
+	b.uploaded = append(b.uploaded, path.Base(file))
+	return b.sessions.Delete(b.Name(), file)
+}
+
+func (b *resumableBackend) Prune(time.Time, string) (*storage.PruneStats, error) {
+	return &storage.PruneStats{}, nil
+}
+
+func (b *resumableBackend) Name() string {
+	return "Resumable"
+}
+
+func TestResumePendingUploads(t *testing.T) {
+	pendingUploadsDirectory = filepath.Join(t.TempDir(), "pending-uploads")
+	defer func() { pendingUploadsDirectory = "/tmp/pending-uploads" }()
+	sessionsDirectory := t.TempDir()
+	dir := t.TempDir()
+
+	run := func(file string, b *resumableBackend) error {
+		if err := os.WriteFile(file, []byte(file), 0644); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		c := mustLoadConfig(t, map[string]string{
+			"BACKUP_UPLOAD_SESSIONS_DIRECTORY": sessionsDirectory,
+		})
+		s := newScript(c)
+		s.file = file
+		b.ResumeUploads(&storage.UploadSessions{Directory: sessionsDirectory, MaxAge: time.Hour})
+		s.storages = []storage.Backend{b}
+		return s.copyArchive()
+	}
+
+	first := &resumableBackend{failures: 1}
+	if err := run(filepath.Join(dir, "backup-1.tar.gz"), first); err == nil {
+		t.Fatal("Expected the first run to fail")
+	}
+	kept := filepath.Join(pendingUploadsDirectory, "backup-1.tar.gz")
+	if _, err := os.Stat(kept); err != nil {
+		t.Fatalf("Expected backup file to be kept, got %v", err)
+	}
+
+	// The second run uses a new backend instance, so the upload can only
+	// be resumed using the session saved by the first run.
+	second := &resumableBackend{}
+	if err := run(filepath.Join(dir, "backup-2.tar.gz"), second); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if !slices.Equal(second.resumed, []string{"backup-1.tar.gz"}) {
+		t.Errorf("Expected upload of first backup to be resumed, got %v", second.resumed)
+	}
+	if !slices.Equal(second.uploaded, []string{"backup-1.tar.gz", "backup-2.tar.gz"}) {
+		t.Errorf("Expected both backups to be uploaded, got %v", second.uploaded)
+	}
+	if _, err := os.Stat(kept); !os.IsNotExist(err) {
+		t.Errorf("Expected kept backup file to be removed, got %v", err)
+	}
+	if pending, err := readPendingUploads(); err != nil || len(pending) != 0 {
+		t.Errorf("Expected no pending uploads, got %v %v", pending, err)
+	}
+}
@@ -7,10 +7,11 @@ import (
 	"fmt"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/offen/docker-volume-backup/internal/errwrap"
-	"golang.org/x/sync/errgroup"
+	"github.com/offen/docker-volume-backup/internal/storage"
 )
 
 // pruneBackups rotates away backups from local and remote storages using
@@ -23,34 +24,63 @@ func (s *script) pruneBackups() error {
 
 	deadline := time.Now().AddDate(0, 0, -int(s.c.BackupRetentionDays)).Add(s.c.BackupPruningLeeway)
 
-	eg := errgroup.Group{}
+	var wg sync.WaitGroup
+	failed := map[string]error{}
 	for _, backend := range s.storages {
 		b := backend
-		eg.Go(func() error {
+		wg.Go(func() {
 			if skipPrune(b.Name(), s.c.BackupSkipBackendsFromPrune) {
 				s.logger.Info(
 					fmt.Sprintf("Skipping pruning for backend `%s`.", b.Name()),
 				)
-				return nil
+				return
 			}
-			stats, err := b.Prune(deadline, s.c.BackupPruningPrefix)
-			if err != nil {
-				return err
+			s.stats.Lock()
+			uploadError := s.stats.Storages[b.Name()].UploadError
+			s.stats.Unlock()
+			if uploadError != "" {
+				s.logger.Info(
+					fmt.Sprintf("Skipping pruning for backend `%s` as uploading the backup failed.", b.Name()),
+				)
+				return
 			}
+			var stats *storage.PruneStats
+			attempts, err := s.withRetry(b, "pruning", func() (err error) {
+				stats, err = b.Prune(deadline, s.c.BackupPruningPrefix)
+				return
+			})
 			s.stats.Lock()
-			s.stats.Storages[b.Name()] = StorageStats{
-				Total:  stats.Total,
-				Pruned: stats.Pruned,
+			defer s.stats.Unlock()
+			storageStats := s.stats.Storages[b.Name()]
+			storageStats.PruneAttempts = attempts
+			if err != nil {
+				storageStats.PruneError = err.Error()
+				failed[b.Name()] = err
+			} else {
+				storageStats.Total = stats.Total
+				storageStats.Pruned = stats.Pruned
+				storageStats.Locked = stats.Locked
+				storageStats.PruneRefused = stats.Refused
 			}
-			s.stats.Unlock()
-			return nil
+			s.stats.Storages[b.Name()] = storageStats
 		})
 	}
+	wg.Wait()
 
-	if err := eg.Wait(); err != nil {
+	if err := s.applySuccessPolicy(failed); err != nil {
 		return errwrap.Wrap(err, "error pruning backups")
 	}
 
+	var refused []string
+	for _, backend := range s.storages {
+		if s.stats.Storages[backend.Name()].PruneRefused {
+			refused = append(refused, backend.Name())
+		}
+	}
+	if len(refused) != 0 {
+		s.notifyEvent(notificationEventPruneRefused, nil, refused...)
+	}
+
 	return nil
 }
 
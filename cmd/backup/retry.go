@@ -0,0 +1,68 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"github.com/offen/docker-volume-backup/internal/storage"
+)
+
+// withRetry runs the given operation against the given backend, retrying
+// it using exponential backoff with jitter as long as the configured number
+// of attempts is not exhausted and the backend considers the error to be
+// transient. Backends that cannot classify errors are always retried, as
+// are uploads that did not match the local file when verified.
+// It returns the number of attempts that were made.
+func (s *script) withRetry(b storage.Backend, operation string, fn func() error) (uint, error) {
+	var attempt uint
+	for {
+		attempt++
+		err := fn()
+		if err == nil {
+			return attempt, nil
+		}
+		if s.reportHostKeyMismatch(err, b.Name()) {
+			return attempt, err
+		}
+		if attempt >= uint(s.c.BackupRetryAttempts.Int()) {
+			return attempt, err
+		}
+		var mismatch *storage.ChecksumMismatchError
+		if r, ok := b.(storage.RetryableBackend); ok && !r.Retryable(err) && !errors.As(err, &mismatch) {
+			return attempt, err
+		}
+
+		wait := retryBackoff(attempt, s.c.BackupRetryBackoff, s.c.BackupRetryMaxBackoff)
+		s.logger.Warn(
+			fmt.Sprintf(
+				"Attempt %d of %d for %s on backend %s failed, retrying in %s: %v",
+				attempt, s.c.BackupRetryAttempts.Int(), operation, b.Name(), wait.Round(time.Millisecond), errwrap.Unwrap(err),
+			),
+		)
+		time.Sleep(wait)
+	}
+}
+
+// retryBackoff returns the time to wait before the next attempt after the
+// given number of failed attempts. The backoff doubles with each attempt up
+// to the given maximum, and a random jitter of up to half its value is
+// subtracted so that concurrent retries do not happen in lockstep.
+func retryBackoff(attempt uint, initial, max time.Duration) time.Duration {
+	backoff := initial
+	for i := uint(1); i < attempt && backoff < max; i++ {
+		backoff *= 2
+	}
+	if max > 0 && backoff > max {
+		backoff = max
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff - rand.N(backoff/2+1)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt uint
+		initial time.Duration
+		max     time.Duration
+		min     time.Duration
+		upper   time.Duration
+	}{
+		{"first attempt", 1, time.Second, time.Minute, 500 * time.Millisecond, time.Second},
+		{"third attempt", 3, time.Second, time.Minute, 2 * time.Second, 4 * time.Second},
+		{"capped", 10, time.Second, 5 * time.Second, 2500 * time.Millisecond, 5 * time.Second},
+		{"zero", 2, 0, time.Minute, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				result := retryBackoff(test.attempt, test.initial, test.max)
+				if result < test.min || result > test.upper {
+					t.Fatalf("Expected backoff between %s and %s, got %s", test.min, test.upper, result)
+				}
+			}
+		})
+	}
+}
@@ -14,8 +14,25 @@ import (
 // runScript instantiates a new script object and orchestrates a backup run.
 // To ensure it runs mutually exclusive a global file lock is acquired before
 // it starts running. Any panic within the script will be recovered and returned
-// as an error.
-func runScript(c *Config) (err error) {
+// as an error. The outcome of each run is recorded in the history journal
+// and returned as stats. In dry run mode, all phases only log what they
+// would do.
+func runScript(c *Config) (stats *Stats, err error) {
+	s := newScript(c)
+	stats = s.stats
+	defer func() {
+		// Dry runs leave no trace other than their log output.
+		if s.c.dryRun {
+			return
+		}
+		if herr := s.recordHistory(err); herr != nil {
+			s.logger.Warn(fmt.Sprintf("Unable to record run in history: %v", errwrap.Unwrap(herr)))
+		}
+		if merr := s.writeMetricsTextfile(err); merr != nil {
+			s.logger.Warn(fmt.Sprintf("Unable to write metrics: %v", errwrap.Unwrap(merr)))
+		}
+	}()
+
 	defer func() {
 		if derr := recover(); derr != nil {
 			fmt.Printf("%s: %s\n", derr, debug.Stack())
@@ -28,8 +45,6 @@ func runScript(c *Config) (err error) {
 		}
 	}()
 
-	s := newScript(c)
-
 	unlock, lockErr := s.lock("/var/lock/dockervolumebackup.lock")
 	if lockErr != nil {
 		err = errwrap.Wrap(lockErr, "error acquiring file lock")
@@ -43,7 +58,8 @@ func runScript(c *Config) (err error) {
 
 	unset, err := s.c.applyEnv()
 	if err != nil {
-		return errwrap.Wrap(err, "error applying env")
+		err = errwrap.Wrap(err, "error applying env")
+		return
 	}
 	defer func() {
 		if derr := unset(); derr != nil {
@@ -56,35 +72,50 @@ func runScript(c *Config) (err error) {
 		return
 	}
 
-	return func() (err error) {
+	s.notifyEvent(notificationEventStarted, nil)
+	if s.stats.LockedTime > s.c.NotificationLockWaitThreshold {
+		s.notifyEvent(notificationEventLockWait, nil)
+	}
+
+	err = func() (err error) {
 		scriptErr := func() error {
-			if err := s.withLabeledCommands(lifecyclePhaseArchive, func() (err error) {
-				restartContainersAndServices, err := s.stopContainersAndServices()
-				// The mechanism for restarting containers is not using hooks as it
-				// should happen as soon as possible (i.e. before uploading backups or
-				// similar).
-				defer func() {
-					if derr := restartContainersAndServices(); derr != nil {
-						err = errors.Join(err, errwrap.Wrap(derr, "error restarting containers and services"))
+			if s.c.BackupRunMode.backsUp() {
+				if err := s.withLabeledCommands(lifecyclePhaseArchive, func() (err error) {
+					restartContainersAndServices, err := s.stopContainersAndServices()
+					// The mechanism for restarting containers is not using hooks as it
+					// should happen as soon as possible (i.e. before uploading backups or
+					// similar).
+					defer func() {
+						if derr := restartContainersAndServices(); derr != nil {
+							s.notifyEvent(notificationEventRestartFailure, derr)
+							err = errors.Join(err, errwrap.Wrap(derr, "error restarting containers and services"))
+						}
+					}()
+					if err != nil {
+						return
 					}
-				}()
-				if err != nil {
+					err = s.createArchive()
 					return
+				})(); err != nil {
+					return err
 				}
-				err = s.createArchive()
-				return
-			})(); err != nil {
-				return err
-			}
 
-			if err := s.withLabeledCommands(lifecyclePhaseProcess, s.encryptArchive)(); err != nil {
-				return err
-			}
-			if err := s.withLabeledCommands(lifecyclePhaseCopy, s.copyArchive)(); err != nil {
-				return err
+				if err := s.withLabeledCommands(lifecyclePhaseProcess, func() error {
+					if err := s.encryptArchive(); err != nil {
+						return err
+					}
+					return s.signArchive()
+				})(); err != nil {
+					return err
+				}
+				if err := s.withLabeledCommands(lifecyclePhaseCopy, s.copyArchive)(); err != nil {
+					return err
+				}
 			}
-			if err := s.withLabeledCommands(lifecyclePhasePrune, s.pruneBackups)(); err != nil {
-				return err
+			if s.c.BackupRunMode.prunes() {
+				if err := s.withLabeledCommands(lifecyclePhasePrune, s.pruneBackups)(); err != nil {
+					return err
+				}
 			}
 			return nil
 		}()
@@ -110,4 +141,5 @@ func runScript(c *Config) (err error) {
 		}
 		return nil
 	}()
+	return
 }
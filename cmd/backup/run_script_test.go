@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunScriptDryRun(t *testing.T) {
+	sources, archive := setupRunScriptTest(t)
+	c := mustLoadConfig(t, map[string]string{
+		"BACKUP_SOURCES":        sources,
+		"BACKUP_ARCHIVE":        archive,
+		"BACKUP_FILENAME":       "backup-dry-run.tar.gz",
+		"BACKUP_RETENTION_DAYS": "1",
+		"BACKUP_PRUNING_PREFIX": "backup-",
+		"BACKUP_HISTORY_FILE":   filepath.Join(archive, "history.jsonl"),
+		"GPG_PASSPHRASE":        "test",
+	})
+	c.dryRun = true
+
+	stats, err := runScript(c)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if local := stats.Storages["Local"]; local.Total != 3 || local.Pruned != 2 {
+		t.Errorf("Expected 2 of 3 backups to be reported as pruned, got %#v", local)
+	}
+
+	entries, err := os.ReadDir(archive)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("Expected archive to be left untouched, got %v", entries)
+	}
+	for _, location := range []string{"/tmp/backup-dry-run.tar.gz", "/tmp/backup-dry-run.tar.gz.gpg"} {
+		if _, err := os.Stat(location); !os.IsNotExist(err) {
+			t.Errorf("Expected %s not to be created, got %v", location, err)
+		}
+	}
+}
+
+func TestRunScriptRunMode(t *testing.T) {
+	tests := []struct {
+		mode          string
+		expectedFiles []string
+	}{
+		{"all", []string{"backup-new.tar.gz", "backup-run-mode.tar.gz"}},
+		{"backup", []string{"backup-new.tar.gz", "backup-old.tar.gz", "backup-older.tar.gz", "backup-run-mode.tar.gz"}},
+		{"prune", []string{"backup-new.tar.gz"}},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			sources, archive := setupRunScriptTest(t)
+			c := mustLoadConfig(t, map[string]string{
+				"BACKUP_RUN_MODE":       test.mode,
+				"BACKUP_SOURCES":        sources,
+				"BACKUP_ARCHIVE":        archive,
+				"BACKUP_FILENAME":       "backup-run-mode.tar.gz",
+				"BACKUP_RETENTION_DAYS": "1",
+				"BACKUP_PRUNING_PREFIX": "backup-",
+				"BACKUP_HISTORY_FILE":   "",
+			})
+			if _, err := runScript(c); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			entries, err := os.ReadDir(archive)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			var files []string
+			for _, entry := range entries {
+				files = append(files, entry.Name())
+			}
+			if len(files) != len(test.expectedFiles) {
+				t.Fatalf("Expected files %v, got %v", test.expectedFiles, files)
+			}
+			for i := range files {
+				if files[i] != test.expectedFiles[i] {
+					t.Errorf("Expected files %v, got %v", test.expectedFiles, files)
+				}
+			}
+		})
+	}
+}
+
+// setupRunScriptTest creates a source directory and an archive holding two
+// backups that are a week old and a recent one.
+func setupRunScriptTest(t *testing.T) (string, string) {
+	t.Helper()
+	sources, archive := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(sources, "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	old := time.Now().AddDate(0, 0, -7)
+	for _, name := range []string{"backup-old.tar.gz", "backup-older.tar.gz"} {
+		location := filepath.Join(archive, name)
+		if err := os.WriteFile(location, []byte("backup"), 0644); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if err := os.Chtimes(location, old, old); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(archive, "backup-new.tar.gz"), []byte("backup"), 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return sources, archive
+}
@@ -0,0 +1,109 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"time"
+)
+
+type runTrigger string
+
+const (
+	runTriggerSchedule runTrigger = "schedule"
+	runTriggerAPI      runTrigger = "api"
+)
+
+// runResult describes the outcome of a finished backup run.
+type runResult struct {
+	Trigger   runTrigger `json:"trigger"`
+	StartTime time.Time  `json:"startTime"`
+	EndTime   time.Time  `json:"endTime"`
+	Error     string     `json:"error,omitempty"`
+	Degraded  bool       `json:"degraded,omitempty"`
+}
+
+// runStatus keeps track of the current and the most recent run of a
+// single configuration.
+type runStatus struct {
+	running      bool
+	runningSince time.Time
+	lastRun      *runResult
+	stats        *Stats
+	lastSuccess  time.Time
+	outcomes     map[runOutcome]uint
+	uploadErrors map[string]uint
+}
+
+// startRun marks a run of the given configuration as being in progress and
+// returns the time it has started. Runs of a configuration never overlap, so
+// in case another run is in progress already, false is returned.
+func (c *command) startRun(config *Config) (time.Time, bool) {
+	start := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status, ok := c.runs[config.name]
+	if !ok {
+		status = &runStatus{
+			outcomes:     map[runOutcome]uint{},
+			uploadErrors: map[string]uint{},
+		}
+		c.runs[config.name] = status
+	}
+	if status.running {
+		return time.Time{}, false
+	}
+	status.running = true
+	status.runningSince = start
+	return start, true
+}
+
+// finishRun executes a run of the given configuration that has been started
+// using startRun, keeping track of its status so it can be queried while the
+// process is running.
+func (c *command) finishRun(config *Config, trigger runTrigger, start time.Time) (*runResult, error) {
+	stats, err := runScript(config)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := c.runs[config.name]
+	status.running = false
+	result := &runResult{
+		Trigger:   trigger,
+		StartTime: start,
+		EndTime:   time.Now(),
+	}
+	outcome := outcomeOf(stats, err)
+	status.outcomes[outcome]++
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Degraded = outcome == runOutcomeDegraded
+		status.lastSuccess = result.EndTime
+	}
+	status.lastRun = result
+	status.stats = stats
+	for name, storageStats := range stats.Storages {
+		if storageStats.UploadError != "" {
+			status.uploadErrors[name]++
+		}
+	}
+	return result, err
+}
+
+// lookupConfiguration returns the currently scheduled configuration using
+// the given name. In case only a single configuration exists, the name
+// can be omitted.
+func (c *command) lookupConfiguration(name string) (*Config, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name == "" && len(c.configurations) == 1 {
+		return c.configurations[0], true
+	}
+	for _, config := range c.configurations {
+		if config.name == name || config.source == name {
+			return config, true
+		}
+	}
+	return nil, false
+}
@@ -9,18 +9,13 @@ import (
 	"log/slog"
 	"os"
 	"path"
+	"slices"
 	"text/template"
 	"time"
 
+	"filippo.io/age"
 	"github.com/offen/docker-volume-backup/internal/errwrap"
 	"github.com/offen/docker-volume-backup/internal/storage"
-	"github.com/offen/docker-volume-backup/internal/storage/azure"
-	"github.com/offen/docker-volume-backup/internal/storage/dropbox"
-	"github.com/offen/docker-volume-backup/internal/storage/googledrive"
-	"github.com/offen/docker-volume-backup/internal/storage/local"
-	"github.com/offen/docker-volume-backup/internal/storage/s3"
-	"github.com/offen/docker-volume-backup/internal/storage/ssh"
-	"github.com/offen/docker-volume-backup/internal/storage/webdav"
 
 	"github.com/containrrr/shoutrrr"
 	"github.com/containrrr/shoutrrr/pkg/router"
@@ -39,8 +34,17 @@ type script struct {
 	hooks     []hook
 	hookLevel hookLevel
 
-	file  string
-	stats *Stats
+	file         string
+	stats        *Stats
+	destinations map[string]string
+	// digests are the hex encoded SHA256 digests of the uploaded files in
+	// case upload templates are configured.
+	digests map[string]string
+	// companionFiles are uploaded alongside the backup file, e.g. its
+	// signature.
+	companionFiles      []string
+	encryptionKeys      []encryptionKey
+	ageRecoveryIdentity *age.X25519Identity
 
 	encounteredLock bool
 
@@ -54,8 +58,10 @@ type script struct {
 func newScript(c *Config) *script {
 	stdOut, logBuffer := buffer(os.Stdout)
 	return &script{
-		c:      c,
-		logger: slog.New(slog.NewTextHandler(stdOut, nil)),
+		c:            c,
+		logger:       slog.New(slog.NewTextHandler(stdOut, nil)),
+		destinations: map[string]string{},
+		digests:      map[string]string{},
 		stats: &Stats{
 			StartTime: time.Now(),
 			LogOutput: logBuffer,
@@ -68,6 +74,7 @@ func newScript(c *Config) *script {
 				"Dropbox":     {},
 				"GoogleDrive": {},
 			},
+			Phases: map[string]PhaseStats{},
 		},
 	}
 }
@@ -79,32 +86,15 @@ func (s *script) init() error {
 		return nil
 	})
 
-	s.file = path.Join("/tmp", s.c.BackupFilename)
-
-	tmplFileName, tErr := template.New("extension").Parse(s.file)
-	if tErr != nil {
-		return errwrap.Wrap(tErr, "unable to parse backup file extension template")
+	if err := s.resolveFile(); err != nil {
+		return err
 	}
-
-	var bf bytes.Buffer
-	if tErr := tmplFileName.Execute(&bf, map[string]string{
-		"Extension": func() string {
-			if s.c.BackupCompression == "none" {
-				return "tar"
-			}
-			return fmt.Sprintf("tar.%s", s.c.BackupCompression)
-		}(),
-	}); tErr != nil {
-		return errwrap.Wrap(tErr, "error executing backup file extension template")
+	if err := s.c.checkRunMode(); err != nil {
+		return err
 	}
-	s.file = bf.String()
-
-	if s.c.BackupFilenameExpand {
-		s.file = os.ExpandEnv(s.file)
-		s.c.BackupLatestSymlink = os.ExpandEnv(s.c.BackupLatestSymlink)
-		s.c.BackupPruningPrefix = os.ExpandEnv(s.c.BackupPruningPrefix)
+	if err := s.c.checkRetention(); err != nil {
+		return err
 	}
-	s.file = timeutil.Strftime(&s.stats.StartTime, s.file)
 
 	_, err := os.Stat("/var/run/docker.sock")
 	_, dockerHostSet := os.LookupEnv("DOCKER_HOST")
@@ -131,117 +121,93 @@ func (s *script) init() error {
 		}
 	}
 
-	if s.c.AwsS3BucketName != "" {
-		s3Config := s3.Config{
-			Endpoint:         s.c.AwsEndpoint,
-			AccessKeyID:      s.c.AwsAccessKeyID,
-			SecretAccessKey:  s.c.AwsSecretAccessKey,
-			IamRoleEndpoint:  s.c.AwsIamRoleEndpoint,
-			EndpointProto:    s.c.AwsEndpointProto,
-			EndpointInsecure: s.c.AwsEndpointInsecure,
-			RemotePath:       s.c.AwsS3Path,
-			BucketName:       s.c.AwsS3BucketName,
-			StorageClass:     s.c.AwsStorageClass,
-			CACert:           s.c.AwsEndpointCACert.Cert,
-			PartSize:         s.c.AwsPartSize,
-		}
-		s3Backend, err := s3.NewStorageBackend(s3Config, logFunc)
-		if err != nil {
-			return errwrap.Wrap(err, "error creating s3 storage backend")
-		}
-		s.storages = append(s.storages, s3Backend)
+	// Notifications are set up before connecting to storage backends, so
+	// that failing to verify the identity of a remote host is reported.
+	if err := s.initNotifications(); err != nil {
+		return err
 	}
 
-	if s.c.WebdavUrl != "" {
-		webDavConfig := webdav.Config{
-			URL:         s.c.WebdavUrl,
-			URLInsecure: s.c.WebdavUrlInsecure,
-			Username:    s.c.WebdavUsername,
-			Password:    s.c.WebdavPassword,
-			RemotePath:  s.c.WebdavPath,
-		}
-		webdavBackend, err := webdav.NewStorageBackend(webDavConfig, logFunc)
+	for _, cfg := range s.c.configuredStorages() {
+		backend, err := cfg.newStorageBackend(logFunc, s.renderUploadTemplate)
 		if err != nil {
-			return errwrap.Wrap(err, "error creating webdav storage backend")
+			name := cfg.Name
+			if name == "" {
+				name = string(cfg.Type)
+			}
+			s.reportHostKeyMismatch(err, name)
+			return err
 		}
-		s.storages = append(s.storages, webdavBackend)
-	}
-
-	if s.c.SSHHostName != "" {
-		sshConfig := ssh.Config{
-			HostName:           s.c.SSHHostName,
-			Port:               s.c.SSHPort,
-			User:               s.c.SSHUser,
-			Password:           s.c.SSHPassword,
-			IdentityFile:       s.c.SSHIdentityFile,
-			IdentityPassphrase: s.c.SSHIdentityPassphrase,
-			RemotePath:         s.c.SSHRemotePath,
+		if cfg.Name != "" {
+			s.stats.Storages[cfg.Name] = StorageStats{}
 		}
-		sshBackend, err := ssh.NewStorageBackend(sshConfig, logFunc)
-		if err != nil {
-			return errwrap.Wrap(err, "error creating ssh storage backend")
+		if retainable, ok := backend.(storage.RetainableBackend); ok && s.c.BackupRetentionDays > 0 {
+			// Backups are retained exactly as long as they are kept before
+			// being pruned.
+			retainable.RetainFor(time.Duration(s.c.BackupRetentionDays) * 24 * time.Hour)
 		}
-		s.storages = append(s.storages, sshBackend)
-	}
-
-	if _, err := os.Stat(s.c.BackupArchive); !os.IsNotExist(err) {
-		localConfig := local.Config{
-			ArchivePath:   s.c.BackupArchive,
-			LatestSymlink: s.c.BackupLatestSymlink,
+		if dryRunnable, ok := backend.(storage.DryRunnableBackend); ok && s.c.dryRun {
+			dryRunnable.EnableDryRun()
 		}
-		localBackend := local.NewStorageBackend(localConfig, logFunc)
-		s.storages = append(s.storages, localBackend)
+		s.destinations[backend.Name()] = cfg.destination(cfg.Type)
+		s.storages = append(s.storages, backend)
 	}
 
-	if s.c.AzureStorageAccountName != "" {
-		azureConfig := azure.Config{
-			ContainerName:     s.c.AzureStorageContainerName,
-			AccountName:       s.c.AzureStorageAccountName,
-			PrimaryAccountKey: s.c.AzureStoragePrimaryAccountKey,
-			Endpoint:          s.c.AzureStorageEndpoint,
-			RemotePath:        s.c.AzureStoragePath,
-			ConnectionString:  s.c.AzureStorageConnectionString,
-			AccessTier:        s.c.AzureStorageAccessTier,
+	if s.c.BackupUploadSessionsDirectory != "" && !s.c.dryRun {
+		sessions := &storage.UploadSessions{
+			Directory: s.c.BackupUploadSessionsDirectory,
+			MaxAge:    s.c.BackupUploadSessionsMaxAge,
 		}
-		azureBackend, err := azure.NewStorageBackend(azureConfig, logFunc)
-		if err != nil {
-			return errwrap.Wrap(err, "error creating azure storage backend")
+		if err := sessions.Sweep(); err != nil {
+			s.logger.Warn(fmt.Sprintf("Unable to remove stale upload sessions: %v", errwrap.Unwrap(err)))
+		}
+		for _, backend := range s.storages {
+			if resumable, ok := backend.(storage.ResumableBackend); ok {
+				resumable.ResumeUploads(sessions)
+			}
 		}
-		s.storages = append(s.storages, azureBackend)
 	}
 
-	if s.c.DropboxRefreshToken != "" && s.c.DropboxAppKey != "" && s.c.DropboxAppSecret != "" {
-		dropboxConfig := dropbox.Config{
-			Endpoint:         s.c.DropboxEndpoint,
-			OAuth2Endpoint:   s.c.DropboxOAuth2Endpoint,
-			RefreshToken:     s.c.DropboxRefreshToken,
-			AppKey:           s.c.DropboxAppKey,
-			AppSecret:        s.c.DropboxAppSecret,
-			RemotePath:       s.c.DropboxRemotePath,
-			ConcurrencyLevel: s.c.DropboxConcurrencyLevel.Int(),
-		}
-		dropboxBackend, err := dropbox.NewStorageBackend(dropboxConfig, logFunc)
-		if err != nil {
-			return errwrap.Wrap(err, "error creating dropbox storage backend")
-		}
-		s.storages = append(s.storages, dropboxBackend)
+	return nil
+}
+
+// resolveFile sets the location of the backup file as per the configured
+// file name.
+func (s *script) resolveFile() error {
+	s.file = path.Join("/tmp", s.c.BackupFilename)
+
+	tmplFileName, tErr := template.New("extension").Parse(s.file)
+	if tErr != nil {
+		return errwrap.Wrap(tErr, "unable to parse backup file extension template")
 	}
 
-	if s.c.GoogleDriveCredentialsJSON != "" {
-		googleDriveConfig := googledrive.Config{
-			CredentialsJSON:    s.c.GoogleDriveCredentialsJSON,
-			FolderID:           s.c.GoogleDriveFolderID,
-			ImpersonateSubject: s.c.GoogleDriveImpersonateSubject,
-			Endpoint:           s.c.GoogleDriveEndpoint,
-			TokenURL:           s.c.GoogleDriveTokenURL,
-		}
-		googleDriveBackend, err := googledrive.NewStorageBackend(googleDriveConfig, logFunc)
-		if err != nil {
-			return errwrap.Wrap(err, "error creating googledrive storage backend")
+	var bf bytes.Buffer
+	if tErr := tmplFileName.Execute(&bf, map[string]string{
+		"Extension": func() string {
+			if s.c.BackupCompression == "none" {
+				return "tar"
+			}
+			return fmt.Sprintf("tar.%s", s.c.BackupCompression)
+		}(),
+	}); tErr != nil {
+		return errwrap.Wrap(tErr, "error executing backup file extension template")
+	}
+	s.file = bf.String()
+
+	if s.c.BackupFilenameExpand {
+		s.file = os.ExpandEnv(s.file)
+		s.c.BackupLatestSymlink = os.ExpandEnv(s.c.BackupLatestSymlink)
+		for i := range s.c.Storages {
+			s.c.Storages[i].BackupLatestSymlink = os.ExpandEnv(s.c.Storages[i].BackupLatestSymlink)
 		}
-		s.storages = append(s.storages, googleDriveBackend)
+		s.c.BackupPruningPrefix = os.ExpandEnv(s.c.BackupPruningPrefix)
 	}
+	s.file = timeutil.Strftime(&s.stats.StartTime, s.file)
+	return nil
+}
 
+// initNotifications validates the notification settings and registers the
+// hooks that send notifications.
+func (s *script) initNotifications() error {
 	if s.c.EmailNotificationRecipient != "" {
 		emailURL := fmt.Sprintf(
 			"smtp://%s:%s@%s:%d/?from=%s&to=%s",
@@ -267,16 +233,21 @@ func (s *script) init() error {
 	}
 	s.hookLevel = hookLevel
 
+	for _, event := range s.c.NotificationEvents {
+		if !slices.Contains(notificationEvents, notificationEvent(event)) {
+			return errwrap.Wrap(nil, fmt.Sprintf("unknown event %s in NOTIFICATION_EVENTS", event))
+		}
+	}
+
 	if len(s.c.NotificationURLs) > 0 {
 		sender, senderErr := shoutrrr.CreateSender(s.c.NotificationURLs...)
 		if senderErr != nil {
 			return errwrap.Wrap(senderErr, "error creating sender")
 		}
-		s.sender = sender
 
 		tmpl := template.New("")
 		tmpl.Funcs(templateHelpers)
-		tmpl, err = tmpl.Parse(defaultNotifications)
+		tmpl, err := tmpl.Parse(defaultNotifications)
 		if err != nil {
 			return errwrap.Wrap(err, "unable to parse default notifications templates")
 		}
@@ -289,16 +260,25 @@ func (s *script) init() error {
 		}
 		s.template = tmpl
 
+		if s.c.dryRun {
+			s.logger.Info(fmt.Sprintf("Would send notifications to %d service(s).", len(s.c.NotificationURLs)))
+			return nil
+		}
+		s.sender = sender
+
 		// To prevent duplicate notifications, ensure the regsistered callbacks
 		// run mutually exclusive.
 		s.registerHook(hookLevelError, func(err error) error {
 			if err == nil {
+				if s.stats.Degraded {
+					return s.notifyDegraded()
+				}
 				return nil
 			}
 			return s.notifyFailure(err)
 		})
 		s.registerHook(hookLevelInfo, func(err error) error {
-			if err != nil {
+			if err != nil || s.stats.Degraded {
 				return nil
 			}
 			return s.notifySuccess()
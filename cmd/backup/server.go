@@ -0,0 +1,149 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+)
+
+// newServer returns the handler for the HTTP API that is available when
+// running in the foreground. All routes require the given token to be
+// passed as a bearer token.
+func (c *command) newServer(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/run", c.handleRun)
+	mux.HandleFunc("GET /v1/status", c.handleStatus)
+	mux.HandleFunc("POST /v1/reload", c.handleReload)
+	mux.HandleFunc("GET /metrics", c.handleMetrics)
+	return requireToken(token, mux)
+}
+
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: "missing or invalid token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// handleRun triggers a backup run for the configuration given in the
+// `config` query parameter. By default, the run happens in the background
+// and the handler returns immediately. Passing `wait=true` blocks until
+// the run has finished and responds with its result. Requests for a
+// configuration that is currently running are rejected.
+func (c *command) handleRun(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("config")
+	config, ok := c.lookupConfiguration(name)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, apiError{Error: fmt.Sprintf("unknown configuration %q", name)})
+		return
+	}
+
+	start, ok := c.startRun(config)
+	if !ok {
+		writeJSON(w, http.StatusConflict, apiError{Error: fmt.Sprintf("a run of configuration %q is already in progress", config.name)})
+		return
+	}
+
+	c.logger.Info(fmt.Sprintf("Now running script for %s as requested via HTTP API", config.source))
+
+	if r.URL.Query().Get("wait") != "true" {
+		go func() {
+			if _, err := c.finishRun(config, runTriggerAPI, start); err != nil {
+				c.logger.Error(
+					fmt.Sprintf("Unexpected error running script for %s: %v", config.source, errwrap.Unwrap(err)),
+					"error",
+					err,
+				)
+			}
+		}()
+		writeJSON(w, http.StatusAccepted, struct {
+			Config string `json:"config"`
+		}{config.name})
+		return
+	}
+
+	result, err := c.finishRun(config, runTriggerAPI, start)
+	if err != nil {
+		c.logger.Error(
+			fmt.Sprintf("Unexpected error running script for %s: %v", config.source, errwrap.Unwrap(err)),
+			"error",
+			err,
+		)
+	}
+
+	status := http.StatusOK
+	if result.Error != "" {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, result)
+}
+
+type configurationStatus struct {
+	Name         string     `json:"name"`
+	Source       string     `json:"source"`
+	Schedule     string     `json:"schedule"`
+	Running      bool       `json:"running"`
+	RunningSince time.Time  `json:"runningSince,omitzero"`
+	LastRun      *runResult `json:"lastRun,omitempty"`
+}
+
+// handleStatus reports whether runs are currently in progress and the
+// result of the most recent run for each configuration.
+func (c *command) handleStatus(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	result := []configurationStatus{}
+	for _, config := range c.configurations {
+		item := configurationStatus{
+			Name:     config.name,
+			Source:   config.source,
+			Schedule: config.BackupCronExpression,
+		}
+		if status, ok := c.runs[config.name]; ok {
+			item.Running = status.running
+			if item.Running {
+				item.RunningSince = status.runningSince
+			}
+			item.LastRun = status.lastRun
+		}
+		result = append(result, item)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	writeJSON(w, http.StatusOK, struct {
+		Configurations []configurationStatus `json:"configurations"`
+	}{result})
+}
+
+// handleReload requests the configuration to be reloaded. Reloading happens
+// asynchronously.
+func (c *command) handleReload(w http.ResponseWriter, r *http.Request) {
+	c.requestReload()
+	writeJSON(w, http.StatusAccepted, struct{}{})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
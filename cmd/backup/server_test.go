@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleRun(t *testing.T) {
+	tests := []struct {
+		name           string
+		runs           map[string]*runStatus
+		query          string
+		expectedStatus int
+	}{
+		{
+			"unknown configuration",
+			map[string]*runStatus{},
+			"config=other",
+			http.StatusNotFound,
+		},
+		{
+			"run in progress",
+			map[string]*runStatus{"default": {running: true}},
+			"",
+			http.StatusConflict,
+		},
+		{
+			"run in progress with wait",
+			map[string]*runStatus{"default": {running: true}},
+			"wait=true",
+			http.StatusConflict,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newCommand()
+			c.configurations = []*Config{{name: "default", source: "from environment"}}
+			c.runs = test.runs
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/run?"+test.query, nil)
+			req.Header.Set("Authorization", "Bearer token")
+			rec := httptest.NewRecorder()
+			c.newServer("token").ServeHTTP(rec, req)
+			if rec.Code != test.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", test.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if status, ok := c.runs["default"]; ok && !status.running {
+				t.Error("Expected the run in progress to be left untouched")
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	openpgp "github.com/ProtonMail/go-crypto/openpgp/v2"
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"golang.org/x/crypto/ssh"
+)
+
+// signer writes a detached signature of the given message.
+type signer func(message io.Reader, signature io.Writer) error
+
+// signArchive creates a detached signature of the backup file in case a
+// signing key is configured. The signature is uploaded alongside the backup
+// file, so that its authenticity can be verified before restoring it.
+func (s *script) signArchive() (outerr error) {
+	extension, sign, err := s.newSigner()
+	if err != nil {
+		return err
+	}
+	if sign == nil {
+		return nil
+	}
+
+	sigFile := fmt.Sprintf("%s.%s", s.file, extension)
+	if s.c.dryRun {
+		s.companionFiles = append(s.companionFiles, sigFile)
+		s.logger.Info(fmt.Sprintf("Would sign backup, saving the signature as `%s`.", sigFile))
+		return nil
+	}
+
+	s.registerHook(hookLevelPlumbing, func(error) error {
+		if err := remove(sigFile); err != nil {
+			return errwrap.Wrap(err, "error removing signature file")
+		}
+		s.logger.Info(
+			fmt.Sprintf("Removed signature file `%s`.", sigFile),
+		)
+		return nil
+	})
+
+	outFile, err := os.Create(sigFile)
+	if err != nil {
+		return errwrap.Wrap(err, "error opening signature file")
+	}
+	defer func() {
+		if err := outFile.Close(); err != nil {
+			outerr = errors.Join(outerr, errwrap.Wrap(err, "error closing signature file"))
+		}
+	}()
+
+	src, err := os.Open(s.file)
+	if err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error opening backup file %q", s.file))
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			outerr = errors.Join(outerr, errwrap.Wrap(err, "error closing backup file"))
+		}
+	}()
+
+	if err := sign(src, outFile); err != nil {
+		return errwrap.Wrap(err, "error signing backup file")
+	}
+
+	s.companionFiles = append(s.companionFiles, sigFile)
+	s.logger.Info(
+		fmt.Sprintf("Signed backup, saving the signature as %q", sigFile),
+	)
+	return nil
+}
+
+// newSigner parses the configured signing key and returns a signer as well
+// as the extension of the signature file. Both armored OpenPGP private keys
+// and OpenSSH private keys are supported. In case no signing key is
+// configured, the returned signer is nil.
+func (s *script) newSigner() (string, signer, error) {
+	if s.c.BackupSigningKey == "" {
+		return "", nil, nil
+	}
+	key := []byte(s.c.BackupSigningKey)
+	passphrase := []byte(s.c.BackupSigningKeyPassphrase)
+
+	if bytes.Contains(key, []byte("BEGIN PGP PRIVATE KEY BLOCK")) {
+		entityList, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+		if err != nil {
+			return "", nil, errwrap.Wrap(err, "error parsing armored signing key")
+		}
+		for _, entity := range entityList {
+			if entity.PrivateKey == nil {
+				return "", nil, errwrap.Wrap(nil, "signing key does not contain a private key")
+			}
+			if entity.PrivateKey.Encrypted {
+				if err := entity.DecryptPrivateKeys(passphrase); err != nil {
+					return "", nil, errwrap.Wrap(err, "error decrypting signing key")
+				}
+			}
+		}
+		return "asc", func(message io.Reader, signature io.Writer) error {
+			return openpgp.ArmoredDetachSign(signature, entityList, message, nil)
+		}, nil
+	}
+
+	var raw any
+	var err error
+	if len(passphrase) != 0 {
+		raw, err = ssh.ParseRawPrivateKeyWithPassphrase(key, passphrase)
+	} else {
+		raw, err = ssh.ParseRawPrivateKey(key)
+	}
+	if err != nil {
+		return "", nil, errwrap.Wrap(err, "error parsing signing key, expected an armored OpenPGP or an OpenSSH private key")
+	}
+	sshSigner, err := ssh.NewSignerFromKey(raw)
+	if err != nil {
+		return "", nil, errwrap.Wrap(err, "error creating signer from signing key")
+	}
+	return "sig", func(message io.Reader, signature io.Writer) error {
+		return signSSH(sshSigner, message, signature)
+	}, nil
+}
+
+// The following implements the signature format used by `ssh-keygen -Y`
+// as described in https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+// Signatures use the namespace `file`, so they can be verified using
+// `ssh-keygen -Y verify -n file`.
+const (
+	sshSigMagic         = "SSHSIG"
+	sshSigNamespace     = "file"
+	sshSigHashAlgorithm = "sha512"
+	sshSigArmorType     = "SSH SIGNATURE"
+)
+
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+type sshSignatureBlob struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+func sshSignedMessage(message io.Reader, namespace, hashAlgorithm string) ([]byte, error) {
+	if hashAlgorithm != sshSigHashAlgorithm {
+		return nil, errwrap.Wrap(nil, fmt.Sprintf("unsupported hash algorithm %s", hashAlgorithm))
+	}
+	h := sha512.New()
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, errwrap.Wrap(err, "error hashing message")
+	}
+	return append([]byte(sshSigMagic), ssh.Marshal(sshSignedData{
+		Namespace:     namespace,
+		HashAlgorithm: hashAlgorithm,
+		Hash:          h.Sum(nil),
+	})...), nil
+}
+
+func signSSH(signer ssh.Signer, message io.Reader, signature io.Writer) error {
+	data, err := sshSignedMessage(message, sshSigNamespace, sshSigHashAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	var sig *ssh.Signature
+	if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = algorithmSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return errwrap.Wrap(err, "error creating signature")
+	}
+
+	blob := append([]byte(sshSigMagic), ssh.Marshal(sshSignatureBlob{
+		Version:       1,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     sshSigNamespace,
+		HashAlgorithm: sshSigHashAlgorithm,
+		Signature:     ssh.Marshal(sig),
+	})...)
+
+	// ssh-keygen wraps the base64 encoded signature at 70 characters.
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var armored strings.Builder
+	armored.WriteString(fmt.Sprintf("-----BEGIN %s-----\n", sshSigArmorType))
+	for len(encoded) > 70 {
+		armored.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	armored.WriteString(encoded + "\n")
+	armored.WriteString(fmt.Sprintf("-----END %s-----\n", sshSigArmorType))
+	if _, err := io.WriteString(signature, armored.String()); err != nil {
+		return errwrap.Wrap(err, "error writing signature")
+	}
+	return nil
+}
+
+func verifySSH(publicKey ssh.PublicKey, message io.Reader, signature []byte) error {
+	block, _ := pem.Decode(signature)
+	if block == nil || block.Type != sshSigArmorType {
+		return errwrap.Wrap(nil, "expected an armored SSH signature")
+	}
+	blob, ok := bytes.CutPrefix(block.Bytes, []byte(sshSigMagic))
+	if !ok {
+		return errwrap.Wrap(nil, "invalid SSH signature")
+	}
+	var parsed sshSignatureBlob
+	if err := ssh.Unmarshal(blob, &parsed); err != nil {
+		return errwrap.Wrap(err, "error parsing SSH signature")
+	}
+	if parsed.Version != 1 {
+		return errwrap.Wrap(nil, fmt.Sprintf("unsupported SSH signature version %d", parsed.Version))
+	}
+	if parsed.Namespace != sshSigNamespace {
+		return errwrap.Wrap(nil, fmt.Sprintf("unexpected SSH signature namespace %s", parsed.Namespace))
+	}
+	if !bytes.Equal(parsed.PublicKey, publicKey.Marshal()) {
+		return errwrap.Wrap(nil, "signature was not created using the given key")
+	}
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(parsed.Signature, &sig); err != nil {
+		return errwrap.Wrap(err, "error parsing SSH signature")
+	}
+	data, err := sshSignedMessage(message, parsed.Namespace, parsed.HashAlgorithm)
+	if err != nil {
+		return err
+	}
+	if err := publicKey.Verify(data, &sig); err != nil {
+		return errwrap.Wrap(err, "invalid signature")
+	}
+	return nil
+}
+
+// verifySignature verifies the detached signature of the given file using
+// the given armored OpenPGP public key or OpenSSH public key. It returns a
+// description of the key that created the signature.
+func verifySignature(file, signature string, key []byte) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", errwrap.Wrap(err, fmt.Sprintf("error opening %s", file))
+	}
+	defer f.Close()
+
+	sig, err := os.ReadFile(signature)
+	if err != nil {
+		return "", errwrap.Wrap(err, fmt.Sprintf("error reading signature %s", signature))
+	}
+
+	if bytes.Contains(key, []byte("BEGIN PGP PUBLIC KEY BLOCK")) {
+		entityList, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+		if err != nil {
+			return "", errwrap.Wrap(err, "error parsing armored public key")
+		}
+		_, entity, err := openpgp.VerifyArmoredDetachedSignature(entityList, f, bytes.NewReader(sig), nil)
+		if err != nil {
+			return "", errwrap.Wrap(err, "invalid signature")
+		}
+		return fmt.Sprintf("OpenPGP key %s", entity.PrimaryKey.KeyIdString()), nil
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(key)
+	if err != nil {
+		return "", errwrap.Wrap(err, "error parsing public key, expected an armored OpenPGP or an OpenSSH public key")
+	}
+	if err := verifySSH(publicKey, f, sig); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("SSH key %s", ssh.FingerprintSHA256(publicKey)), nil
+}
+
+// runVerify implements the `verify` subcommand which verifies the signature
+// of a backup file.
+func (c *command) runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	keyFile := fs.String("key", "", "location of the armored OpenPGP or OpenSSH public key used for verifying")
+	signature := fs.String("signature", "", "location of the signature, defaults to the backup file with a .asc or .sig extension")
+	if err := fs.Parse(args); err != nil {
+		return errwrap.Wrap(err, "error parsing flags")
+	}
+	if fs.NArg() != 1 || *keyFile == "" {
+		return errwrap.Wrap(nil, "usage: backup verify -key <public key> [-signature <signature>] <backup file>")
+	}
+	file := fs.Arg(0)
+
+	key, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error reading key %s", *keyFile))
+	}
+
+	if *signature == "" {
+		for _, extension := range []string{"asc", "sig"} {
+			candidate := fmt.Sprintf("%s.%s", file, extension)
+			if _, err := os.Stat(candidate); err == nil {
+				*signature = candidate
+				break
+			}
+		}
+		if *signature == "" {
+			return errwrap.Wrap(nil, fmt.Sprintf("no signature found for %s", file))
+		}
+	}
+
+	signer, err := verifySignature(file, *signature, key)
+	if err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error verifying %s", file))
+	}
+	fmt.Printf("%s: good signature from %s\n", file, signer)
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	openpgp "github.com/ProtonMail/go-crypto/openpgp/v2"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSignArchive(t *testing.T) {
+	tests := []struct {
+		name              string
+		keys              func(t *testing.T) (private string, public []byte)
+		expectedExtension string
+	}{
+		{
+			"ssh",
+			func(t *testing.T) (string, []byte) {
+				public, private, err := ed25519.GenerateKey(rand.Reader)
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				block, err := ssh.MarshalPrivateKey(private, "")
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				sshPublic, err := ssh.NewPublicKey(public)
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				return string(pem.EncodeToMemory(block)), ssh.MarshalAuthorizedKey(sshPublic)
+			},
+			"sig",
+		},
+		{
+			"openpgp",
+			func(t *testing.T) (string, []byte) {
+				entity, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				var private, public bytes.Buffer
+				w, _ := armor.Encode(&private, "PGP PRIVATE KEY BLOCK", nil)
+				if err := entity.SerializePrivate(w, nil); err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				w.Close()
+				w, _ = armor.Encode(&public, "PGP PUBLIC KEY BLOCK", nil)
+				if err := entity.Serialize(w); err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				w.Close()
+				return private.String(), public.Bytes()
+			},
+			"asc",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			private, public := test.keys(t)
+			file := filepath.Join(t.TempDir(), "backup.tar.gz")
+			if err := os.WriteFile(file, []byte("backup"), 0644); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			s := newScript(&Config{BackupSigningKey: private})
+			s.file = file
+			if err := s.signArchive(); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			signature := file + "." + test.expectedExtension
+			if len(s.companionFiles) != 1 || s.companionFiles[0] != signature {
+				t.Fatalf("Unexpected companion files %v", s.companionFiles)
+			}
+			if _, err := verifySignature(file, signature, public); err != nil {
+				t.Errorf("Expected signature to be valid, got %v", err)
+			}
+
+			if err := os.WriteFile(file, []byte("tampered"), 0644); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if _, err := verifySignature(file, signature, public); err == nil {
+				t.Error("Expected signature of tampered file to be invalid")
+			}
+		})
+	}
+}
@@ -35,21 +35,43 @@ type BackupFileStats struct {
 
 // StorageStats stats about the status of an archival directory
 type StorageStats struct {
-	Total       uint
-	Pruned      uint
-	PruneErrors uint
+	Uploaded         bool
+	UploadError      string
+	UploadDuration   time.Duration
+	UploadBytes      uint64
+	UploadThroughput uint64
+	UploadAttempts   uint
+	Verified         bool
+	VerifyMethod     string
+	Total            uint
+	Pruned           uint
+	Locked           uint
+	PruneRefused     bool
+	PruneErrors      uint
+	PruneError       string
+	PruneAttempts    uint
+}
+
+// PhaseStats stats about a single phase of the backup lifecycle, including
+// the commands that are run before and after it
+type PhaseStats struct {
+	Duration time.Duration
 }
 
 // Stats global stats regarding script execution
 type Stats struct {
 	sync.Mutex
-	StartTime  time.Time
-	EndTime    time.Time
-	TookTime   time.Duration
-	LockedTime time.Duration
-	LogOutput  *bytes.Buffer
-	Containers ContainersStats
-	Services   ServicesStats
-	BackupFile BackupFileStats
-	Storages   map[string]StorageStats
+	Degraded        bool
+	StartTime       time.Time
+	EndTime         time.Time
+	TookTime        time.Duration
+	LockedTime      time.Duration
+	StopDuration    time.Duration
+	RestartDuration time.Duration
+	LogOutput       *bytes.Buffer
+	Containers      ContainersStats
+	Services        ServicesStats
+	BackupFile      BackupFileStats
+	Storages        map[string]StorageStats
+	Phases          map[string]PhaseStats
 }
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -99,6 +100,7 @@ func (s *script) stopContainersAndServices() (func() error, error) {
 		return noop, nil
 	}
 
+	stopStart := time.Now()
 	isDockerSwarm, err := isSwarm(s.cli)
 	if err != nil {
 		return noop, errwrap.Wrap(err, "error determining swarm state")
@@ -139,6 +141,7 @@ func (s *script) stopContainersAndServices() (func() error, error) {
 
 	var allServices []swarm.Service
 	var servicesToScaleDown []handledSwarmService
+	var matchingServiceNames []string
 	if isDockerSwarm {
 		allServices, err = s.cli.ServiceList(context.Background(), swarm.ServiceListOptions{})
 		if err != nil {
@@ -165,6 +168,7 @@ func (s *script) stopContainersAndServices() (func() error, error) {
 				serviceID:           s.ID,
 				initialReplicaCount: *s.Spec.Mode.Replicated.Replicas,
 			})
+			matchingServiceNames = append(matchingServiceNames, s.Spec.Name)
 		}
 	}
 
@@ -195,6 +199,16 @@ func (s *script) stopContainersAndServices() (func() error, error) {
 		}
 	}
 
+	if s.c.dryRun {
+		for _, container := range containersToStop {
+			s.logger.Info(fmt.Sprintf("Would stop container %s.", strings.TrimPrefix(container.Names[0], "/")))
+		}
+		for _, svc := range matchingServiceNames {
+			s.logger.Info(fmt.Sprintf("Would scale down service %s.", svc))
+		}
+		return noop, nil
+	}
+
 	s.logger.Info(
 		fmt.Sprintf(
 			"Stopping %d out of %d running container(s) as they were labeled %s.",
@@ -266,6 +280,7 @@ func (s *script) stopContainersAndServices() (func() error, error) {
 		ScaledDown:      uint(len(scaledDownServices)),
 		ScaleDownErrors: uint(len(scaleDownErrors.value())),
 	}
+	s.stats.StopDuration = time.Since(stopStart)
 
 	var initialErr error
 	allErrors := append(stopErrors, scaleDownErrors.value()...)
@@ -280,6 +295,11 @@ func (s *script) stopContainersAndServices() (func() error, error) {
 	}
 
 	return func() error {
+		restartStart := time.Now()
+		defer func() {
+			s.stats.RestartDuration = time.Since(restartStart)
+		}()
+
 		var restartErrors []error
 		matchedServices := map[string]bool{}
 		for _, container := range stoppedContainers {
@@ -0,0 +1,264 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"github.com/offen/docker-volume-backup/internal/storage"
+	"github.com/offen/docker-volume-backup/internal/storage/azure"
+	"github.com/offen/docker-volume-backup/internal/storage/dropbox"
+	"github.com/offen/docker-volume-backup/internal/storage/googledrive"
+	"github.com/offen/docker-volume-backup/internal/storage/local"
+	"github.com/offen/docker-volume-backup/internal/storage/s3"
+	"github.com/offen/docker-volume-backup/internal/storage/ssh"
+	"github.com/offen/docker-volume-backup/internal/storage/webdav"
+)
+
+// storageTypes lists all supported types of storage backends in the order
+// the unnamed backends are created in.
+var storageTypes = []StorageType{"s3", "webdav", "ssh", "local", "azure", "dropbox", "googledrive"}
+
+// connectingStorageTypes are the types of storage backends that connect to
+// the remote when being created.
+var connectingStorageTypes = []StorageType{"ssh", "dropbox"}
+
+// configures returns whether the given configuration sets up an unnamed
+// backend of the given type.
+func (c *StorageConfig) configures(t StorageType) bool {
+	switch t {
+	case "s3":
+		return c.AwsS3BucketName != ""
+	case "webdav":
+		return c.WebdavUrl != ""
+	case "ssh":
+		return c.SSHHostName != ""
+	case "local":
+		_, err := os.Stat(c.BackupArchive)
+		return !os.IsNotExist(err)
+	case "azure":
+		return c.AzureStorageAccountName != ""
+	case "dropbox":
+		return c.DropboxRefreshToken != "" && c.DropboxAppKey != "" && c.DropboxAppSecret != ""
+	case "googledrive":
+		return c.GoogleDriveCredentialsJSON != ""
+	default:
+		return false
+	}
+}
+
+// destination describes the location backups are uploaded to by the backend
+// of the given type.
+func (c *StorageConfig) destination(t StorageType) string {
+	switch t {
+	case "s3":
+		return fmt.Sprintf("%s://%s/%s", c.AwsEndpointProto, c.AwsEndpoint, path.Join(c.AwsS3BucketName, c.AwsS3Path))
+	case "webdav":
+		return fmt.Sprintf("%s/%s", strings.TrimSuffix(c.WebdavUrl, "/"), strings.TrimPrefix(c.WebdavPath, "/"))
+	case "ssh":
+		return fmt.Sprintf("%s:%s", c.SSHHostName, c.SSHRemotePath)
+	case "local":
+		return c.BackupArchive
+	case "azure":
+		return path.Join(c.AzureStorageAccountName, c.AzureStorageContainerName, c.AzureStoragePath)
+	case "dropbox":
+		return c.DropboxRemotePath
+	case "googledrive":
+		return fmt.Sprintf("folder %s", c.GoogleDriveFolderID)
+	default:
+		return ""
+	}
+}
+
+// configuredStorages returns the configuration of all storage backends
+// that are configured. Unnamed backends have an empty name.
+func (c *Config) configuredStorages() []NamedStorageConfig {
+	var storages []NamedStorageConfig
+	for _, t := range storageTypes {
+		if c.StorageConfig.configures(t) {
+			storages = append(storages, NamedStorageConfig{Type: t, StorageConfig: c.StorageConfig})
+		}
+	}
+	return append(storages, c.Storages...)
+}
+
+// usesUploadTemplates returns whether any of the configured storages renders
+// templates when uploading files.
+func (c *Config) usesUploadTemplates() bool {
+	for _, n := range c.configuredStorages() {
+		if n.Type == "s3" && (n.AwsS3ObjectTags.String() != "" || n.AwsS3ObjectMetadata.String() != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadRenderer renders the given templates for uploading the given file.
+type uploadRenderer func(file string, m *TemplateMap) (map[string]string, error)
+
+// newStorageBackend creates the configured storage backend. render may be
+// nil in case the backend is not used for uploading files.
+func (n *NamedStorageConfig) newStorageBackend(logFunc storage.Log, render uploadRenderer) (storage.Backend, error) {
+	backend, err := newStorageBackend(n, logFunc, render)
+	if err != nil {
+		if n.Name != "" {
+			return nil, errwrap.Wrap(err, fmt.Sprintf("error creating storage backend instance %s", n.Name))
+		}
+		return nil, err
+	}
+	if nameable, ok := backend.(storage.NameableBackend); ok && n.Name != "" {
+		nameable.SetName(n.Name)
+	}
+	return backend, nil
+}
+
+// newStorageBackend creates a storage backend of the given type using the
+// given configuration.
+func newStorageBackend(n *NamedStorageConfig, logFunc storage.Log, render uploadRenderer) (storage.Backend, error) {
+	c := &n.StorageConfig
+	switch n.Type {
+	case "s3":
+		s3Config := s3.Config{
+			Endpoint:         c.AwsEndpoint,
+			AccessKeyID:      c.AwsAccessKeyID,
+			SecretAccessKey:  c.AwsSecretAccessKey,
+			IamRoleEndpoint:  c.AwsIamRoleEndpoint,
+			EndpointProto:    c.AwsEndpointProto,
+			EndpointInsecure: c.AwsEndpointInsecure,
+			RemotePath:       c.AwsS3Path,
+			BucketName:       c.AwsS3BucketName,
+			StorageClass:     c.AwsStorageClass,
+			CACert:           c.AwsEndpointCACert.Cert,
+			PartSize:         c.AwsPartSize,
+
+			SessionToken:          c.AwsSessionToken,
+			RoleARN:               c.AwsRoleArn,
+			RoleSessionName:       c.AwsRoleSessionName,
+			RoleExternalID:        c.AwsRoleExternalID,
+			WebIdentityTokenFile:  c.AwsWebIdentityTokenFile,
+			STSEndpoint:           c.AwsStsEndpoint,
+			STSRegion:             c.AwsStsRegion,
+			SharedCredentialsFile: c.AwsSharedCredentialsFile,
+			Profile:               c.AwsProfile,
+			ServerSideEncryption:  c.AwsS3ServerSideEncryption,
+			KMSKeyID:              c.AwsS3SseKmsKeyID,
+			CustomerKey:           c.AwsS3SseCustomerKey,
+			ObjectLockMode:        c.AwsS3ObjectLockMode,
+			LegalHold:             c.AwsS3ObjectLockLegalHold,
+
+			// Credentials from the environment belong to the unnamed
+			// backend, so they are not looked up for named instances.
+			DefaultCredentials: n.Name == "",
+		}
+		if render != nil {
+			s3Config.Annotate = func(file string) (map[string]string, map[string]string, error) {
+				tags, err := render(file, &c.AwsS3ObjectTags)
+				if err != nil {
+					return nil, nil, errwrap.Wrap(err, "error rendering AWS_S3_OBJECT_TAGS")
+				}
+				metadata, err := render(file, &c.AwsS3ObjectMetadata)
+				if err != nil {
+					return nil, nil, errwrap.Wrap(err, "error rendering AWS_S3_OBJECT_METADATA")
+				}
+				return tags, metadata, nil
+			}
+		}
+		s3Backend, err := s3.NewStorageBackend(s3Config, logFunc)
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error creating s3 storage backend")
+		}
+		return s3Backend, nil
+	case "webdav":
+		webDavConfig := webdav.Config{
+			URL:         c.WebdavUrl,
+			URLInsecure: c.WebdavUrlInsecure,
+			Username:    c.WebdavUsername,
+			Password:    c.WebdavPassword,
+			RemotePath:  c.WebdavPath,
+		}
+		webdavBackend, err := webdav.NewStorageBackend(webDavConfig, logFunc)
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error creating webdav storage backend")
+		}
+		return webdavBackend, nil
+	case "ssh":
+		sshConfig := ssh.Config{
+			HostName:               c.SSHHostName,
+			Port:                   c.SSHPort,
+			User:                   c.SSHUser,
+			Password:               c.SSHPassword,
+			IdentityFile:           c.SSHIdentityFile,
+			IdentityPassphrase:     c.SSHIdentityPassphrase,
+			RemotePath:             c.SSHRemotePath,
+			KnownHostsFile:         c.SSHKnownHosts,
+			HostKeyFingerprints:    c.SSHHostKeyFingerprint,
+			HostKeyTrustOnFirstUse: c.SSHHostKeyTrustOnFirstUse,
+			AgentSocket:            c.SSHAuthSock,
+			CertificateFile:        c.SSHCertificateFile,
+			ProxyJump:              c.SSHProxyJump,
+		}
+		sshBackend, err := ssh.NewStorageBackend(sshConfig, logFunc)
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error creating ssh storage backend")
+		}
+		return sshBackend, nil
+	case "local":
+		localConfig := local.Config{
+			ArchivePath:   c.BackupArchive,
+			LatestSymlink: c.BackupLatestSymlink,
+		}
+		return local.NewStorageBackend(localConfig, logFunc), nil
+	case "azure":
+		azureConfig := azure.Config{
+			ContainerName:     c.AzureStorageContainerName,
+			AccountName:       c.AzureStorageAccountName,
+			PrimaryAccountKey: c.AzureStoragePrimaryAccountKey,
+			Endpoint:          c.AzureStorageEndpoint,
+			RemotePath:        c.AzureStoragePath,
+			ConnectionString:  c.AzureStorageConnectionString,
+			AccessTier:        c.AzureStorageAccessTier,
+			ImmutabilityMode:  c.AzureStorageImmutabilityMode,
+			LegalHold:         c.AzureStorageLegalHold,
+		}
+		azureBackend, err := azure.NewStorageBackend(azureConfig, logFunc)
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error creating azure storage backend")
+		}
+		return azureBackend, nil
+	case "dropbox":
+		dropboxConfig := dropbox.Config{
+			Endpoint:         c.DropboxEndpoint,
+			OAuth2Endpoint:   c.DropboxOAuth2Endpoint,
+			RefreshToken:     c.DropboxRefreshToken,
+			AppKey:           c.DropboxAppKey,
+			AppSecret:        c.DropboxAppSecret,
+			RemotePath:       c.DropboxRemotePath,
+			ConcurrencyLevel: c.DropboxConcurrencyLevel.Int(),
+		}
+		dropboxBackend, err := dropbox.NewStorageBackend(dropboxConfig, logFunc)
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error creating dropbox storage backend")
+		}
+		return dropboxBackend, nil
+	case "googledrive":
+		googleDriveConfig := googledrive.Config{
+			CredentialsJSON:    c.GoogleDriveCredentialsJSON,
+			FolderID:           c.GoogleDriveFolderID,
+			ImpersonateSubject: c.GoogleDriveImpersonateSubject,
+			Endpoint:           c.GoogleDriveEndpoint,
+			TokenURL:           c.GoogleDriveTokenURL,
+		}
+		googleDriveBackend, err := googledrive.NewStorageBackend(googleDriveConfig, logFunc)
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error creating googledrive storage backend")
+		}
+		return googleDriveBackend, nil
+	default:
+		return nil, errwrap.Wrap(nil, fmt.Sprintf("unknown storage type %s", n.Type))
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+)
+
+// applySuccessPolicy decides whether the run can continue after an operation
+// failed for the given backends. Backends that failed in an earlier operation
+// are not considered successful either. In case the configured policy is
+// still satisfied, the run is marked as degraded and no error is returned.
+func (s *script) applySuccessPolicy(failed map[string]error) error {
+	if len(failed) == 0 {
+		return nil
+	}
+
+	var all, succeeded, failedNames []string
+	var errs []error
+	s.stats.Lock()
+	for _, backend := range s.storages {
+		name := backend.Name()
+		all = append(all, name)
+		if err, ok := failed[name]; ok {
+			failedNames = append(failedNames, name)
+			errs = append(errs, errwrap.Wrap(err, name))
+			continue
+		}
+		if stats := s.stats.Storages[name]; stats.UploadError == "" && stats.PruneError == "" {
+			succeeded = append(succeeded, name)
+		}
+	}
+	s.stats.Unlock()
+
+	if !s.c.BackupSuccessPolicy.Satisfied(all, succeeded) {
+		return errwrap.Wrap(
+			errors.Join(errs...),
+			fmt.Sprintf(
+				"failed for [%s], succeeded for [%s]",
+				strings.Join(failedNames, ", "),
+				strings.Join(succeeded, ", "),
+			),
+		)
+	}
+
+	s.stats.Degraded = true
+	s.logger.Warn(
+		fmt.Sprintf(
+			"Operation failed for [%s] but succeeded for [%s] which satisfies the configured success policy, marking run as degraded: %v",
+			strings.Join(failedNames, ", "),
+			strings.Join(succeeded, ", "),
+			errwrap.Unwrap(errors.Join(errs...)),
+		),
+	)
+	return nil
+}
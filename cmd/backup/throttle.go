@@ -0,0 +1,50 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// throttleReadSize is the maximum number of bytes read from an archive
+// at once while uploads are throttled, so the rate is limited smoothly
+// instead of in large bursts.
+const throttleReadSize = 32 * 1024
+
+// throttle limits the rate at which bytes are passed through it. It can be
+// shared by multiple uploads, in which case they share the bandwidth.
+type throttle struct {
+	limit BandwidthLimit
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newThrottle(limit BandwidthLimit) *throttle {
+	return &throttle{limit: limit, now: time.Now, sleep: time.Sleep}
+}
+
+// wait blocks until the given number of bytes may be sent without
+// exceeding the rate that currently applies.
+func (t *throttle) wait(n int) {
+	now := t.now()
+	rate := t.limit.At(now)
+	if rate == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	// Idle time is not saved up, so bursts after a pause are not possible.
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(float64(n) / float64(rate) * float64(time.Second)))
+	d := t.next.Sub(now)
+	t.mu.Unlock()
+
+	t.sleep(d)
+}
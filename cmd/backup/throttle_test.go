@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.Local)
+	var slept time.Duration
+	th := newThrottle(BandwidthLimit{Default: 1000})
+	th.now = func() time.Time { return now }
+	th.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	for range 4 {
+		th.wait(500)
+	}
+	if slept != 2*time.Second {
+		t.Errorf("Expected to sleep for 2s, got %v", slept)
+	}
+
+	// idle time must not allow a burst
+	now = now.Add(time.Hour)
+	slept = 0
+	th.wait(1000)
+	if slept != time.Second {
+		t.Errorf("Expected to sleep for 1s after idling, got %v", slept)
+	}
+
+	slept = 0
+	th.limit = BandwidthLimit{}
+	th.wait(1000)
+	if slept != 0 {
+		t.Errorf("Expected not to sleep without a limit, got %v", slept)
+	}
+}
@@ -12,7 +12,6 @@ import (
 	"time"
 
 	"github.com/offen/docker-volume-backup/internal/errwrap"
-	"github.com/robfig/cron/v3"
 )
 
 var noop = func() error { return nil }
@@ -86,19 +85,11 @@ func (c *concurrentSlice[T]) value() []T {
 
 // checkCronSchedule detects whether the given cron expression will actually
 // ever be executed or not.
-func checkCronSchedule(expression string) (ok bool) {
-	defer func() {
-		if err := recover(); err != nil {
-			ok = false
-		}
-	}()
-	sched, err := cron.ParseStandard(expression)
+func checkCronSchedule(expression string) bool {
+	sched, err := scheduleParser.Parse(expression)
 	if err != nil {
-		ok = false
-		return
+		return false
 	}
-	now := time.Now()
-	sched.Next(now) // panics when the cron would never run
-	ok = true
-	return
+	// The zero time is returned when the cron would never run.
+	return !sched.Next(time.Now()).IsZero()
 }
@@ -0,0 +1,175 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+)
+
+// vaultTransit wraps and unwraps data keys using the transit secrets engine
+// of HashiCorp Vault, or any other service implementing its HTTP API, so
+// the key itself never has to be stored.
+type vaultTransit struct {
+	address   string
+	token     string
+	namespace string
+	mountPath string
+	keyName   string
+	client    *http.Client
+}
+
+func newVaultTransit(address, token, namespace, mountPath, keyName string) *vaultTransit {
+	return &vaultTransit{
+		address:   strings.TrimSuffix(address, "/"),
+		token:     token,
+		namespace: namespace,
+		mountPath: strings.Trim(mountPath, "/"),
+		keyName:   keyName,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// keyID identifies the transit key, e.g. `transit/backup`.
+func (v *vaultTransit) keyID() string {
+	return fmt.Sprintf("%s/%s", v.mountPath, v.keyName)
+}
+
+// wrap encrypts the given data key, returning the ciphertext as returned
+// by Vault, e.g. `vault:v1:...`.
+func (v *vaultTransit) wrap(key []byte) (string, error) {
+	var response struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := v.do("encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(key),
+	}, &response); err != nil {
+		return "", errwrap.Wrap(err, "error wrapping data key")
+	}
+	if response.Ciphertext == "" {
+		return "", errwrap.Wrap(nil, "response did not contain a ciphertext")
+	}
+	return response.Ciphertext, nil
+}
+
+// unwrap decrypts the given wrapped data key.
+func (v *vaultTransit) unwrap(wrapped string) ([]byte, error) {
+	var response struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := v.do("decrypt", map[string]string{
+		"ciphertext": wrapped,
+	}, &response); err != nil {
+		return nil, errwrap.Wrap(err, "error unwrapping data key")
+	}
+	key, err := base64.StdEncoding.DecodeString(response.Plaintext)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error decoding data key")
+	}
+	return key, nil
+}
+
+func (v *vaultTransit) do(operation string, payload any, data any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errwrap.Wrap(err, "error marshaling request")
+	}
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", v.address, v.mountPath, operation, v.keyName)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return errwrap.Wrap(err, "error creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	res, err := v.client.Do(req)
+	if err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error calling %s", url))
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return errwrap.Wrap(err, "error reading response")
+	}
+
+	var response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err := json.Unmarshal(b, &response); err != nil && res.StatusCode == http.StatusOK {
+		return errwrap.Wrap(err, "error parsing response")
+	}
+	if res.StatusCode != http.StatusOK {
+		if len(response.Errors) != 0 {
+			return errwrap.Wrap(nil, fmt.Sprintf("received status %s: %s", res.Status, strings.Join(response.Errors, ", ")))
+		}
+		return errwrap.Wrap(nil, fmt.Sprintf("received status %s", res.Status))
+	}
+	if err := json.Unmarshal(response.Data, data); err != nil {
+		return errwrap.Wrap(err, "error parsing response data")
+	}
+	return nil
+}
+
+// encryptWithVaultTransit encrypts the backup using a one-off age identity
+// that is wrapped using Vault Transit. Only the wrapped identity is stored
+// in the encryption metadata.
+func (s *script) encryptWithVaultTransit(v *vaultTransit) encryptor {
+	return func(ciphertextWriter io.Writer) (io.WriteCloser, error) {
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error generating data key")
+		}
+		wrapped, err := v.wrap([]byte(identity.String()))
+		if err != nil {
+			return nil, err
+		}
+		s.encryptionKeys = []encryptionKey{{
+			Type:        keyTypeVaultTransit,
+			Fingerprint: v.keyID(),
+			WrappedKey:  wrapped,
+		}}
+		return age.Encrypt(ciphertextWriter, identity.Recipient())
+	}
+}
+
+// unwrapVaultTransitKey unwraps the data key stored in the given metadata
+// using the Vault instance configured by the VAULT_ADDR, VAULT_TOKEN and
+// VAULT_NAMESPACE environment variables as used by the Vault CLI.
+func unwrapVaultTransitKey(key encryptionKey) (age.Identity, error) {
+	address, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if address == "" || token == "" {
+		return nil, errwrap.Wrap(
+			nil,
+			fmt.Sprintf("the backup key is wrapped using the Vault Transit key %s, set VAULT_ADDR and VAULT_TOKEN to unwrap it", key.Fingerprint),
+		)
+	}
+	idx := strings.LastIndex(key.Fingerprint, "/")
+	if idx == -1 {
+		return nil, errwrap.Wrap(nil, fmt.Sprintf("invalid Vault Transit key %s", key.Fingerprint))
+	}
+	v := newVaultTransit(address, token, os.Getenv("VAULT_NAMESPACE"), key.Fingerprint[:idx], key.Fingerprint[idx+1:])
+	b, err := v.unwrap(key.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := age.ParseX25519Identity(string(b))
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error parsing unwrapped data key")
+	}
+	return identity, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+)
+
+// watchConfiguration requests a reload of the configuration each time the
+// contents of the given directory change. Events are debounced so that
+// editing multiple files results in a single reload. In case the directory
+// does not exist, nothing is watched. The returned function stops watching.
+func (c *command) watchConfiguration(directory string, debounce time.Duration) (func() error, error) {
+	if _, err := os.Stat(directory); err != nil {
+		if os.IsNotExist(err) {
+			return noop, nil
+		}
+		return noop, errwrap.Wrap(err, fmt.Sprintf("error checking for existence of %s", directory))
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return noop, errwrap.Wrap(err, "error creating file watcher")
+	}
+	if err := watcher.Add(directory); err != nil {
+		_ = watcher.Close()
+		return noop, errwrap.Wrap(err, fmt.Sprintf("error watching %s", directory))
+	}
+
+	go func() {
+		var pending <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Chmod) && !event.Has(fsnotify.Write) {
+					continue
+				}
+				pending = time.After(debounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				c.logger.Warn(fmt.Sprintf("Error watching %s for changes: %v", directory, err))
+			case <-pending:
+				pending = nil
+				c.logger.Info(fmt.Sprintf("Detected changes in %s, reloading configuration.", directory))
+				c.requestReload()
+			}
+		}
+	}()
+
+	c.logger.Info(fmt.Sprintf("Watching %s for changes.", directory))
+	return watcher.Close, nil
+}
+
+// requestReload asks the main loop to reload the configuration. In case a
+// reload is already pending, the request is dropped.
+func (c *command) requestReload() {
+	select {
+	case c.reload <- struct{}{}:
+	default:
+	}
+}
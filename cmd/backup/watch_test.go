@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestWatchConfiguration(t *testing.T) {
+	dir := t.TempDir()
+	c := newCommand()
+	c.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	// The channel is buffered so that additional reloads are not dropped
+	// and can be counted.
+	c.reload = make(chan struct{}, 10)
+
+	debounce := 200 * time.Millisecond
+	stop, err := c.watchConfiguration(dir, debounce)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer stop()
+
+	for i := range 3 {
+		file := filepath.Join(dir, fmt.Sprintf("%d.env", i))
+		if err := os.WriteFile(file, []byte("BACKUP_CRON_EXPRESSION=@daily\n"), 0644); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		time.Sleep(debounce / 10)
+	}
+
+	select {
+	case <-c.reload:
+	case <-time.After(10 * debounce):
+		t.Fatal("Expected configuration to be reloaded")
+	}
+	time.Sleep(3 * debounce)
+	if n := len(c.reload); n != 0 {
+		t.Errorf("Expected exactly one reload, got %d additional ones", n)
+	}
+}
+
+func TestWatchConfigurationMissingDirectory(t *testing.T) {
+	c := newCommand()
+	stop, err := c.watchConfiguration(filepath.Join(t.TempDir(), "conf.d"), time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestScheduleInvalidReload(t *testing.T) {
+	valid := "jobs:\n  - name: app\n    schedule: \"0 2 * * *\"\n"
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"invalid schedule", "jobs:\n  - name: app\n    schedule: \"not a schedule\"\n"},
+		{"malformed file", "jobs:\n  - name: [app\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location := filepath.Join(t.TempDir(), "config.yml")
+			if err := os.WriteFile(location, []byte(valid), 0644); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			t.Setenv("BACKUP_CONFIG_FILE", location)
+
+			c := newCommand()
+			c.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+			c.cr = cron.New(cron.WithParser(scheduleParser))
+			if err := c.schedule(configStrategyFile); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			schedules := slices.Clone(c.schedules)
+
+			if err := os.WriteFile(location, []byte(test.content), 0644); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if err := c.schedule(configStrategyFile); err == nil {
+				t.Fatal("Expected an error reloading the configuration")
+			}
+
+			if !slices.Equal(c.schedules, schedules) {
+				t.Errorf("Expected schedules %v to be kept, got %v", schedules, c.schedules)
+			}
+			entries := c.cr.Entries()
+			if len(entries) != 1 || entries[0].ID != schedules[0] {
+				t.Errorf("Expected previous cron entry to be kept, got %v", entries)
+			}
+			if len(c.configurations) != 1 || c.configurations[0].BackupCronExpression != "0 2 * * *" {
+				t.Errorf("Expected previous configuration to be kept, got %v", c.configurations)
+			}
+		})
+	}
+}
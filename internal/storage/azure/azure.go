@@ -6,19 +6,24 @@ package azure
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/offen/docker-volume-backup/internal/errwrap"
@@ -30,6 +35,9 @@ type azureBlobStorage struct {
 	client              *azblob.Client
 	uploadStreamOptions *blockblob.UploadStreamOptions
 	containerName       string
+	immutabilityMode    *blob.ImmutabilityPolicySetting
+	legalHold           bool
+	retention           time.Duration
 }
 
 // Config contains values that define the configuration of an Azure Blob Storage.
@@ -41,6 +49,11 @@ type Config struct {
 	Endpoint          string
 	RemotePath        string
 	AccessTier        string
+	// ImmutabilityMode is one of `Unlocked` or `Locked`. Uploaded blobs are
+	// immutable for the duration passed to RetainFor.
+	ImmutabilityMode string
+	// LegalHold places a legal hold on uploaded blobs.
+	LegalHold bool
 }
 
 // NewStorageBackend creates and initializes a new Azure Blob Storage backend.
@@ -102,10 +115,17 @@ func NewStorageBackend(opts Config, logFunc storage.Log) (storage.Backend, error
 		}
 	}
 
+	immutabilityMode, err := newImmutabilityMode(opts.ImmutabilityMode)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error setting up immutability policy")
+	}
+
 	storage := azureBlobStorage{
 		client:              client,
 		uploadStreamOptions: uploadStreamOptions,
 		containerName:       opts.ContainerName,
+		immutabilityMode:    immutabilityMode,
+		legalHold:           opts.LegalHold,
 		StorageBackend: &storage.StorageBackend{
 			DestinationPath: opts.RemotePath,
 			Log:             logFunc,
@@ -116,29 +136,88 @@ func NewStorageBackend(opts Config, logFunc storage.Log) (storage.Backend, error
 
 // Name returns the name of the storage backend
 func (b *azureBlobStorage) Name() string {
-	return "Azure"
+	return b.NameOr("Azure")
 }
 
-// Copy copies the given file to the storage backend.
+// Copy copies the given file to the storage backend. Each block is
+// validated by the server when received, and the MD5 digest of the file is
+// stored as the blob's Content-MD5 so the blob can be verified later on.
 func (b *azureBlobStorage) Copy(file string) error {
+	digest, err := storage.Digest(file, md5.New())
+	if err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error computing checksum of file %s", file))
+	}
+	var options blockblob.UploadStreamOptions
+	if b.uploadStreamOptions != nil {
+		options = *b.uploadStreamOptions
+	}
+	options.TransactionalValidation = blob.TransferValidationTypeComputeCRC64()
+	options.HTTPHeaders = &blob.HTTPHeaders{BlobContentMD5: digest}
+
 	fileReader, err := os.Open(file)
 	if err != nil {
 		return errwrap.Wrap(err, fmt.Sprintf("error opening file %s", file))
 	}
+	defer fileReader.Close()
 
+	name := path.Join(b.DestinationPath, filepath.Base(file))
 	_, err = b.client.UploadStream(
 		context.Background(),
 		b.containerName,
-		path.Join(b.DestinationPath, filepath.Base(file)),
-		fileReader,
-		b.uploadStreamOptions,
+		name,
+		b.Reader(fileReader),
+		&options,
 	)
 	if err != nil {
 		return errwrap.Wrap(err, fmt.Sprintf("error uploading file %s", file))
 	}
+	return b.protect(context.Background(), name)
+}
+
+// Verify compares the size and Content-MD5 of the blob of the given file
+// to the ones of the given file.
+func (b *azureBlobStorage) Verify(file string) (*storage.Verification, error) {
+	name := path.Join(b.DestinationPath, filepath.Base(file))
+	props, err := b.client.ServiceClient().NewContainerClient(b.containerName).NewBlobClient(name).GetProperties(context.Background(), nil)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error looking up stored blob")
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error reading local file")
+	}
+	var size int64
+	if props.ContentLength != nil {
+		size = *props.ContentLength
+	}
+	if _, err := storage.Compare(name, "size", strconv.FormatInt(info.Size(), 10), strconv.FormatInt(size, 10)); err != nil {
+		return nil, err
+	}
+	digest, err := storage.Digest(file, md5.New())
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error computing checksum of local file")
+	}
+	return storage.Compare(name, "MD5", base64.StdEncoding.EncodeToString(digest), base64.StdEncoding.EncodeToString(props.ContentMD5))
+}
+
+// Check verifies that the configured container can be accessed.
+func (b *azureBlobStorage) Check() error {
+	if _, err := b.client.ServiceClient().NewContainerClient(b.containerName).GetProperties(context.Background(), nil); err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error accessing container %s", b.containerName))
+	}
 	return nil
 }
 
+// Retryable returns whether the given error was caused by a transient
+// network issue or a server side error.
+func (b *azureBlobStorage) Retryable(err error) bool {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return storage.IsRetryableStatus(respErr.StatusCode)
+	}
+	return storage.IsTransientNetworkError(err)
+}
+
 // Prune rotates away backups according to the configuration and provided
 // deadline for the Azure Blob storage backend.
 func (b *azureBlobStorage) Prune(deadline time.Time, pruningPrefix string) (*storage.PruneStats, error) {
@@ -162,23 +241,33 @@ func (b *azureBlobStorage) Prune(deadline time.Time, pruningPrefix string) (*sto
 	}
 
 	stats := &storage.PruneStats{
-		Total:  totalCount,
-		Pruned: uint(len(matches)),
+		Total: totalCount,
 	}
 
-	pruneErr := b.DoPrune(b.Name(), len(matches), int(totalCount), deadline, func() error {
+	pruneErr := b.DoPrune(b.Name(), matches, stats, deadline, func() error {
 		wg := sync.WaitGroup{}
 		wg.Add(len(matches))
+		var mu sync.Mutex
 		var errs []error
 
 		for _, match := range matches {
 			name := match
 			go func() {
+				defer wg.Done()
 				_, err := b.client.DeleteBlob(context.Background(), b.containerName, name, nil)
+				mu.Lock()
+				defer mu.Unlock()
+				// Blobs protected by an immutability policy or a legal hold are
+				// skipped, they are pruned once they are not protected anymore.
+				if bloberror.HasCode(err, bloberror.BlobImmutableDueToPolicy) {
+					b.Log(storage.LogLevelInfo, b.Name(), "Skipping %s as it is protected by an immutability policy or legal hold.", name)
+					stats.Pruned--
+					stats.Locked++
+					return
+				}
 				if err != nil {
 					errs = append(errs, err)
 				}
-				wg.Done()
 			}()
 		}
 		wg.Wait()
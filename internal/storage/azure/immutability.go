@@ -0,0 +1,57 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+)
+
+// newImmutabilityMode validates the given immutability policy mode. An
+// empty mode disables setting an immutability policy on upload.
+func newImmutabilityMode(mode string) (*blob.ImmutabilityPolicySetting, error) {
+	if mode == "" {
+		return nil, nil
+	}
+	for _, m := range blob.PossibleImmutabilityPolicySettingValues() {
+		if strings.EqualFold(string(m), mode) {
+			return &m, nil
+		}
+	}
+	return nil, errwrap.Wrap(nil, fmt.Sprintf("unknown immutability policy mode %s, expected one of Unlocked or Locked", mode))
+}
+
+// RetainFor sets the duration uploaded blobs are immutable for in case an
+// immutability policy mode is configured.
+func (b *azureBlobStorage) RetainFor(d time.Duration) {
+	b.retention = d
+}
+
+// protect sets the configured immutability policy and legal hold on the
+// given blob. This requires version-level immutability support to be
+// enabled for the container.
+func (b *azureBlobStorage) protect(ctx context.Context, name string) error {
+	client := b.client.ServiceClient().NewContainerClient(b.containerName).NewBlobClient(name)
+	if b.immutabilityMode != nil {
+		if b.retention <= 0 {
+			return errwrap.Wrap(nil, "immutability policy requires a retention period to be configured")
+		}
+		if _, err := client.SetImmutabilityPolicy(ctx, time.Now().Add(b.retention), &blob.SetImmutabilityPolicyOptions{
+			Mode: b.immutabilityMode,
+		}); err != nil {
+			return errwrap.Wrap(err, fmt.Sprintf("error setting immutability policy on %s", name))
+		}
+	}
+	if b.legalHold {
+		if _, err := client.SetLegalHold(ctx, true, nil); err != nil {
+			return errwrap.Wrap(err, fmt.Sprintf("error setting legal hold on %s", name))
+		}
+	}
+	return nil
+}
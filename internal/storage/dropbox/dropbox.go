@@ -3,21 +3,32 @@ package dropbox
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"path"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/dropbox/dropbox-sdk-go-unofficial/v6/dropbox"
+	"github.com/dropbox/dropbox-sdk-go-unofficial/v6/dropbox/auth"
 	"github.com/dropbox/dropbox-sdk-go-unofficial/v6/dropbox/files"
 	"github.com/offen/docker-volume-backup/internal/errwrap"
 	"github.com/offen/docker-volume-backup/internal/storage"
 	"golang.org/x/oauth2"
 )
 
+// uploadState is the persisted state of a resumable upload session.
+type uploadState struct {
+	SessionID string
+	Completed []uint64
+}
+
 type dropboxStorage struct {
 	*storage.StorageBackend
 	client           files.Client
@@ -83,7 +94,7 @@ func NewStorageBackend(opts Config, logFunc storage.Log) (storage.Backend, error
 
 // Name returns the name of the storage backend
 func (b *dropboxStorage) Name() string {
-	return "Dropbox"
+	return b.NameOr("Dropbox")
 }
 
 // Copy copies the given file to the WebDav storage backend.
@@ -111,20 +122,47 @@ func (b *dropboxStorage) Copy(file string) (returnErr error) {
 		return
 	}
 	defer func() {
-		returnErr = r.Close()
+		returnErr = errors.Join(returnErr, r.Close())
 	}()
 
-	// Start new upload session and get session id
-	b.Log(storage.LogLevelInfo, b.Name(), "Starting upload session for backup '%s' at path '%s'.", file, b.DestinationPath)
+	// In case uploads are resumable, an existing session is continued by
+	// skipping all chunks that have already been appended.
+	var state uploadState
+	resumed, err := b.Sessions.Load(b.Name(), file, &state)
+	if err != nil {
+		returnErr = errwrap.Wrap(err, "error loading upload session")
+		return
+	}
+	defer func() {
+		// Sessions that Dropbox rejects cannot be resumed and are discarded.
+		var appendErr files.UploadSessionAppendV2APIError
+		var finishErr files.UploadSessionFinishAPIError
+		if errors.As(returnErr, &appendErr) || errors.As(returnErr, &finishErr) {
+			returnErr = errors.Join(returnErr, b.Sessions.Delete(b.Name(), file))
+		}
+	}()
 
 	var sessionId string
-	uploadSessionStartArg := files.NewUploadSessionStartArg()
-	uploadSessionStartArg.SessionType = &files.UploadSessionType{Tagged: dropbox.Tagged{Tag: files.UploadSessionTypeConcurrent}}
-	if res, err := b.client.UploadSessionStart(uploadSessionStartArg, nil); err != nil {
-		returnErr = errwrap.Wrap(err, "error starting the upload session")
-		return
+	if resumed {
+		sessionId = state.SessionID
+		b.Log(storage.LogLevelInfo, b.Name(), "Resuming upload session for backup '%s', %d chunk(s) have already been uploaded.", file, len(state.Completed))
 	} else {
-		sessionId = res.SessionId
+		// Start new upload session and get session id
+		b.Log(storage.LogLevelInfo, b.Name(), "Starting upload session for backup '%s' at path '%s'.", file, b.DestinationPath)
+
+		uploadSessionStartArg := files.NewUploadSessionStartArg()
+		uploadSessionStartArg.SessionType = &files.UploadSessionType{Tagged: dropbox.Tagged{Tag: files.UploadSessionTypeConcurrent}}
+		if res, err := b.client.UploadSessionStart(uploadSessionStartArg, nil); err != nil {
+			returnErr = errwrap.Wrap(err, "error starting the upload session")
+			return
+		} else {
+			sessionId = res.SessionId
+		}
+		state = uploadState{SessionID: sessionId}
+		if err := b.Sessions.Save(b.Name(), file, state); err != nil {
+			returnErr = errwrap.Wrap(err, "error saving upload session")
+			return
+		}
 	}
 
 	// Send the file in 148MB chunks (Dropbox API limit is 150MB, concurrent upload requires a multiple of 4MB though)
@@ -176,8 +214,9 @@ loop:
 			}
 			chunk = chunk[:bytesRead]
 
+			chunkOffset := offset
 			uploadSessionAppendArg := files.NewUploadSessionAppendArg(
-				files.NewUploadSessionCursor(sessionId, offset),
+				files.NewUploadSessionCursor(sessionId, chunkOffset),
 			)
 			isEOF := bytesRead < chunkSize
 			uploadSessionAppendArg.Close = isEOF
@@ -185,13 +224,25 @@ loop:
 				EOFChn <- true
 			}
 			offset += uint64(bytesRead)
+			completed := slices.Contains(state.Completed, chunkOffset)
 
 			mu.Unlock()
 
-			if err := b.client.UploadSessionAppendV2(uploadSessionAppendArg, bytes.NewReader(chunk)); err != nil {
+			if completed {
+				return
+			}
+
+			if err := b.client.UploadSessionAppendV2(uploadSessionAppendArg, b.Reader(bytes.NewReader(chunk))); err != nil {
 				errorChn <- errwrap.Wrap(err, "error appending the file to the upload session")
 				return
 			}
+
+			mu.Lock()
+			state.Completed = append(state.Completed, chunkOffset)
+			if err := b.Sessions.Save(b.Name(), file, state); err != nil {
+				b.Log(storage.LogLevelWarning, b.Name(), "Unable to save upload session: %v", err)
+			}
+			mu.Unlock()
 		}()
 	}
 
@@ -206,12 +257,66 @@ loop:
 		returnErr = errwrap.Wrap(err, "error finishing the upload session")
 		return
 	}
+	if err := b.Sessions.Delete(b.Name(), file); err != nil {
+		returnErr = errwrap.Wrap(err, "error deleting upload session")
+		return
+	}
 
 	b.Log(storage.LogLevelInfo, b.Name(), "Uploaded a copy of backup '%s' at path '%s'.", file, b.DestinationPath)
 
 	return nil
 }
 
+// ResumeUploads persists the state of uploads using the given sessions, so
+// that interrupted uploads can be resumed.
+func (b *dropboxStorage) ResumeUploads(sessions *storage.UploadSessions) {
+	b.Sessions = sessions
+}
+
+// Check verifies that the configured remote path can be listed.
+func (b *dropboxStorage) Check() error {
+	arg := files.NewListFolderArg(b.DestinationPath)
+	arg.Limit = 1
+	if _, err := b.client.ListFolder(arg); err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error listing %s", b.DestinationPath))
+	}
+	return nil
+}
+
+// contentHashBlockSize is the size of the blocks Dropbox computes the
+// content hash of a file over.
+const contentHashBlockSize = 4 * 1024 * 1024
+
+// Verify compares the content hash Dropbox reports for the stored copy of
+// the given file to the one of the given file.
+func (b *dropboxStorage) Verify(file string) (*storage.Verification, error) {
+	_, name := path.Split(file)
+	remotePath := path.Join(b.DestinationPath, name)
+	metadata, err := b.client.GetMetadata(files.NewGetMetadataArg(remotePath))
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error looking up stored file")
+	}
+	fileMetadata, ok := metadata.(*files.FileMetadata)
+	if !ok {
+		return nil, errwrap.Wrap(nil, fmt.Sprintf("%s is not a file", remotePath))
+	}
+	digest, _, err := storage.PartDigest(file, contentHashBlockSize, sha256.New)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error computing content hash of local file")
+	}
+	return storage.Compare(remotePath, "content hash", hex.EncodeToString(digest), fileMetadata.ContentHash)
+}
+
+// Retryable returns whether the given error was caused by a transient
+// network issue, rate limiting or a server side error.
+func (b *dropboxStorage) Retryable(err error) bool {
+	var rateLimitErr auth.RateLimitAPIError
+	var serverErr auth.ServerError
+	return errors.As(err, &rateLimitErr) ||
+		errors.As(err, &serverErr) ||
+		storage.IsTransientNetworkError(err)
+}
+
 // Prune rotates away backups according to the configuration and provided deadline for the Dropbox storage backend.
 func (b *dropboxStorage) Prune(deadline time.Time, pruningPrefix string) (*storage.PruneStats, error) {
 	var entries []files.IsMetadata
@@ -247,11 +352,10 @@ func (b *dropboxStorage) Prune(deadline time.Time, pruningPrefix string) (*stora
 	}
 
 	stats := &storage.PruneStats{
-		Total:  uint(lenCandidates),
-		Pruned: uint(len(matches)),
+		Total: uint(lenCandidates),
 	}
 
-	pruneErr := b.DoPrune(b.Name(), len(matches), lenCandidates, deadline, func() error {
+	pruneErr := b.DoPrune(b.Name(), storage.Names(matches, func(f *files.FileMetadata) string { return f.PathDisplay }), stats, deadline, func() error {
 		for _, match := range matches {
 			if _, err := b.client.DeleteV2(files.NewDeleteArg(path.Join(b.DestinationPath, match.Name))); err != nil {
 				return errwrap.Wrap(err, "error removing file from Dropbox storage")
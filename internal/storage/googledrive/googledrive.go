@@ -5,9 +5,13 @@ package googledrive
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,13 +21,18 @@ import (
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"net/http"
 )
 
 type googleDriveStorage struct {
 	storage.StorageBackend
-	client *drive.Service
+	client     *drive.Service
+	httpClient *http.Client
+	// fileIDs are the IDs of the files that have been created for each of
+	// the uploaded local files.
+	fileIDs map[string]string
 }
 
 // Config allows to configure a Google Drive storage backend.
@@ -62,7 +71,8 @@ func NewStorageBackend(opts Config, logFunc storage.Log) (storage.Backend, error
 		insecureClient := &http.Client{Transport: insecureTransport}
 		ctx = context.WithValue(ctx, oauth2.HTTPClient, insecureClient)
 	}
-	clientOptions = append(clientOptions, option.WithTokenSource(config.TokenSource(ctx)))
+	tokenSource := config.TokenSource(ctx)
+	clientOptions = append(clientOptions, option.WithTokenSource(tokenSource))
 
 	srv, err := drive.NewService(ctx, clientOptions...)
 	if err != nil {
@@ -74,13 +84,15 @@ func NewStorageBackend(opts Config, logFunc storage.Log) (storage.Backend, error
 			DestinationPath: opts.FolderID,
 			Log:             logFunc,
 		},
-		client: srv,
+		client:     srv,
+		httpClient: oauth2.NewClient(ctx, tokenSource),
+		fileIDs:    map[string]string{},
 	}, nil
 }
 
 // Name returns the name of the storage backend
 func (b *googleDriveStorage) Name() string {
-	return "GoogleDrive"
+	return b.NameOr("GoogleDrive")
 }
 
 // Copy copies the given file to the Google Drive storage backend.
@@ -94,7 +106,7 @@ func (b *googleDriveStorage) Copy(file string) (returnErr error) {
 		return
 	}
 	defer func() {
-		returnErr = f.Close()
+		returnErr = errors.Join(returnErr, f.Close())
 	}()
 
 	driveFile := &drive.File{Name: name}
@@ -104,17 +116,85 @@ func (b *googleDriveStorage) Copy(file string) (returnErr error) {
 		driveFile.Parents = []string{"root"}
 	}
 
+	if b.Sessions != nil {
+		id, err := b.copyResumable(f, driveFile)
+		if err != nil {
+			returnErr = errwrap.Wrap(err, fmt.Sprintf("failed to upload %s", name))
+			return
+		}
+		b.fileIDs[file] = id
+		b.Log(storage.LogLevelInfo, b.Name(), "Finished upload for %s. File ID: %s", name, id)
+		return nil
+	}
+
 	createCall := b.client.Files.Create(driveFile).SupportsAllDrives(true).Fields("id")
-	created, err := createCall.Media(f).Do()
+	created, err := createCall.Media(b.Reader(f)).Do()
 	if err != nil {
 		returnErr = errwrap.Wrap(err, fmt.Sprintf("failed to upload %s", name))
 		return
 	}
 
+	b.fileIDs[file] = created.Id
 	b.Log(storage.LogLevelInfo, b.Name(), "Finished upload for %s. File ID: %s", name, created.Id)
 	return nil
 }
 
+// Verify compares the size and MD5 checksum Google Drive reports for the
+// file created for the given file to the ones of the given file.
+func (b *googleDriveStorage) Verify(file string) (*storage.Verification, error) {
+	_, name := filepath.Split(file)
+	id, ok := b.fileIDs[file]
+	if !ok {
+		return nil, errwrap.Wrap(nil, fmt.Sprintf("%s has not been uploaded", name))
+	}
+	stored, err := b.client.Files.Get(id).SupportsAllDrives(true).Fields("md5Checksum", "size").Do()
+	if err != nil {
+		return nil, errwrap.Wrap(err, fmt.Sprintf("error looking up file %s", id))
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error reading local file")
+	}
+	if _, err := storage.Compare(name, "size", strconv.FormatInt(info.Size(), 10), strconv.FormatInt(stored.Size, 10)); err != nil {
+		return nil, err
+	}
+	digest, err := storage.Digest(file, md5.New())
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error computing checksum of local file")
+	}
+	return storage.Compare(name, "MD5", hex.EncodeToString(digest), stored.Md5Checksum)
+}
+
+// ResumeUploads persists the state of uploads using the given sessions, so
+// that interrupted uploads can be resumed.
+func (b *googleDriveStorage) ResumeUploads(sessions *storage.UploadSessions) {
+	b.Sessions = sessions
+}
+
+// Check verifies that the configured folder can be accessed.
+func (b *googleDriveStorage) Check() error {
+	if b.DestinationPath == "" {
+		if _, err := b.client.Files.List().PageSize(1).Do(); err != nil {
+			return errwrap.Wrap(err, "error listing files")
+		}
+		return nil
+	}
+	if _, err := b.client.Files.Get(b.DestinationPath).SupportsAllDrives(true).Fields("id").Do(); err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error accessing folder %s", b.DestinationPath))
+	}
+	return nil
+}
+
+// Retryable returns whether the given error was caused by a transient
+// network issue, rate limiting or a server side error.
+func (b *googleDriveStorage) Retryable(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return storage.IsRetryableStatus(apiErr.Code)
+	}
+	return storage.IsTransientNetworkError(err)
+}
+
 // Prune rotates away backups according to the configuration and provided deadline for the Google Drive storage backend.
 func (b *googleDriveStorage) Prune(deadline time.Time, pruningPrefix string) (*storage.PruneStats, error) {
 	parentID := b.DestinationPath
@@ -160,11 +240,10 @@ func (b *googleDriveStorage) Prune(deadline time.Time, pruningPrefix string) (*s
 	}
 
 	stats := &storage.PruneStats{
-		Total:  uint(lenCandidates),
-		Pruned: uint(len(matches)),
+		Total: uint(lenCandidates),
 	}
 
-	pruneErr := b.DoPrune(b.Name(), len(matches), lenCandidates, deadline, func() error {
+	pruneErr := b.DoPrune(b.Name(), storage.Names(matches, func(f *drive.File) string { return f.Name }), stats, deadline, func() error {
 		for _, file := range matches {
 			b.Log(storage.LogLevelInfo, b.Name(), "Deleting old backup file: %s", file.Name)
 			if err := b.client.Files.Delete(file.Id).SupportsAllDrives(true).Do(); err != nil {
@@ -0,0 +1,180 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package googledrive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"github.com/offen/docker-volume-backup/internal/storage"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// resumableState is the persisted state of a resumable upload session.
+type resumableState struct {
+	SessionURI string
+}
+
+// copyResumable uploads the given file using the resumable upload protocol
+// of Google Drive. The session URI is persisted, so that an interrupted
+// upload can be continued at the offset the server has confirmed.
+func (b *googleDriveStorage) copyResumable(f *os.File, driveFile *drive.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", errwrap.Wrap(err, "error reading the file to be uploaded")
+	}
+
+	var state resumableState
+	resumed, err := b.Sessions.Load(b.Name(), f.Name(), &state)
+	if err != nil {
+		return "", errwrap.Wrap(err, "error loading upload session")
+	}
+
+	var offset int64
+	if resumed {
+		var id string
+		id, offset, err = b.queryResumable(state.SessionURI, info.Size())
+		switch {
+		case err != nil:
+			b.Log(storage.LogLevelWarning, b.Name(), "Unable to resume upload of '%s', starting over: %v", driveFile.Name, err)
+			resumed = false
+		case id != "":
+			return id, b.Sessions.Delete(b.Name(), f.Name())
+		default:
+			b.Log(storage.LogLevelInfo, b.Name(), "Resuming upload of '%s' at offset %d.", driveFile.Name, offset)
+		}
+	}
+
+	if !resumed {
+		offset = 0
+		state.SessionURI, err = b.startResumable(driveFile, info.Size())
+		if err != nil {
+			return "", errwrap.Wrap(err, "error starting resumable upload")
+		}
+		if err := b.Sessions.Save(b.Name(), f.Name(), state); err != nil {
+			return "", errwrap.Wrap(err, "error saving upload session")
+		}
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return "", errwrap.Wrap(err, "error seeking in the file to be uploaded")
+	}
+	req, err := http.NewRequest(http.MethodPut, state.SessionURI, b.Reader(f))
+	if err != nil {
+		return "", errwrap.Wrap(err, "error creating request")
+	}
+	req.ContentLength = info.Size() - offset
+	if req.ContentLength > 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, info.Size()-1, info.Size()))
+	} else {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size()))
+	}
+	res, err := b.httpClient.Do(req)
+	if err != nil {
+		return "", errwrap.Wrap(err, "error uploading file")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		// The session has expired and the next attempt needs to start over.
+		return "", errors.Join(
+			errwrap.Wrap(googleapi.CheckResponse(res), "upload session has expired"),
+			b.Sessions.Delete(b.Name(), f.Name()),
+		)
+	}
+	if err := googleapi.CheckResponse(res); err != nil {
+		return "", errwrap.Wrap(err, "error uploading file")
+	}
+	var created drive.File
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+		return "", errwrap.Wrap(err, "error decoding response")
+	}
+	if err := b.Sessions.Delete(b.Name(), f.Name()); err != nil {
+		return "", errwrap.Wrap(err, "error deleting upload session")
+	}
+	return created.Id, nil
+}
+
+// startResumable initiates a resumable upload session for the given file
+// and returns the session URI.
+func (b *googleDriveStorage) startResumable(driveFile *drive.File, size int64) (string, error) {
+	body, err := json.Marshal(driveFile)
+	if err != nil {
+		return "", errwrap.Wrap(err, "error marshaling file metadata")
+	}
+	req, err := http.NewRequest(
+		http.MethodPost,
+		googleapi.ResolveRelative(b.client.BasePath, "/upload/drive/v3/files")+"?uploadType=resumable&supportsAllDrives=true&fields=id",
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return "", errwrap.Wrap(err, "error creating request")
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	res, err := b.httpClient.Do(req)
+	if err != nil {
+		return "", errwrap.Wrap(err, "error starting upload session")
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return "", errwrap.Wrap(err, "error starting upload session")
+	}
+	location := res.Header.Get("Location")
+	if location == "" {
+		return "", errwrap.Wrap(nil, "response did not contain a session URI")
+	}
+	return location, nil
+}
+
+// queryResumable asks the server about the state of the given upload
+// session. It returns the ID of the created file in case the upload has
+// already completed, or the offset at which the upload needs to continue.
+func (b *googleDriveStorage) queryResumable(sessionURI string, size int64) (string, int64, error) {
+	req, err := http.NewRequest(http.MethodPut, sessionURI, nil)
+	if err != nil {
+		return "", 0, errwrap.Wrap(err, "error creating request")
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	res, err := b.httpClient.Do(req)
+	if err != nil {
+		return "", 0, errwrap.Wrap(err, "error querying upload session")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusPermanentRedirect {
+		// The Range header contains the bytes that have been received, e.g.
+		// `bytes=0-1023`, or is missing in case nothing has been received yet.
+		received := res.Header.Get("Range")
+		if received == "" {
+			return "", 0, nil
+		}
+		_, last, ok := strings.Cut(strings.TrimPrefix(received, "bytes="), "-")
+		if !ok {
+			return "", 0, errwrap.Wrap(nil, fmt.Sprintf("unexpected range %s", received))
+		}
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return "", 0, errwrap.Wrap(err, fmt.Sprintf("unexpected range %s", received))
+		}
+		return "", n + 1, nil
+	}
+	if err := googleapi.CheckResponse(res); err != nil {
+		return "", 0, errwrap.Wrap(err, "error querying upload session")
+	}
+	var created drive.File
+	if err := json.NewDecoder(res.Body).Decode(&created); err != nil {
+		return "", 0, errwrap.Wrap(err, "error decoding response")
+	}
+	return created.Id, 0, nil
+}
@@ -4,6 +4,8 @@
 package local
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -40,14 +42,14 @@ func NewStorageBackend(opts Config, logFunc storage.Log) storage.Backend {
 
 // Name return the name of the storage backend
 func (b *localStorage) Name() string {
-	return "Local"
+	return b.NameOr("Local")
 }
 
 // Copy copies the given file to the local storage backend.
 func (b *localStorage) Copy(file string) error {
 	_, name := path.Split(file)
 
-	if err := copyFile(file, path.Join(b.DestinationPath, name)); err != nil {
+	if err := copyFile(file, path.Join(b.DestinationPath, name), b.Reader); err != nil {
 		return errwrap.Wrap(err, "error copying file to archive")
 	}
 	b.Log(storage.LogLevelInfo, b.Name(), "Stored copy of backup `%s` in `%s`.", file, b.DestinationPath)
@@ -68,6 +70,35 @@ func (b *localStorage) Copy(file string) error {
 	return nil
 }
 
+// Check verifies that the archive directory exists.
+func (b *localStorage) Check() error {
+	if _, err := os.Stat(b.DestinationPath); err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error accessing %s", b.DestinationPath))
+	}
+	return nil
+}
+
+// Verify re-reads the stored copy of the given file and compares its
+// checksum to the one of the given file.
+func (b *localStorage) Verify(file string) (*storage.Verification, error) {
+	_, name := path.Split(file)
+	expected, err := storage.Digest(file, sha256.New())
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error computing checksum of local file")
+	}
+	actual, err := storage.Digest(path.Join(b.DestinationPath, name), sha256.New())
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error computing checksum of stored file")
+	}
+	return storage.Compare(name, "SHA256", hex.EncodeToString(expected), hex.EncodeToString(actual))
+}
+
+// Retryable returns false as errors writing to the local filesystem are not
+// expected to be transient.
+func (b *localStorage) Retryable(err error) bool {
+	return false
+}
+
 // Prune rotates away backups according to the configuration and provided deadline for the local storage backend.
 func (b *localStorage) Prune(deadline time.Time, pruningPrefix string) (*storage.PruneStats, error) {
 	globPattern := path.Join(
@@ -121,11 +152,10 @@ func (b *localStorage) Prune(deadline time.Time, pruningPrefix string) (*storage
 	}
 
 	stats := &storage.PruneStats{
-		Total:  uint(len(candidates)),
-		Pruned: uint(len(matches)),
+		Total: uint(len(candidates)),
 	}
 
-	pruneErr := b.DoPrune(b.Name(), len(matches), len(candidates), deadline, func() error {
+	pruneErr := b.DoPrune(b.Name(), matches, stats, deadline, func() error {
 		var removeErrors []error
 		for _, match := range matches {
 			if err := os.Remove(match); err != nil {
@@ -148,7 +178,7 @@ func (b *localStorage) Prune(deadline time.Time, pruningPrefix string) (*storage
 }
 
 // copy creates a copy of the file located at `dst` at `src`.
-func copyFile(src, dst string) (returnErr error) {
+func copyFile(src, dst string, wrap func(io.Reader) io.Reader) (returnErr error) {
 	in, err := os.Open(src)
 	if err != nil {
 		returnErr = err
@@ -164,7 +194,7 @@ func copyFile(src, dst string) (returnErr error) {
 		return
 	}
 
-	_, err = io.Copy(out, in)
+	_, err = io.Copy(out, wrap(in))
 	if err != nil {
 		return errors.Join(err, out.Close())
 	}
@@ -0,0 +1,59 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package storage
+
+import (
+	"time"
+)
+
+// RetainableBackend is implemented by backends that can protect uploaded
+// files from being deleted or overwritten for a given duration, e.g. using
+// S3 Object Lock.
+type RetainableBackend interface {
+	RetainFor(d time.Duration)
+}
+
+// Lock describes why a stored file cannot be deleted.
+type Lock struct {
+	// Until is the time the file is retained until. It is zero in case the
+	// file is retained because of a legal hold only.
+	Until time.Time
+	// LegalHold is true in case the file is retained until the legal hold
+	// is removed.
+	LegalHold bool
+}
+
+// Active returns whether the lock prevents the file from being deleted at
+// the given time.
+func (l Lock) Active(now time.Time) bool {
+	return l.LegalHold || now.Before(l.Until)
+}
+
+func (l Lock) String() string {
+	switch {
+	case l.LegalHold && !l.Until.IsZero():
+		return "legal hold, retained until " + l.Until.Format(time.RFC3339)
+	case l.LegalHold:
+		return "legal hold"
+	default:
+		return "retained until " + l.Until.Format(time.RFC3339)
+	}
+}
+
+// SkipLocked removes all matches that are locked at the given time and logs
+// each of them. It returns the remaining matches and the number of skipped
+// ones.
+func (b *StorageBackend) SkipLocked(context string, matches []string, locks map[string]Lock, now time.Time) ([]string, uint) {
+	var unlocked []string
+	var skipped uint
+	for _, match := range matches {
+		if lock, ok := locks[match]; ok && lock.Active(now) {
+			b.Log(LogLevelInfo, context, "Skipping %s as it is locked (%s).", match, lock)
+			skipped++
+			continue
+		}
+		unlocked = append(unlocked, match)
+	}
+	return unlocked, skipped
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSkipLocked(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name            string
+		locks           map[string]Lock
+		expected        []string
+		expectedSkipped uint
+	}{
+		{"no locks", nil, []string{"a", "b", "c"}, 0},
+		{
+			"expired retention",
+			map[string]Lock{"a": {Until: now.Add(-time.Hour)}},
+			[]string{"a", "b", "c"},
+			0,
+		},
+		{
+			"active retention",
+			map[string]Lock{"a": {Until: now.Add(time.Hour)}},
+			[]string{"b", "c"},
+			1,
+		},
+		{
+			"legal hold",
+			map[string]Lock{"b": {LegalHold: true}, "c": {Until: now.Add(-time.Hour), LegalHold: true}},
+			[]string{"a"},
+			2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var messages int
+			b := &StorageBackend{
+				Log: func(LogLevel, string, string, ...any) {
+					messages++
+				},
+			}
+			result, skipped := b.SkipLocked("test", []string{"a", "b", "c"}, test.locks, now)
+			if !slices.Equal(result, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, result)
+			}
+			if skipped != test.expectedSkipped {
+				t.Errorf("Expected %d skipped, got %d", test.expectedSkipped, skipped)
+			}
+			if uint(messages) != skipped {
+				t.Errorf("Expected a message for each skipped match, got %d", messages)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package s3
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+)
+
+// newCredentials returns the credentials to use for accessing S3. Static
+// keys take precedence over the IAM role endpoint, web identity tokens and
+// shared credentials files. In case none of them are configured and default
+// credentials are enabled, the credentials are looked up in the
+// environment, the default shared credentials file and the IAM metadata
+// service, in this order. In case a role is configured, it is assumed using
+// the credentials found.
+func newCredentials(opts Config) (*credentials.Credentials, error) {
+	var creds *credentials.Credentials
+	switch {
+	case opts.AccessKeyID != "" && opts.SecretAccessKey != "":
+		creds = credentials.NewStaticV4(
+			opts.AccessKeyID,
+			opts.SecretAccessKey,
+			opts.SessionToken,
+		)
+	case opts.IamRoleEndpoint != "":
+		creds = credentials.NewIAM(opts.IamRoleEndpoint)
+	case opts.WebIdentityTokenFile != "":
+		if opts.RoleARN == "" {
+			return nil, errwrap.Wrap(nil, "AWS_WEB_IDENTITY_TOKEN_FILE requires AWS_ROLE_ARN to be set")
+		}
+		// The role is assumed using the token, so the result does not need
+		// to be wrapped in another AssumeRole call.
+		return credentials.NewSTSWebIdentity(
+			stsEndpoint(opts),
+			func() (*credentials.WebIdentityToken, error) {
+				token, err := os.ReadFile(opts.WebIdentityTokenFile)
+				if err != nil {
+					return nil, errwrap.Wrap(err, "error reading web identity token")
+				}
+				return &credentials.WebIdentityToken{Token: strings.TrimSpace(string(token))}, nil
+			},
+			func(i *credentials.STSWebIdentity) {
+				i.RoleARN = opts.RoleARN
+			},
+		)
+	case opts.SharedCredentialsFile != "" || opts.Profile != "":
+		creds = credentials.NewFileAWSCredentials(opts.SharedCredentialsFile, opts.Profile)
+	case !opts.DefaultCredentials:
+		return nil, errwrap.Wrap(nil, "AWS_S3_BUCKET_NAME is defined, but no credentials were provided")
+	default:
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		})
+	}
+
+	if opts.RoleARN == "" {
+		return creds, nil
+	}
+	return credentials.New(&assumeRole{
+		base:     creds,
+		endpoint: stsEndpoint(opts),
+		options: credentials.STSAssumeRoleOptions{
+			RoleARN:         opts.RoleARN,
+			RoleSessionName: opts.RoleSessionName,
+			ExternalID:      opts.RoleExternalID,
+			Location:        opts.STSRegion,
+		},
+	}), nil
+}
+
+// stsEndpoint returns the STS endpoint to use. Unless configured, the global
+// AWS STS endpoint is used for AWS, while other providers (e.g. MinIO) are
+// expected to serve STS requests on the S3 endpoint.
+func stsEndpoint(opts Config) string {
+	if opts.STSEndpoint != "" {
+		return opts.STSEndpoint
+	}
+	if strings.HasSuffix(opts.Endpoint, ".amazonaws.com") {
+		return credentials.DefaultSTSRoleEndpoint
+	}
+	return fmt.Sprintf("%s://%s", opts.EndpointProto, opts.Endpoint)
+}
+
+// assumeRole is a credentials provider that assumes a role using the
+// credentials of another provider, which might be temporary themselves.
+type assumeRole struct {
+	credentials.Expiry
+	base     *credentials.Credentials
+	endpoint string
+	options  credentials.STSAssumeRoleOptions
+}
+
+// RetrieveWithCredContext assumes the configured role.
+func (a *assumeRole) RetrieveWithCredContext(cc *credentials.CredContext) (credentials.Value, error) {
+	base, err := a.base.GetWithContext(cc)
+	if err != nil {
+		return credentials.Value{}, errwrap.Wrap(err, "error retrieving credentials for assuming role")
+	}
+	if base.AccessKeyID == "" || base.SecretAccessKey == "" {
+		return credentials.Value{}, errwrap.Wrap(nil, "no credentials found for assuming role")
+	}
+	options := a.options
+	options.AccessKey = base.AccessKeyID
+	options.SecretKey = base.SecretAccessKey
+	options.SessionToken = base.SessionToken
+	sts := &credentials.STSAssumeRole{STSEndpoint: a.endpoint, Options: options}
+	value, err := sts.RetrieveWithCredContext(cc)
+	if err != nil {
+		return credentials.Value{}, errwrap.Wrap(err, "error assuming role")
+	}
+	a.SetExpiration(value.Expiration, credentials.DefaultExpiryWindow)
+	return value, nil
+}
+
+// Retrieve assumes the configured role.
+func (a *assumeRole) Retrieve() (credentials.Value, error) {
+	return a.RetrieveWithCredContext(nil)
+}
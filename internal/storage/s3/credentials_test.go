@@ -0,0 +1,202 @@
+package s3
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newSTSServer returns a server that responds to AssumeRole and
+// AssumeRoleWithWebIdentity requests with the given access key in case the
+// request contains the expected form values.
+func newSTSServer(t *testing.T, accessKey string, expected map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for key, value := range expected {
+			if actual := r.PostForm.Get(key); actual != value {
+				t.Errorf("Expected %s to be %s, got %s", key, value, actual)
+			}
+		}
+		action := r.PostForm.Get("Action")
+		if action == "AssumeRole" && !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/sts/") {
+			t.Errorf("Expected request to be signed for STS, got %s", r.Header.Get("Authorization"))
+		}
+		fmt.Fprintf(
+			w,
+			`<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><%[1]sResult><Credentials><AccessKeyId>%[2]s</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration></Credentials></%[1]sResult></%[1]sResponse>`,
+			action, accessKey,
+		)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewCredentials(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("web-identity-token\n"), 0600); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	credentialsFile := filepath.Join(dir, "credentials")
+	if err := os.WriteFile(credentialsFile, []byte("[default]\naws_access_key_id = default\naws_secret_access_key = secret\n\n[backup]\naws_access_key_id = profile\naws_secret_access_key = secret\n"), 0600); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	tests := []struct {
+		name              string
+		env               map[string]string
+		opts              func(sts string) Config
+		expected          map[string]string
+		expectedAccessKey string
+		expectError       bool
+	}{
+		{
+			"static keys",
+			nil,
+			func(string) Config {
+				return Config{AccessKeyID: "static", SecretAccessKey: "secret", SessionToken: "token"}
+			},
+			nil,
+			"static",
+			false,
+		},
+		{
+			"shared credentials file with profile",
+			nil,
+			func(string) Config {
+				return Config{SharedCredentialsFile: credentialsFile, Profile: "backup"}
+			},
+			nil,
+			"profile",
+			false,
+		},
+		{
+			"assume role with external id",
+			nil,
+			func(sts string) Config {
+				return Config{
+					AccessKeyID: "static", SecretAccessKey: "secret",
+					RoleARN: "arn:aws:iam::123456789012:role/backup", RoleSessionName: "backup", RoleExternalID: "external",
+					STSEndpoint: sts, STSRegion: "us-east-1",
+				}
+			},
+			map[string]string{
+				"Action": "AssumeRole", "RoleArn": "arn:aws:iam::123456789012:role/backup",
+				"RoleSessionName": "backup", "ExternalId": "external",
+			},
+			"assumed",
+			false,
+		},
+		{
+			"assume role using shared credentials file",
+			nil,
+			func(sts string) Config {
+				return Config{
+					SharedCredentialsFile: credentialsFile,
+					RoleARN:               "arn:aws:iam::123456789012:role/backup",
+					STSEndpoint:           sts, STSRegion: "us-east-1",
+				}
+			},
+			map[string]string{"Action": "AssumeRole"},
+			"assumed",
+			false,
+		},
+		{
+			"web identity",
+			nil,
+			func(sts string) Config {
+				return Config{
+					WebIdentityTokenFile: tokenFile,
+					RoleARN:              "arn:aws:iam::123456789012:role/backup",
+					STSEndpoint:          sts,
+				}
+			},
+			map[string]string{
+				"Action": "AssumeRoleWithWebIdentity", "RoleArn": "arn:aws:iam::123456789012:role/backup",
+				"WebIdentityToken": "web-identity-token",
+			},
+			"assumed",
+			false,
+		},
+		{
+			"default credentials",
+			map[string]string{"AWS_ACCESS_KEY_ID": "environment", "AWS_SECRET_ACCESS_KEY": "secret"},
+			func(string) Config {
+				return Config{DefaultCredentials: true}
+			},
+			nil,
+			"environment",
+			false,
+		},
+		{
+			"default credentials disabled",
+			map[string]string{"AWS_ACCESS_KEY_ID": "environment", "AWS_SECRET_ACCESS_KEY": "secret"},
+			func(string) Config {
+				return Config{}
+			},
+			nil,
+			"",
+			true,
+		},
+		{
+			"web identity without role",
+			nil,
+			func(string) Config {
+				return Config{WebIdentityTokenFile: tokenFile}
+			},
+			nil,
+			"",
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			server := newSTSServer(t, "assumed", test.expected)
+			creds, err := newCredentials(test.opts(server.URL))
+			if (err != nil) != test.expectError {
+				t.Fatalf("Unexpected error value %v", err)
+			}
+			if err != nil {
+				return
+			}
+			value, err := creds.GetWithContext(nil)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if value.AccessKeyID != test.expectedAccessKey {
+				t.Errorf("Expected access key %s, got %s", test.expectedAccessKey, value.AccessKeyID)
+			}
+		})
+	}
+}
+
+func TestSTSEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Config
+		expected string
+	}{
+		{"aws", Config{Endpoint: "s3.amazonaws.com"}, "https://sts.amazonaws.com"},
+		{"aws region", Config{Endpoint: "s3.eu-central-1.amazonaws.com"}, "https://sts.amazonaws.com"},
+		{"minio", Config{Endpoint: "minio:9000", EndpointProto: "http"}, "http://minio:9000"},
+		{"configured", Config{Endpoint: "s3.amazonaws.com", STSEndpoint: "https://sts.eu-central-1.amazonaws.com"}, "https://sts.eu-central-1.amazonaws.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := stsEndpoint(test.opts); actual != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"github.com/offen/docker-volume-backup/internal/storage"
+)
+
+// newRetentionMode validates the given Object Lock mode. An empty mode
+// disables setting a retention period on upload.
+func newRetentionMode(mode string) (minio.RetentionMode, error) {
+	if mode == "" {
+		return "", nil
+	}
+	m := minio.RetentionMode(strings.ToUpper(mode))
+	if !m.IsValid() {
+		return "", errwrap.Wrap(nil, fmt.Sprintf("unknown object lock mode %s, expected one of GOVERNANCE or COMPLIANCE", mode))
+	}
+	return m, nil
+}
+
+// RetainFor sets the duration uploaded objects are locked for in case an
+// Object Lock mode is configured.
+func (b *s3Storage) RetainFor(d time.Duration) {
+	b.retention = d
+}
+
+// applyObjectLock requests the configured retention and legal hold for the
+// object that is created using the given options.
+func (b *s3Storage) applyObjectLock(opts *minio.PutObjectOptions) error {
+	if b.lockMode != "" {
+		if b.retention <= 0 {
+			return errwrap.Wrap(nil, "object lock requires a retention period to be configured")
+		}
+		opts.Mode = b.lockMode
+		opts.RetainUntilDate = time.Now().Add(b.retention).UTC()
+	}
+	if b.legalHold {
+		opts.LegalHold = minio.LegalHoldEnabled
+	}
+	return nil
+}
+
+// locks looks up the Object Lock retention and legal hold of the given
+// objects. Objects are only looked up in case locking uploads is configured
+// or Object Lock is enabled for the bucket.
+func (b *s3Storage) locks(ctx context.Context, keys []string) (map[string]storage.Lock, error) {
+	if b.lockMode == "" && !b.legalHold {
+		if enabled, _, _, _, err := b.client.GetObjectLockConfig(ctx, b.bucket); err != nil || enabled != "Enabled" {
+			return nil, nil
+		}
+	}
+	locks := map[string]storage.Lock{}
+	for _, key := range keys {
+		info, err := b.client.StatObject(ctx, b.bucket, key, minio.StatObjectOptions{ServerSideEncryption: b.customerKey()})
+		if err != nil {
+			return nil, errwrap.Wrap(err, fmt.Sprintf("error looking up object lock of %s", key))
+		}
+		var lock storage.Lock
+		if until := info.Metadata.Get("X-Amz-Object-Lock-Retain-Until-Date"); until != "" {
+			if lock.Until, err = time.Parse(time.RFC3339, until); err != nil {
+				return nil, errwrap.Wrap(err, fmt.Sprintf("error parsing retention of %s", key))
+			}
+		}
+		lock.LegalHold = info.Metadata.Get("X-Amz-Object-Lock-Legal-Hold") == string(minio.LegalHoldEnabled)
+		locks[key] = lock
+	}
+	return locks, nil
+}
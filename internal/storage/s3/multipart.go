@@ -0,0 +1,166 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"github.com/offen/docker-volume-backup/internal/storage"
+)
+
+// multipartState is the persisted state of a resumable multipart upload.
+type multipartState struct {
+	UploadID string
+	PartSize int64
+}
+
+// singlePartSize is the size up to which files are uploaded in a single
+// part in case no part size is configured, which is the minimum part size
+// used by the minio client.
+const singlePartSize = 16 * 1024 * 1024
+
+// resumable returns whether the given file is uploaded using a multipart
+// upload whose state is persisted. Files that fit into a single part are
+// uploaded using a single request instead, as there is nothing to resume.
+func (b *s3Storage) resumable(file string) (bool, error) {
+	if b.Sessions == nil {
+		return false, nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return false, errwrap.Wrap(err, "error reading the local file")
+	}
+	partSize := int64(singlePartSize)
+	if b.partSize > 0 {
+		partSize = b.partSize * 1024 * 1024
+	}
+	return info.Size() > partSize, nil
+}
+
+// copyResumable uploads the given file using a multipart upload whose state
+// is persisted, so that an interrupted upload can be resumed by skipping
+// all parts that have already been uploaded.
+func (b *s3Storage) copyResumable(file, key string, opts minio.PutObjectOptions) (returnErr error) {
+	ctx := context.Background()
+	core := minio.Core{Client: b.client}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return errwrap.Wrap(err, "error opening the file to be uploaded")
+	}
+	defer func() {
+		returnErr = errors.Join(returnErr, f.Close())
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return errwrap.Wrap(err, "error reading the local file")
+	}
+
+	var state multipartState
+	uploaded := map[int]minio.ObjectPart{}
+	resumed, err := b.Sessions.Load(b.Name(), file, &state)
+	if err != nil {
+		return errwrap.Wrap(err, "error loading upload session")
+	}
+	if resumed {
+		if uploaded, err = b.listParts(ctx, core, key, state.UploadID); err != nil {
+			b.Log(storage.LogLevelWarning, b.Name(), "Unable to resume upload of `%s`, starting over: %v", file, err)
+			resumed = false
+		} else {
+			b.Log(storage.LogLevelInfo, b.Name(), "Resuming upload of `%s`, %d part(s) have already been uploaded.", file, len(uploaded))
+		}
+	}
+
+	if !resumed {
+		b.abortStaleUploads(ctx, core)
+		_, partSize, _, err := minio.OptimalPartInfo(info.Size(), uint64(b.partSize*1024*1024))
+		if err != nil {
+			return errwrap.Wrap(err, "error computing the optimal s3 part size")
+		}
+		uploadID, err := core.NewMultipartUpload(ctx, b.bucket, key, opts)
+		if err != nil {
+			return errwrap.Wrap(err, "error starting multipart upload")
+		}
+		state = multipartState{UploadID: uploadID, PartSize: partSize}
+		uploaded = map[int]minio.ObjectPart{}
+		if err := b.Sessions.Save(b.Name(), file, state); err != nil {
+			return errwrap.Wrap(err, "error saving upload session")
+		}
+	}
+
+	var parts []minio.CompletePart
+	for number, offset := 1, int64(0); offset < info.Size(); number, offset = number+1, offset+state.PartSize {
+		size := min(state.PartSize, info.Size()-offset)
+		if part, ok := uploaded[number]; ok && part.Size == size {
+			parts = append(parts, minio.CompletePart{PartNumber: number, ETag: part.ETag})
+			continue
+		}
+		part, err := core.PutObjectPart(
+			ctx, b.bucket, key, state.UploadID, number,
+			b.Reader(io.NewSectionReader(f, offset, size)), size, minio.PutObjectPartOptions{SSE: b.customerKey()},
+		)
+		if err != nil {
+			return errwrap.Wrap(err, fmt.Sprintf("error uploading part %d", number))
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: number, ETag: part.ETag})
+	}
+
+	if _, err := core.CompleteMultipartUpload(ctx, b.bucket, key, state.UploadID, parts, opts); err != nil {
+		return errwrap.Wrap(err, "error completing multipart upload")
+	}
+	if err := b.Sessions.Delete(b.Name(), file); err != nil {
+		return errwrap.Wrap(err, "error deleting upload session")
+	}
+	return nil
+}
+
+// listParts returns all parts that have already been uploaded as part of
+// the given multipart upload.
+func (b *s3Storage) listParts(ctx context.Context, core minio.Core, key, uploadID string) (map[int]minio.ObjectPart, error) {
+	parts := map[int]minio.ObjectPart{}
+	var marker int
+	for {
+		result, err := core.ListObjectParts(ctx, b.bucket, key, uploadID, marker, 1000)
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error listing uploaded parts")
+		}
+		for _, part := range result.ObjectParts {
+			parts[part.PartNumber] = part
+		}
+		if !result.IsTruncated {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+// abortStaleUploads aborts all incomplete multipart uploads below the
+// destination path that have been started longer than the maximum age of
+// upload sessions ago. Failing to do so is not considered fatal.
+func (b *s3Storage) abortStaleUploads(ctx context.Context, core minio.Core) {
+	if b.Sessions.MaxAge <= 0 {
+		return
+	}
+	for upload := range b.client.ListIncompleteUploads(ctx, b.bucket, b.DestinationPath, true) {
+		if upload.Err != nil {
+			b.Log(storage.LogLevelWarning, b.Name(), "Unable to look up stale multipart uploads: %v", upload.Err)
+			return
+		}
+		if time.Since(upload.Initiated) <= b.Sessions.MaxAge {
+			continue
+		}
+		if err := core.AbortMultipartUpload(ctx, b.bucket, upload.Key, upload.UploadID); err != nil {
+			b.Log(storage.LogLevelWarning, b.Name(), "Unable to abort stale multipart upload of `%s`: %v", upload.Key, err)
+			continue
+		}
+		b.Log(storage.LogLevelInfo, b.Name(), "Aborted stale multipart upload of `%s` started at %s.", upload.Key, upload.Initiated.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,48 @@
+package s3
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/offen/docker-volume-backup/internal/storage"
+)
+
+func TestResumable(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions *storage.UploadSessions
+		partSize int64
+		size     int64
+		expected bool
+	}{
+		{"no sessions", nil, 5, 64 * 1024 * 1024, false},
+		{"empty file", &storage.UploadSessions{}, 5, 0, false},
+		{"single part", &storage.UploadSessions{}, 5, 5 * 1024 * 1024, false},
+		{"multiple parts", &storage.UploadSessions{}, 5, 5*1024*1024 + 1, true},
+		{"default part size", &storage.UploadSessions{}, 0, 16 * 1024 * 1024, false},
+		{"multiple default parts", &storage.UploadSessions{}, 0, 16*1024*1024 + 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "backup.tar.gz")
+			if err := os.WriteFile(file, nil, 0644); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if err := os.Truncate(file, test.size); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			b := &s3Storage{
+				StorageBackend: &storage.StorageBackend{Sessions: test.sessions},
+				partSize:       test.partSize,
+			}
+			resumable, err := b.resumable(file)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if resumable != test.expected {
+				t.Errorf("Expected %v, got %v", test.expected, resumable)
+			}
+		})
+	}
+}
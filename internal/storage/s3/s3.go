@@ -10,10 +10,13 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/minio/minio-go/v7/pkg/tags"
 	"github.com/offen/docker-volume-backup/internal/errwrap"
 	"github.com/offen/docker-volume-backup/internal/storage"
 )
@@ -24,6 +27,11 @@ type s3Storage struct {
 	bucket       string
 	storageClass string
 	partSize     int64
+	sse          encrypt.ServerSide
+	annotate     func(file string) (tags, metadata map[string]string, err error)
+	lockMode     minio.RetentionMode
+	legalHold    bool
+	retention    time.Duration
 }
 
 // Config contains values that define the configuration of a S3 backend.
@@ -32,6 +40,7 @@ type Config struct {
 	AccessKeyID      string
 	SecretAccessKey  string
 	IamRoleEndpoint  string
+	SessionToken     string
 	EndpointProto    string
 	EndpointInsecure bool
 	RemotePath       string
@@ -39,21 +48,48 @@ type Config struct {
 	StorageClass     string
 	PartSize         int64
 	CACert           *x509.Certificate
+	// RoleARN is the role that is assumed, either using the other
+	// credentials or using the web identity token.
+	RoleARN         string
+	RoleSessionName string
+	RoleExternalID  string
+	// WebIdentityTokenFile is the location of a token used for assuming
+	// RoleARN, e.g. a Kubernetes service account token.
+	WebIdentityTokenFile string
+	// STSEndpoint and STSRegion are used for assuming roles.
+	STSEndpoint string
+	STSRegion   string
+	// SharedCredentialsFile and Profile select the credentials from a
+	// shared credentials file.
+	SharedCredentialsFile string
+	Profile               string
+	// DefaultCredentials looks up credentials in the environment, the default
+	// shared credentials file and the IAM metadata service in case none of
+	// the above are configured.
+	DefaultCredentials bool
+	// ServerSideEncryption is one of `SSE-S3`, `SSE-KMS` or `SSE-C`. By
+	// default, the encryption configured for the bucket applies.
+	ServerSideEncryption string
+	// KMSKeyID is the key used for SSE-KMS. The AWS managed key is used in
+	// case it is not given.
+	KMSKeyID string
+	// CustomerKey is the 256 bit key used for SSE-C.
+	CustomerKey []byte
+	// Annotate returns the tags and user metadata to attach to the object
+	// that is created for the given file.
+	Annotate func(file string) (tags, metadata map[string]string, err error)
+	// ObjectLockMode is one of `GOVERNANCE` or `COMPLIANCE`. Uploaded
+	// objects are retained for the duration passed to RetainFor.
+	ObjectLockMode string
+	// LegalHold places a legal hold on uploaded objects.
+	LegalHold bool
 }
 
 // NewStorageBackend creates and initializes a new S3/Minio storage backend.
 func NewStorageBackend(opts Config, logFunc storage.Log) (storage.Backend, error) {
-	var creds *credentials.Credentials
-	if opts.AccessKeyID != "" && opts.SecretAccessKey != "" {
-		creds = credentials.NewStaticV4(
-			opts.AccessKeyID,
-			opts.SecretAccessKey,
-			"",
-		)
-	} else if opts.IamRoleEndpoint != "" {
-		creds = credentials.NewIAM(opts.IamRoleEndpoint)
-	} else {
-		return nil, errwrap.Wrap(nil, "AWS_S3_BUCKET_NAME is defined, but no credentials were provided")
+	creds, err := newCredentials(opts)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error setting up credentials")
 	}
 
 	options := minio.Options{
@@ -84,6 +120,16 @@ func NewStorageBackend(opts Config, logFunc storage.Log) (storage.Backend, error
 		return nil, errwrap.Wrap(err, "error setting up minio client")
 	}
 
+	sse, err := newServerSideEncryption(opts)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error setting up server side encryption")
+	}
+
+	lockMode, err := newRetentionMode(opts.ObjectLockMode)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error setting up object lock")
+	}
+
 	return &s3Storage{
 		StorageBackend: &storage.StorageBackend{
 			DestinationPath: opts.RemotePath,
@@ -93,20 +139,69 @@ func NewStorageBackend(opts Config, logFunc storage.Log) (storage.Backend, error
 		bucket:       opts.BucketName,
 		storageClass: opts.StorageClass,
 		partSize:     opts.PartSize,
+		sse:          sse,
+		annotate:     opts.Annotate,
+		lockMode:     lockMode,
+		legalHold:    opts.LegalHold,
 	}, nil
 }
 
+// customerKey returns the key that needs to be passed when reading objects
+// or uploading parts in case SSE-C is used.
+func (b *s3Storage) customerKey() encrypt.ServerSide {
+	if b.sse != nil && b.sse.Type() == encrypt.SSEC {
+		return b.sse
+	}
+	return nil
+}
+
+// newServerSideEncryption returns the server side encryption to request when
+// creating objects, or nil in case the default of the bucket applies.
+func newServerSideEncryption(opts Config) (encrypt.ServerSide, error) {
+	switch strings.ToUpper(opts.ServerSideEncryption) {
+	case "":
+		return nil, nil
+	case "SSE-S3":
+		return encrypt.NewSSE(), nil
+	case "SSE-KMS":
+		return encrypt.NewSSEKMS(opts.KMSKeyID, nil)
+	case "SSE-C":
+		if opts.EndpointProto != "https" {
+			return nil, errwrap.Wrap(nil, "SSE-C requires the endpoint to use https")
+		}
+		return encrypt.NewSSEC(opts.CustomerKey)
+	default:
+		return nil, errwrap.Wrap(nil, fmt.Sprintf("unknown server side encryption %s, expected one of SSE-S3, SSE-KMS or SSE-C", opts.ServerSideEncryption))
+	}
+}
+
 // Name returns the name of the storage backend
 func (v *s3Storage) Name() string {
-	return "S3"
+	return v.NameOr("S3")
 }
 
 // Copy copies the given file to the S3/Minio storage backend.
 func (b *s3Storage) Copy(file string) error {
 	_, name := path.Split(file)
 	putObjectOptions := minio.PutObjectOptions{
-		ContentType:  "application/tar+gzip",
-		StorageClass: b.storageClass,
+		ContentType:          "application/tar+gzip",
+		StorageClass:         b.storageClass,
+		Progress:             b.Progress(),
+		ServerSideEncryption: b.sse,
+	}
+	if err := b.applyObjectLock(&putObjectOptions); err != nil {
+		return err
+	}
+	if b.annotate != nil {
+		objectTags, metadata, err := b.annotate(file)
+		if err != nil {
+			return errwrap.Wrap(err, "error rendering object tags and metadata")
+		}
+		if _, err := tags.NewTags(objectTags, true); err != nil {
+			return errwrap.Wrap(err, "invalid object tags")
+		}
+		putObjectOptions.UserTags = objectTags
+		putObjectOptions.UserMetadata = metadata
 	}
 
 	if b.partSize > 0 {
@@ -115,21 +210,35 @@ func (b *s3Storage) Copy(file string) error {
 			return errwrap.Wrap(err, "error reading the local file")
 		}
 
-		_, partSize, _, err := minio.OptimalPartInfo(srcFileInfo.Size(), uint64(b.partSize*1024*1024))
-		if err != nil {
-			return errwrap.Wrap(err, "error computing the optimal s3 part size")
+		// Files that fit into a single part, e.g. signatures, are not
+		// uploaded in parts.
+		if srcFileInfo.Size() > b.partSize*1024*1024 {
+			_, partSize, _, err := minio.OptimalPartInfo(srcFileInfo.Size(), uint64(b.partSize*1024*1024))
+			if err != nil {
+				return errwrap.Wrap(err, "error computing the optimal s3 part size")
+			}
+			putObjectOptions.PartSize = uint64(partSize)
 		}
+	}
 
-		putObjectOptions.PartSize = uint64(partSize)
+	resumable, err := b.resumable(file)
+	if err != nil {
+		return err
+	}
+	if resumable {
+		if err := b.copyResumable(file, path.Join(b.DestinationPath, name), putObjectOptions); err != nil {
+			return errwrap.Wrap(err, "error uploading backup to remote storage")
+		}
+		b.Log(storage.LogLevelInfo, b.Name(), "Uploaded a copy of backup `%s` to bucket `%s`.", file, b.bucket)
+		return nil
 	}
 
 	if _, err := b.client.FPutObject(context.Background(), b.bucket, path.Join(b.DestinationPath, name), file, putObjectOptions); err != nil {
 		if errResp := minio.ToErrorResponse(err); errResp.Message != "" {
 			return errwrap.Wrap(
-				nil,
+				err,
 				fmt.Sprintf(
-					"error uploading backup to remote storage: [Message]: '%s', [Code]: %s, [StatusCode]: %d",
-					errResp.Message,
+					"error uploading backup to remote storage: [Code]: %s, [StatusCode]: %d, [Message]",
 					errResp.Code,
 					errResp.StatusCode,
 				),
@@ -143,6 +252,38 @@ func (b *s3Storage) Copy(file string) error {
 	return nil
 }
 
+// ResumeUploads persists the state of uploads using the given sessions, so
+// that interrupted uploads can be resumed.
+func (b *s3Storage) ResumeUploads(sessions *storage.UploadSessions) {
+	b.Sessions = sessions
+}
+
+// Check verifies that the configured bucket exists and can be accessed.
+func (b *s3Storage) Check() error {
+	exists, err := b.client.BucketExists(context.Background(), b.bucket)
+	if err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error accessing bucket %s", b.bucket))
+	}
+	if !exists {
+		return errwrap.Wrap(nil, fmt.Sprintf("bucket %s does not exist", b.bucket))
+	}
+	return nil
+}
+
+// Retryable returns whether the given error was caused by a transient
+// network issue or a server side error.
+func (b *s3Storage) Retryable(err error) bool {
+	var errResp minio.ErrorResponse
+	if errors.As(err, &errResp) {
+		switch errResp.Code {
+		case "SlowDown", "RequestTimeout", "InternalError", "ServiceUnavailable":
+			return true
+		}
+		return storage.IsRetryableStatus(errResp.StatusCode)
+	}
+	return storage.IsTransientNetworkError(err)
+}
+
 // Prune rotates away backups according to the configuration and provided deadline for the S3/Minio storage backend.
 func (b *s3Storage) Prune(deadline time.Time, pruningPrefix string) (*storage.PruneStats, error) {
 	candidates := b.client.ListObjects(context.Background(), b.bucket, minio.ListObjectsOptions{
@@ -165,12 +306,22 @@ func (b *s3Storage) Prune(deadline time.Time, pruningPrefix string) (*storage.Pr
 		}
 	}
 
+	names := storage.Names(matches, func(o minio.ObjectInfo) string { return o.Key })
+	locks, err := b.locks(context.Background(), names)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error looking up locked objects")
+	}
+	names, locked := b.SkipLocked(b.Name(), names, locks, time.Now())
+	matches = slices.DeleteFunc(matches, func(o minio.ObjectInfo) bool {
+		return !slices.Contains(names, o.Key)
+	})
+
 	stats := &storage.PruneStats{
 		Total:  uint(lenCandidates),
-		Pruned: uint(len(matches)),
+		Locked: locked,
 	}
 
-	pruneErr := b.DoPrune(b.Name(), len(matches), lenCandidates, deadline, func() error {
+	pruneErr := b.DoPrune(b.Name(), names, stats, deadline, func() error {
 		objectsCh := make(chan minio.ObjectInfo)
 		go func() {
 			for _, match := range matches {
@@ -0,0 +1,113 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"github.com/offen/docker-volume-backup/internal/storage"
+)
+
+// checksums are the additional checksums S3 might store for an object, in
+// order of preference. Values are base64 encoded.
+var checksums = []struct {
+	method  string
+	value   func(minio.ObjectInfo) string
+	newHash func() hash.Hash
+}{
+	{"SHA256", func(o minio.ObjectInfo) string { return o.ChecksumSHA256 }, sha256.New},
+	{"SHA1", func(o minio.ObjectInfo) string { return o.ChecksumSHA1 }, sha1.New},
+	{"CRC32C", func(o minio.ObjectInfo) string { return o.ChecksumCRC32C }, func() hash.Hash {
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	}},
+	{"CRC32", func(o minio.ObjectInfo) string { return o.ChecksumCRC32 }, func() hash.Hash {
+		return crc32.NewIEEE()
+	}},
+}
+
+var md5ETag = regexp.MustCompile(`^[0-9a-f]{32}(-[0-9]+)?$`)
+
+// Verify compares the checksum stored for the object of the given file to
+// the checksum of the given file. In case no additional checksum is
+// stored for the object, its ETag is used, which is a MD5 digest unless the
+// object is encrypted using SSE-C or SSE-KMS.
+func (b *s3Storage) Verify(file string) (*storage.Verification, error) {
+	ctx := context.Background()
+	_, name := path.Split(file)
+	key := path.Join(b.DestinationPath, name)
+
+	info, err := b.client.StatObject(ctx, b.bucket, key, minio.StatObjectOptions{Checksum: true, ServerSideEncryption: b.customerKey()})
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error looking up stored object")
+	}
+	localInfo, err := os.Stat(file)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error reading local file")
+	}
+	size, err := storage.Compare(key, "size", strconv.FormatInt(localInfo.Size(), 10), strconv.FormatInt(info.Size, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, checksum := range checksums {
+		if value := checksum.value(info); value != "" {
+			return b.verifyDigest(ctx, file, key, checksum.method, value, checksum.newHash, base64.StdEncoding.EncodeToString)
+		}
+	}
+
+	encryption := info.Metadata.Get("X-Amz-Server-Side-Encryption")
+	customerKey := info.Metadata.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm")
+	if md5ETag.MatchString(info.ETag) && !strings.HasPrefix(encryption, "aws:kms") && customerKey == "" {
+		return b.verifyDigest(ctx, file, key, "MD5", info.ETag, md5.New, hex.EncodeToString)
+	}
+
+	b.Log(
+		storage.LogLevelWarning, b.Name(),
+		"Object `%s` has no checksum that can be verified, only its size has been compared.", key,
+	)
+	return size, nil
+}
+
+// verifyDigest compares the given value to the digest of the given file.
+// Values of objects that have been created using a multipart upload have a
+// `-N` suffix and are the digest of the concatenated digests of all N parts.
+func (b *s3Storage) verifyDigest(
+	ctx context.Context, file, key, method, value string,
+	newHash func() hash.Hash, encode func([]byte) string,
+) (*storage.Verification, error) {
+	if _, _, multipart := strings.Cut(value, "-"); !multipart {
+		digest, err := storage.Digest(file, newHash())
+		if err != nil {
+			return nil, errwrap.Wrap(err, "error computing checksum of local file")
+		}
+		return storage.Compare(key, method, encode(digest), value)
+	}
+
+	// All parts but the last one have the same size, which is the size of the
+	// first part.
+	part, err := b.client.StatObject(ctx, b.bucket, key, minio.StatObjectOptions{PartNumber: 1, ServerSideEncryption: b.customerKey()})
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error looking up part size of stored object")
+	}
+	digest, parts, err := storage.PartDigest(file, part.Size, newHash)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error computing checksum of local file")
+	}
+	return storage.Compare(key, method, fmt.Sprintf("%s-%d", encode(digest), parts), value)
+}
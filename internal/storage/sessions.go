@@ -0,0 +1,165 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+)
+
+// UploadSessions persists the state of uploads that are in progress, so
+// that an interrupted upload can be resumed by a later attempt instead of
+// starting over. State is stored as one file per backend and uploaded file
+// in the given directory.
+type UploadSessions struct {
+	Directory string
+	MaxAge    time.Duration
+}
+
+// ResumableBackend is implemented by backends that are able to resume
+// interrupted uploads. Passing nil disables resuming uploads.
+type ResumableBackend interface {
+	ResumeUploads(sessions *UploadSessions)
+}
+
+type uploadSession struct {
+	File    string
+	Size    int64
+	ModTime time.Time
+	Created time.Time
+	State   json.RawMessage
+}
+
+// Load reads the state of a previous upload of the given file to the given
+// backend into v. It returns false in case no usable state exists, i.e. the
+// file has never been uploaded before, it has changed since, or the state
+// is older than MaxAge.
+func (u *UploadSessions) Load(backend, file string, v any) (bool, error) {
+	if u == nil {
+		return false, nil
+	}
+	b, err := os.ReadFile(u.location(backend, file))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errwrap.Wrap(err, "error reading upload session")
+	}
+
+	var session uploadSession
+	if err := json.Unmarshal(b, &session); err != nil {
+		return false, nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return false, errwrap.Wrap(err, fmt.Sprintf("error reading %s", file))
+	}
+	if session.Size != info.Size() || !session.ModTime.Equal(info.ModTime()) {
+		return false, nil
+	}
+	if u.MaxAge > 0 && time.Since(session.Created) > u.MaxAge {
+		return false, nil
+	}
+	if err := json.Unmarshal(session.State, v); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Save persists the given state of an upload of the given file to the given
+// backend, replacing any previous state. The time the upload was first
+// started is retained.
+func (u *UploadSessions) Save(backend, file string, v any) error {
+	if u == nil {
+		return nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error reading %s", file))
+	}
+	state, err := json.Marshal(v)
+	if err != nil {
+		return errwrap.Wrap(err, "error marshaling upload state")
+	}
+
+	session := uploadSession{
+		File:    filepath.Base(file),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Created: time.Now(),
+		State:   state,
+	}
+	if b, err := os.ReadFile(u.location(backend, file)); err == nil {
+		var previous uploadSession
+		if err := json.Unmarshal(b, &previous); err == nil && previous.Size == session.Size && previous.ModTime.Equal(session.ModTime) {
+			session.Created = previous.Created
+		}
+	}
+
+	b, err := json.Marshal(session)
+	if err != nil {
+		return errwrap.Wrap(err, "error marshaling upload session")
+	}
+	if err := os.MkdirAll(u.Directory, 0700); err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error creating %s", u.Directory))
+	}
+	tmp := fmt.Sprintf("%s.tmp", u.location(backend, file))
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return errwrap.Wrap(err, "error writing upload session")
+	}
+	if err := os.Rename(tmp, u.location(backend, file)); err != nil {
+		return errwrap.Wrap(err, "error writing upload session")
+	}
+	return nil
+}
+
+// Delete removes the state of an upload of the given file to the given
+// backend, e.g. after it has completed.
+func (u *UploadSessions) Delete(backend, file string) error {
+	if u == nil {
+		return nil
+	}
+	if err := os.Remove(u.location(backend, file)); err != nil && !os.IsNotExist(err) {
+		return errwrap.Wrap(err, "error removing upload session")
+	}
+	return nil
+}
+
+// Sweep removes the state of all uploads that have been started longer
+// than MaxAge ago.
+func (u *UploadSessions) Sweep() error {
+	if u == nil || u.MaxAge <= 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(filepath.Join(u.Directory, "*.json"))
+	if err != nil {
+		return errwrap.Wrap(err, "error listing upload sessions")
+	}
+	for _, match := range matches {
+		b, err := os.ReadFile(match)
+		if err != nil {
+			continue
+		}
+		var session uploadSession
+		if err := json.Unmarshal(b, &session); err == nil && time.Since(session.Created) <= u.MaxAge {
+			continue
+		}
+		if err := os.Remove(match); err != nil && !os.IsNotExist(err) {
+			return errwrap.Wrap(err, fmt.Sprintf("error removing stale upload session %s", match))
+		}
+	}
+	return nil
+}
+
+func (u *UploadSessions) location(backend, file string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s", backend, filepath.Base(file))))
+	return filepath.Join(u.Directory, fmt.Sprintf("%s.json", hex.EncodeToString(sum[:8])))
+}
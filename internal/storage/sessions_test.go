@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUploadSessions(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "backup.tar.gz")
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	sessions := &UploadSessions{Directory: filepath.Join(dir, "sessions"), MaxAge: time.Hour}
+	type state struct {
+		ID string
+	}
+
+	var loaded state
+	if ok, err := sessions.Load("S3", file, &loaded); err != nil || ok {
+		t.Fatalf("Expected no session, got %v %v", ok, err)
+	}
+
+	if err := sessions.Save("S3", file, state{ID: "abc"}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if ok, err := sessions.Load("S3", file, &loaded); err != nil || !ok || loaded.ID != "abc" {
+		t.Fatalf("Expected session to be loaded, got %v %v %v", ok, err, loaded)
+	}
+	if ok, _ := sessions.Load("SSH", file, &loaded); ok {
+		t.Error("Expected sessions of other backends not to be loaded")
+	}
+
+	if err := sessions.Sweep(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if ok, _ := sessions.Load("S3", file, &loaded); !ok {
+		t.Error("Expected session to survive sweeping")
+	}
+
+	if err := os.WriteFile(file, []byte("changed content"), 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if ok, _ := sessions.Load("S3", file, &loaded); ok {
+		t.Error("Expected session of changed file not to be loaded")
+	}
+
+	if err := sessions.Delete("S3", file); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := sessions.Delete("S3", file); err != nil {
+		t.Fatalf("Expected deleting twice to succeed, got %v", err)
+	}
+
+	var disabled *UploadSessions
+	if ok, err := disabled.Load("S3", file, &loaded); ok || err != nil {
+		t.Errorf("Expected disabled sessions to load nothing, got %v %v", ok, err)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// authMethods returns the methods used for authenticating against the remote
+// host, in the order they are tried: keys held by an agent, the identity file
+// (optionally using an OpenSSH user certificate) and a password.
+func authMethods(opts Config) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if opts.AgentSocket != "" {
+		conn, err := net.Dial("unix", opts.AgentSocket)
+		if err != nil {
+			return nil, errwrap.Wrap(err, fmt.Sprintf("error connecting to ssh-agent at %s", opts.AgentSocket))
+		}
+		// The connection is kept open for signing when reconnecting.
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	if _, err := os.Stat(opts.IdentityFile); err == nil {
+		signer, err := identitySigner(opts)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if opts.Password != "" {
+		methods = append(methods, ssh.Password(opts.Password))
+	}
+	return methods, nil
+}
+
+// identitySigner parses the identity file. In case a certificate is given,
+// or a certificate is found next to the identity file using a `-cert.pub`
+// suffix like OpenSSH does, the certificate is presented instead of the
+// plain public key.
+func identitySigner(opts Config) (ssh.Signer, error) {
+	key, err := os.ReadFile(opts.IdentityFile)
+	if err != nil {
+		return nil, errwrap.Wrap(nil, "error reading the private key")
+	}
+
+	var signer ssh.Signer
+	if opts.IdentityPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(opts.IdentityPassphrase))
+		if err != nil {
+			return nil, errwrap.Wrap(nil, "error parsing the encrypted private key")
+		}
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, errwrap.Wrap(nil, "error parsing the private key")
+		}
+	}
+
+	certificateFile := opts.CertificateFile
+	if certificateFile == "" {
+		if _, err := os.Stat(opts.IdentityFile + "-cert.pub"); err != nil {
+			return signer, nil
+		}
+		certificateFile = opts.IdentityFile + "-cert.pub"
+	}
+	b, err := os.ReadFile(certificateFile)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error reading the certificate")
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error parsing the certificate")
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errwrap.Wrap(nil, fmt.Sprintf("%s is not a certificate", certificateFile))
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error using the certificate")
+	}
+	return certSigner, nil
+}
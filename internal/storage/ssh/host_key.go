@@ -0,0 +1,166 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package ssh
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+	"github.com/offen/docker-volume-backup/internal/storage"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// hostKeyVerifier verifies the key presented by the remote host against
+// pinned fingerprints and/or a known_hosts file. In trust-on-first-use mode,
+// the key of a host that is not yet known is added to the known_hosts file.
+type hostKeyVerifier struct {
+	knownHostsFile  string
+	fingerprints    []string
+	trustOnFirstUse bool
+	logFunc         storage.Log
+	mu              sync.Mutex
+}
+
+func newHostKeyVerifier(opts Config, logFunc storage.Log) (*hostKeyVerifier, error) {
+	v := &hostKeyVerifier{
+		knownHostsFile:  opts.KnownHostsFile,
+		trustOnFirstUse: opts.HostKeyTrustOnFirstUse,
+		logFunc:         logFunc,
+	}
+	for _, fingerprint := range opts.HostKeyFingerprints {
+		if fingerprint = strings.TrimSpace(fingerprint); fingerprint == "" {
+			continue
+		}
+		if !strings.HasPrefix(fingerprint, "SHA256:") && !strings.HasPrefix(fingerprint, "MD5:") {
+			fingerprint = "SHA256:" + fingerprint
+		}
+		v.fingerprints = append(v.fingerprints, fingerprint)
+	}
+
+	if v.trustOnFirstUse && v.knownHostsFile == "" {
+		return nil, errwrap.Wrap(nil, "trusting host keys on first use requires a known hosts file to be given")
+	}
+	if v.knownHostsFile != "" {
+		if _, err := os.Stat(v.knownHostsFile); err != nil {
+			if !os.IsNotExist(err) || !v.trustOnFirstUse {
+				return nil, errwrap.Wrap(err, fmt.Sprintf("error reading known hosts file %s", v.knownHostsFile))
+			}
+			if err := os.MkdirAll(path.Dir(v.knownHostsFile), 0700); err != nil {
+				return nil, errwrap.Wrap(err, "error creating directory for known hosts file")
+			}
+			if err := os.WriteFile(v.knownHostsFile, nil, 0600); err != nil {
+				return nil, errwrap.Wrap(err, "error creating known hosts file")
+			}
+		}
+	}
+	return v, nil
+}
+
+// enabled returns whether host keys are verified at all.
+func (v *hostKeyVerifier) enabled() bool {
+	return v.knownHostsFile != "" || len(v.fingerprints) != 0
+}
+
+// callback returns the ssh.HostKeyCallback to be used when connecting.
+// Pinned fingerprints only apply to the SSH server itself, so jump hosts are
+// verified using the known hosts file only.
+func (v *hostKeyVerifier) callback(jumpHost bool) ssh.HostKeyCallback {
+	fingerprints := v.fingerprints
+	if jumpHost {
+		fingerprints = nil
+	}
+	if len(fingerprints) == 0 && v.knownHostsFile == "" {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if len(fingerprints) != 0 && !slices.Contains(fingerprints, ssh.FingerprintSHA256(key)) &&
+			!slices.Contains(fingerprints, "MD5:"+ssh.FingerprintLegacyMD5(key)) {
+			return &storage.HostKeyMismatchError{Host: hostname, Fingerprint: ssh.FingerprintSHA256(key)}
+		}
+		if v.knownHostsFile == "" {
+			return nil
+		}
+		return v.checkKnownHosts(hostname, remote, key)
+	}
+}
+
+func (v *hostKeyVerifier) checkKnownHosts(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// The file is read on each connection so keys that have been added on
+	// first use are known when reconnecting.
+	check, err := knownhosts.New(v.knownHostsFile)
+	if err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error reading known hosts file %s", v.knownHostsFile))
+	}
+	err = check(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return err
+	}
+	if len(keyErr.Want) != 0 {
+		return &storage.HostKeyMismatchError{Host: hostname, Fingerprint: ssh.FingerprintSHA256(key)}
+	}
+	if !v.trustOnFirstUse {
+		return errwrap.Wrap(nil, fmt.Sprintf("host %s is not present in known hosts file %s", hostname, v.knownHostsFile))
+	}
+
+	f, err := os.OpenFile(v.knownHostsFile, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return errwrap.Wrap(err, "error opening known hosts file")
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)); err != nil {
+		return errwrap.Wrap(err, "error adding host key to known hosts file")
+	}
+	v.logFunc(
+		storage.LogLevelWarning, "SSH",
+		"Trusting %s key %s of previously unknown host %s on first use, adding it to %s.",
+		key.Type(), ssh.FingerprintSHA256(key), hostname, v.knownHostsFile,
+	)
+	return nil
+}
+
+// hostKeyAlgorithms returns the algorithms of the keys known for the given
+// host, so the host presents one of these keys instead of the one it
+// prefers. In case no keys are known, nil is returned, using the defaults.
+func (v *hostKeyVerifier) hostKeyAlgorithms(addr string) []string {
+	if v.knownHostsFile == "" {
+		return nil
+	}
+	check, err := knownhosts.New(v.knownHostsFile)
+	if err != nil {
+		return nil
+	}
+	// Checking a key that is never known returns all keys known for the host.
+	_, probe, _ := ed25519.GenerateKey(nil)
+	signer, err := ssh.NewSignerFromKey(probe)
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if err := check(addr, &net.TCPAddr{}, signer.PublicKey()); !errors.As(err, &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		switch keyType := known.Key.Type(); keyType {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, keyType)
+		}
+	}
+	return algorithms
+}
@@ -0,0 +1,144 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/offen/docker-volume-backup/internal/storage"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestHostKeyVerifier(t *testing.T) {
+	hostKey := newPublicKey(t)
+	otherKey := newPublicKey(t)
+	const host = "server.local:2222"
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 2222}
+
+	tests := []struct {
+		name             string
+		opts             func(knownHosts string) Config
+		knownHosts       []ssh.PublicKey
+		key              ssh.PublicKey
+		expectMismatch   bool
+		expectError      bool
+		expectKnownHosts int
+	}{
+		{
+			"disabled",
+			func(string) Config { return Config{} },
+			nil, otherKey, false, false, 0,
+		},
+		{
+			"matching fingerprint",
+			func(string) Config {
+				return Config{HostKeyFingerprints: []string{ssh.FingerprintSHA256(otherKey), ssh.FingerprintSHA256(hostKey)}}
+			},
+			nil, hostKey, false, false, 0,
+		},
+		{
+			"fingerprint without prefix",
+			func(string) Config {
+				return Config{HostKeyFingerprints: []string{ssh.FingerprintSHA256(hostKey)[len("SHA256:"):]}}
+			},
+			nil, hostKey, false, false, 0,
+		},
+		{
+			"fingerprint mismatch",
+			func(string) Config { return Config{HostKeyFingerprints: []string{ssh.FingerprintSHA256(hostKey)}} },
+			nil, otherKey, true, true, 0,
+		},
+		{
+			"known host",
+			func(f string) Config { return Config{KnownHostsFile: f} },
+			[]ssh.PublicKey{hostKey}, hostKey, false, false, 1,
+		},
+		{
+			"known host mismatch",
+			func(f string) Config { return Config{KnownHostsFile: f} },
+			[]ssh.PublicKey{hostKey}, otherKey, true, true, 1,
+		},
+		{
+			"unknown host",
+			func(f string) Config { return Config{KnownHostsFile: f} },
+			[]ssh.PublicKey{}, hostKey, false, true, 0,
+		},
+		{
+			"trust on first use",
+			func(f string) Config { return Config{KnownHostsFile: f, HostKeyTrustOnFirstUse: true} },
+			nil, hostKey, false, false, 1,
+		},
+		{
+			"trust on first use mismatch",
+			func(f string) Config { return Config{KnownHostsFile: f, HostKeyTrustOnFirstUse: true} },
+			[]ssh.PublicKey{hostKey}, otherKey, true, true, 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			knownHosts := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+			if test.knownHosts != nil {
+				if err := os.MkdirAll(filepath.Dir(knownHosts), 0700); err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				var lines string
+				for _, key := range test.knownHosts {
+					lines += knownhosts.Line([]string{knownhosts.Normalize(host)}, key) + "\n"
+				}
+				if err := os.WriteFile(knownHosts, []byte(lines), 0600); err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+			}
+
+			v, err := newHostKeyVerifier(test.opts(knownHosts), func(storage.LogLevel, string, string, ...any) {})
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			err = v.callback(false)(host, remote, test.key)
+			if test.expectError != (err != nil) {
+				t.Fatalf("Unexpected error value %v", err)
+			}
+			var mismatch *storage.HostKeyMismatchError
+			if test.expectMismatch != errors.As(err, &mismatch) {
+				t.Errorf("Expected mismatch to be %v, got %v", test.expectMismatch, err)
+			}
+
+			if test.expectKnownHosts != 0 {
+				b, err := os.ReadFile(knownHosts)
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				if lines := strings.Count(string(b), "\n"); lines != test.expectKnownHosts {
+					t.Errorf("Expected %d known hosts, got %d", test.expectKnownHosts, lines)
+				}
+				// Connecting again succeeds, e.g. after trusting on first use.
+				if err := v.callback(false)(host, remote, hostKey); err != nil {
+					t.Errorf("Unexpected error reconnecting %v", err)
+				}
+				if algorithms := v.hostKeyAlgorithms(host); !slices.Equal(algorithms, []string{ssh.KeyAlgoED25519}) {
+					t.Errorf("Unexpected host key algorithms %v", algorithms)
+				}
+			}
+		})
+	}
+}
+
+func newPublicKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return key
+}
@@ -4,10 +4,16 @@
 package ssh
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,9 +25,15 @@ import (
 
 type sshStorage struct {
 	*storage.StorageBackend
-	client     *ssh.Client
-	sftpClient *sftp.Client
-	hostName   string
+	client      *ssh.Client
+	jumpClients []*ssh.Client
+	sftpClient  *sftp.Client
+	hostName    string
+	addr        string
+	user        string
+	auth        []ssh.AuthMethod
+	verifier    *hostKeyVerifier
+	jumpHosts   []jumpHost
 }
 
 // Config allows to configure a SSH backend.
@@ -33,51 +45,156 @@ type Config struct {
 	IdentityFile       string
 	IdentityPassphrase string
 	RemotePath         string
+	// KnownHostsFile is a file in the known_hosts format the key of the
+	// remote host is verified against.
+	KnownHostsFile string
+	// HostKeyFingerprints pins the key of the remote host to the given
+	// SHA256 fingerprints.
+	HostKeyFingerprints []string
+	// HostKeyTrustOnFirstUse adds the key of a host not yet present in
+	// KnownHostsFile instead of failing.
+	HostKeyTrustOnFirstUse bool
+	// AgentSocket is the path of the socket of a ssh-agent holding keys
+	// used for authentication.
+	AgentSocket string
+	// CertificateFile is an OpenSSH user certificate for the key in
+	// IdentityFile. It defaults to IdentityFile with a `-cert.pub` suffix
+	// in case such a file exists.
+	CertificateFile string
+	// ProxyJump is a comma separated list of `[user@]host[:port]` jump
+	// hosts the connection is tunneled through.
+	ProxyJump string
 }
 
 // NewStorageBackend creates and initializes a new SSH storage backend.
 func NewStorageBackend(opts Config, logFunc storage.Log) (storage.Backend, error) {
-	var authMethods []ssh.AuthMethod
+	authMethods, err := authMethods(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := newHostKeyVerifier(opts, logFunc)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error setting up host key verification")
+	}
+	if !verifier.enabled() {
+		logFunc(
+			storage.LogLevelWarning, "SSH",
+			"The key of host %s is not verified, configure a known hosts file or a host key fingerprint to protect against man-in-the-middle attacks.",
+			opts.HostName,
+		)
+	}
 
-	if opts.Password != "" {
-		authMethods = append(authMethods, ssh.Password(opts.Password))
+	jumpHosts, err := parseProxyJump(opts.ProxyJump, opts.User)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error parsing jump hosts")
 	}
+	if len(jumpHosts) != 0 && verifier.enabled() && opts.KnownHostsFile == "" {
+		logFunc(
+			storage.LogLevelWarning, "SSH",
+			"The keys of jump hosts are not verified as pinned fingerprints only apply to host %s, configure a known hosts file to verify them.",
+			opts.HostName,
+		)
+	}
+
+	b := &sshStorage{
+		StorageBackend: &storage.StorageBackend{
+			DestinationPath: opts.RemotePath,
+			Log:             logFunc,
+		},
+		hostName:  opts.HostName,
+		addr:      net.JoinHostPort(opts.HostName, opts.Port),
+		user:      opts.User,
+		auth:      authMethods,
+		verifier:  verifier,
+		jumpHosts: jumpHosts,
+	}
+	if err := b.connect(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// jumpHost is a host the connection to the remote host is tunneled through.
+type jumpHost struct {
+	user string
+	addr string
+}
 
-	if _, err := os.Stat(opts.IdentityFile); err == nil {
-		key, err := os.ReadFile(opts.IdentityFile)
+// parseProxyJump parses a comma separated list of jump hosts in the
+// `[user@]host[:port]` format used by OpenSSH's ProxyJump option. Hosts
+// are connected to in the given order.
+func parseProxyJump(value, defaultUser string) ([]jumpHost, error) {
+	var hosts []jumpHost
+	for _, hop := range strings.Split(value, ",") {
+		if hop = strings.TrimSpace(hop); hop == "" {
+			continue
+		}
+		host := jumpHost{user: defaultUser}
+		if idx := strings.LastIndex(hop, "@"); idx != -1 {
+			host.user, hop = hop[:idx], hop[idx+1:]
+		}
+		hostName, port, err := net.SplitHostPort(hop)
 		if err != nil {
-			return nil, errwrap.Wrap(nil, "error reading the private key")
+			hostName, port = strings.Trim(hop, "[]"), "22"
+		}
+		if hostName == "" || host.user == "" {
+			return nil, errwrap.Wrap(nil, fmt.Sprintf("invalid jump host %q", hop))
 		}
+		host.addr = net.JoinHostPort(hostName, port)
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
 
-		var signer ssh.Signer
-		if opts.IdentityPassphrase != "" {
-			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(opts.IdentityPassphrase))
-			if err != nil {
-				return nil, errwrap.Wrap(nil, "error parsing the encrypted private key")
-			}
-			authMethods = append(authMethods, ssh.PublicKeys(signer))
-		} else {
-			signer, err = ssh.ParsePrivateKey(key)
+// connect establishes the connection to the remote host, tunneling it
+// through the configured jump hosts.
+func (b *sshStorage) connect() error {
+	var sshClient *ssh.Client
+	var jumpClients []*ssh.Client
+	closeJumpClients := func() {
+		for i := len(jumpClients) - 1; i >= 0; i-- {
+			_ = jumpClients[i].Close()
+		}
+	}
+
+	hops := append(slices.Clone(b.jumpHosts), jumpHost{user: b.user, addr: b.addr})
+	for i, hop := range hops {
+		clientConfig := &ssh.ClientConfig{
+			User:              hop.user,
+			Auth:              b.auth,
+			HostKeyCallback:   b.verifier.callback(i < len(b.jumpHosts)),
+			HostKeyAlgorithms: b.verifier.hostKeyAlgorithms(hop.addr),
+		}
+		if sshClient == nil {
+			client, err := ssh.Dial("tcp", hop.addr, clientConfig)
 			if err != nil {
-				return nil, errwrap.Wrap(nil, "error parsing the private key")
+				return errwrap.Wrap(err, fmt.Sprintf("error connecting to %s", hop.addr))
 			}
-			authMethods = append(authMethods, ssh.PublicKeys(signer))
+			sshClient = client
+			continue
 		}
-	}
 
-	sshClientConfig := &ssh.ClientConfig{
-		User:            opts.User,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		jumpClients = append(jumpClients, sshClient)
+		conn, err := sshClient.Dial("tcp", hop.addr)
+		if err != nil {
+			closeJumpClients()
+			return errwrap.Wrap(err, fmt.Sprintf("error connecting to %s through jump host", hop.addr))
+		}
+		c, chans, reqs, err := ssh.NewClientConn(conn, hop.addr, clientConfig)
+		if err != nil {
+			_ = conn.Close()
+			closeJumpClients()
+			return errwrap.Wrap(err, fmt.Sprintf("error connecting to %s through jump host", hop.addr))
+		}
+		sshClient = ssh.NewClient(c, chans, reqs)
 	}
-	sshClient, err := ssh.Dial("tcp", fmt.Sprintf("%s:%s", opts.HostName, opts.Port), sshClientConfig)
 
+	_, _, err := sshClient.SendRequest("keepalive", false, nil)
 	if err != nil {
-		return nil, errwrap.Wrap(err, "error creating ssh client")
-	}
-	_, _, err = sshClient.SendRequest("keepalive", false, nil)
-	if err != nil {
-		return nil, err
+		_ = sshClient.Close()
+		closeJumpClients()
+		return err
 	}
 
 	sftpClient, err := sftp.NewClient(sshClient,
@@ -86,27 +203,122 @@ func NewStorageBackend(opts Config, logFunc storage.Log) (storage.Backend, error
 		sftp.MaxConcurrentRequestsPerFile(64),
 	)
 	if err != nil {
-		return nil, errwrap.Wrap(err, "error creating sftp client")
+		_ = sshClient.Close()
+		closeJumpClients()
+		return errwrap.Wrap(err, "error creating sftp client")
 	}
 
-	return &sshStorage{
-		StorageBackend: &storage.StorageBackend{
-			DestinationPath: opts.RemotePath,
-			Log:             logFunc,
-		},
-		client:     sshClient,
-		sftpClient: sftpClient,
-		hostName:   opts.HostName,
-	}, nil
+	b.client = sshClient
+	b.jumpClients = jumpClients
+	b.sftpClient = sftpClient
+	return nil
+}
+
+// close closes the connection to the remote host and all jump hosts.
+func (b *sshStorage) close() {
+	_ = b.sftpClient.Close()
+	_ = b.client.Close()
+	for i := len(b.jumpClients) - 1; i >= 0; i-- {
+		_ = b.jumpClients[i].Close()
+	}
+}
+
+// reconnect establishes a new connection in case the existing one has been
+// dropped, e.g. before retrying a failed operation.
+func (b *sshStorage) reconnect() error {
+	if _, _, err := b.client.SendRequest("keepalive", true, nil); err == nil {
+		return nil
+	}
+	b.Log(storage.LogLevelWarning, b.Name(), "Connection to '%s' was lost, reconnecting.", b.hostName)
+	b.close()
+	return b.connect()
+}
+
+// ResumeUploads persists the state of uploads using the given sessions, so
+// that interrupted uploads can be resumed.
+func (b *sshStorage) ResumeUploads(sessions *storage.UploadSessions) {
+	b.Sessions = sessions
+}
+
+// Check verifies that the remote path exists.
+func (b *sshStorage) Check() error {
+	if err := b.reconnect(); err != nil {
+		return errwrap.Wrap(err, "error reconnecting")
+	}
+	if _, err := b.sftpClient.Stat(b.DestinationPath); err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error accessing %s", b.DestinationPath))
+	}
+	return nil
+}
+
+// Verify compares the size of the stored copy of the given file to the one
+// of the given file, and reads it back for comparing their checksums.
+func (b *sshStorage) Verify(file string) (verification *storage.Verification, returnErr error) {
+	if err := b.reconnect(); err != nil {
+		return nil, errwrap.Wrap(err, "error reconnecting")
+	}
+	_, name := path.Split(file)
+	localInfo, err := os.Stat(file)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error reading local file")
+	}
+	remote, err := b.sftpClient.Open(path.Join(b.DestinationPath, name))
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error opening stored file")
+	}
+	defer func() {
+		returnErr = errors.Join(returnErr, remote.Close())
+	}()
+	remoteInfo, err := remote.Stat()
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error reading stored file")
+	}
+	if _, err := storage.Compare(name, "size", strconv.FormatInt(localInfo.Size(), 10), strconv.FormatInt(remoteInfo.Size(), 10)); err != nil {
+		return nil, err
+	}
+
+	expected, err := storage.Digest(file, sha256.New())
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error computing checksum of local file")
+	}
+	h := sha256.New()
+	if _, err := remote.WriteTo(h); err != nil {
+		return nil, errwrap.Wrap(err, "error reading stored file")
+	}
+	return storage.Compare(name, "SHA256", hex.EncodeToString(expected), hex.EncodeToString(h.Sum(nil)))
+}
+
+// Retryable returns whether the given error was caused by a transient
+// network issue or a dropped connection.
+func (b *sshStorage) Retryable(err error) bool {
+	return errors.Is(err, sftp.ErrSSHFxConnectionLost) ||
+		errors.Is(err, io.EOF) ||
+		storage.IsTransientNetworkError(err)
 }
 
 // Name returns the name of the storage backend
 func (b *sshStorage) Name() string {
-	return "SSH"
+	return b.NameOr("SSH")
+}
+
+// partialSuffix is appended to the names of files that are still being
+// uploaded. Files are only renamed to their final name once complete.
+const partialSuffix = ".partial"
+
+// partialState is the persisted state of a resumable upload.
+type partialState struct {
+	Path string
 }
 
-// Copy copies the given file to the SSH storage backend.
+// Copy copies the given file to the SSH storage backend. The file is
+// uploaded using a temporary name first and atomically renamed when done,
+// so incomplete files are never mistaken for backups. In case uploads are
+// resumable, a later attempt continues where an interrupted upload stopped.
 func (b *sshStorage) Copy(file string) (returnErr error) {
+	if err := b.reconnect(); err != nil {
+		return errwrap.Wrap(err, "error reconnecting")
+	}
+
 	source, err := os.Open(file)
 	_, name := path.Split(file)
 	if err != nil {
@@ -114,21 +326,129 @@ func (b *sshStorage) Copy(file string) (returnErr error) {
 		return
 	}
 	defer func() {
-		returnErr = source.Close()
+		returnErr = errors.Join(returnErr, source.Close())
+	}()
+
+	destinationPath := path.Join(b.DestinationPath, name)
+	partialPath := destinationPath + partialSuffix
+	if b.Sessions == nil {
+		if err := b.upload(b.sftpClient, source, partialPath, 0); err != nil {
+			_ = b.sftpClient.Remove(partialPath)
+			returnErr = err
+			return
+		}
+		if err := b.rename(partialPath, destinationPath); err != nil {
+			_ = b.sftpClient.Remove(partialPath)
+			returnErr = err
+			return
+		}
+		b.Log(storage.LogLevelInfo, b.Name(), "Uploaded a copy of backup `%s` to '%s' at path '%s'.", file, b.hostName, b.DestinationPath)
+		return nil
+	}
+
+	// Resumed uploads continue at the size of the partial file, so data
+	// needs to be written in order. Concurrent writes could leave holes in
+	// the file when being interrupted.
+	sequentialClient, err := sftp.NewClient(b.client, sftp.UseConcurrentReads(true))
+	if err != nil {
+		returnErr = errwrap.Wrap(err, "error creating sftp client")
+		return
+	}
+	defer func() {
+		returnErr = errors.Join(returnErr, sequentialClient.Close())
 	}()
 
-	destination, err := b.sftpClient.Create(path.Join(b.DestinationPath, name))
+	b.removeStalePartials()
+	var offset int64
+	var state partialState
+	resumed, err := b.Sessions.Load(b.Name(), file, &state)
+	if err != nil {
+		returnErr = errwrap.Wrap(err, "error loading upload session")
+		return
+	}
+	if resumed && state.Path == partialPath {
+		localInfo, err := source.Stat()
+		if err != nil {
+			returnErr = errwrap.Wrap(err, "error reading the file to be uploaded")
+			return
+		}
+		if remoteInfo, err := b.sftpClient.Stat(partialPath); err == nil && remoteInfo.Size() <= localInfo.Size() {
+			offset = remoteInfo.Size()
+			b.Log(storage.LogLevelInfo, b.Name(), "Resuming upload of `%s` at offset %d.", file, offset)
+		}
+	}
+	if err := b.Sessions.Save(b.Name(), file, partialState{Path: partialPath}); err != nil {
+		returnErr = errwrap.Wrap(err, "error saving upload session")
+		return
+	}
+
+	if err := b.upload(sequentialClient, source, partialPath, offset); err != nil {
+		returnErr = err
+		return
+	}
+	if err := b.rename(partialPath, destinationPath); err != nil {
+		returnErr = err
+		return
+	}
+	if err := b.Sessions.Delete(b.Name(), file); err != nil {
+		returnErr = errwrap.Wrap(err, "error deleting upload session")
+		return
+	}
+
+	b.Log(storage.LogLevelInfo, b.Name(), "Uploaded a copy of backup `%s` to '%s' at path '%s'.", file, b.hostName, b.DestinationPath)
+	return nil
+}
+
+// rename moves the uploaded file to its final name, replacing any existing
+// file. Servers that do not support the posix-rename extension cannot
+// replace files atomically, so an existing file is removed first.
+func (b *sshStorage) rename(from, to string) error {
+	err := b.sftpClient.PosixRename(from, to)
+	var statusErr *sftp.StatusError
+	if !errors.As(err, &statusErr) || statusErr.FxCode() != sftp.ErrSSHFxOpUnsupported {
+		if err != nil {
+			return errwrap.Wrap(err, "error renaming uploaded file")
+		}
+		return nil
+	}
+	if err := b.sftpClient.Remove(to); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errwrap.Wrap(err, "error replacing existing file")
+	}
+	if err := b.sftpClient.Rename(from, to); err != nil {
+		return errwrap.Wrap(err, "error renaming uploaded file")
+	}
+	return nil
+}
+
+// upload writes the contents of source to the given remote location using
+// the given client, starting at the given offset.
+func (b *sshStorage) upload(client *sftp.Client, source *os.File, destinationPath string, offset int64) (returnErr error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY
+		if _, err := source.Seek(offset, io.SeekStart); err != nil {
+			return errwrap.Wrap(err, "error seeking in the file to be uploaded")
+		}
+	}
+
+	destination, err := client.OpenFile(destinationPath, flags)
 	if err != nil {
 		returnErr = errwrap.Wrap(err, "error creating file")
 		return
 	}
 	defer func() {
-		returnErr = destination.Close()
+		returnErr = errors.Join(returnErr, destination.Close())
 	}()
+	if offset > 0 {
+		if _, err := destination.Seek(offset, io.SeekStart); err != nil {
+			return errwrap.Wrap(err, "error seeking in the remote file")
+		}
+	}
 
+	reader := b.Reader(source)
 	chunk := make([]byte, 1e9)
 	for {
-		num, err := source.Read(chunk)
+		num, err := reader.Read(chunk)
 		if err == io.EOF {
 			tot, err := destination.Write(chunk[:num])
 			if err != nil {
@@ -161,13 +481,38 @@ func (b *sshStorage) Copy(file string) (returnErr error) {
 		}
 	}
 
-	b.Log(storage.LogLevelInfo, b.Name(), "Uploaded a copy of backup `%s` to '%s' at path '%s'.", file, b.hostName, b.DestinationPath)
-
 	return nil
 }
 
+// removeStalePartials removes files of interrupted uploads that have not
+// been modified for longer than the maximum age of upload sessions. Failing
+// to do so is not considered fatal.
+func (b *sshStorage) removeStalePartials() {
+	if b.Sessions.MaxAge <= 0 {
+		return
+	}
+	candidates, err := b.sftpClient.ReadDir(b.DestinationPath)
+	if err != nil {
+		b.Log(storage.LogLevelWarning, b.Name(), "Unable to look up stale partial uploads: %v", err)
+		return
+	}
+	for _, candidate := range candidates {
+		if candidate.IsDir() || !strings.HasSuffix(candidate.Name(), partialSuffix) || time.Since(candidate.ModTime()) <= b.Sessions.MaxAge {
+			continue
+		}
+		p := path.Join(b.DestinationPath, candidate.Name())
+		if err := b.sftpClient.Remove(p); err != nil {
+			b.Log(storage.LogLevelWarning, b.Name(), "Unable to remove stale partial upload %s: %v", p, err)
+		}
+	}
+}
+
 // Prune rotates away backups according to the configuration and provided deadline for the SSH storage backend.
 func (b *sshStorage) Prune(deadline time.Time, pruningPrefix string) (*storage.PruneStats, error) {
+	if err := b.reconnect(); err != nil {
+		return nil, errwrap.Wrap(err, "error reconnecting")
+	}
+
 	candidates, err := b.sftpClient.ReadDir(b.DestinationPath)
 	if err != nil {
 		return nil, errwrap.Wrap(err, "error reading directory")
@@ -176,7 +521,7 @@ func (b *sshStorage) Prune(deadline time.Time, pruningPrefix string) (*storage.P
 	var matches []string
 	var numCandidates int
 	for _, candidate := range candidates {
-		if candidate.IsDir() || !strings.HasPrefix(candidate.Name(), pruningPrefix) {
+		if candidate.IsDir() || !strings.HasPrefix(candidate.Name(), pruningPrefix) || strings.HasSuffix(candidate.Name(), partialSuffix) {
 			continue
 		}
 
@@ -187,11 +532,10 @@ func (b *sshStorage) Prune(deadline time.Time, pruningPrefix string) (*storage.P
 	}
 
 	stats := &storage.PruneStats{
-		Total:  uint(numCandidates),
-		Pruned: uint(len(matches)),
+		Total: uint(numCandidates),
 	}
 
-	pruneErr := b.DoPrune(b.Name(), len(matches), numCandidates, deadline, func() error {
+	pruneErr := b.DoPrune(b.Name(), matches, stats, deadline, func() error {
 		for _, match := range matches {
 			p := path.Join(b.DestinationPath, match)
 			if err := b.sftpClient.Remove(p); err != nil {
@@ -0,0 +1,458 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/offen/docker-volume-backup/internal/storage"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSSHStorage(t *testing.T) {
+	_, hostKey := newPrivateKey(t)
+	userPrivateKey, userKey := newPrivateKey(t)
+	_, caKey := newPrivateKey(t)
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, opts *Config, target *testServer)
+	}{
+		{
+			"identity file",
+			func(t *testing.T, opts *Config, target *testServer) {
+				opts.IdentityFile = writeIdentity(t, userPrivateKey)
+				target.authorized = userKey.PublicKey()
+			},
+		},
+		{
+			"certificate",
+			func(t *testing.T, opts *Config, target *testServer) {
+				opts.IdentityFile = writeIdentity(t, userPrivateKey)
+				cert := &ssh.Certificate{
+					Key:             userKey.PublicKey(),
+					CertType:        ssh.UserCert,
+					KeyId:           "backup",
+					ValidPrincipals: []string{"backup"},
+					ValidBefore:     ssh.CertTimeInfinity,
+				}
+				if err := cert.SignCert(rand.Reader, caKey); err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				if err := os.WriteFile(opts.IdentityFile+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+				target.ca = caKey.PublicKey()
+			},
+		},
+		{
+			"agent",
+			func(t *testing.T, opts *Config, target *testServer) {
+				opts.AgentSocket = serveAgent(t, userPrivateKey)
+				target.authorized = userKey.PublicKey()
+			},
+		},
+		{
+			"proxy jump",
+			func(t *testing.T, opts *Config, target *testServer) {
+				opts.IdentityFile = writeIdentity(t, userPrivateKey)
+				target.authorized = userKey.PublicKey()
+				// The target is only known by this name to the jump host.
+				_, jumpHostKey := newPrivateKey(t)
+				jump := &testServer{
+					hostKey:    jumpHostKey,
+					authorized: userKey.PublicKey(),
+					hosts:      map[string]string{"target.internal": "127.0.0.1"},
+				}
+				opts.ProxyJump = "jump@" + jump.start(t)
+				opts.HostName = "target.internal"
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			remotePath := t.TempDir()
+			target := &testServer{hostKey: hostKey}
+			opts := Config{
+				User:                "backup",
+				RemotePath:          remotePath,
+				IdentityFile:        filepath.Join(t.TempDir(), "missing"),
+				HostKeyFingerprints: []string{ssh.FingerprintSHA256(hostKey.PublicKey())},
+			}
+			test.setup(t, &opts, target)
+			host, port, _ := net.SplitHostPort(target.start(t))
+			opts.Port = port
+			if opts.HostName == "" {
+				opts.HostName = host
+			}
+
+			backend, err := NewStorageBackend(opts, func(storage.LogLevel, string, string, ...any) {})
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			b := backend.(*sshStorage)
+			defer b.close()
+
+			if err := os.WriteFile(filepath.Join(remotePath, "backup.tar.gz"), []byte("stale"), 0644); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			file := filepath.Join(t.TempDir(), "backup.tar.gz")
+			if err := os.WriteFile(file, []byte("backup"), 0644); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if err := b.Copy(file); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			entries, err := os.ReadDir(remotePath)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if len(entries) != 1 || entries[0].Name() != "backup.tar.gz" {
+				t.Errorf("Unexpected files in remote path %v", entries)
+			}
+			if content, _ := os.ReadFile(filepath.Join(remotePath, "backup.tar.gz")); string(content) != "backup" {
+				t.Errorf("Unexpected content %q", content)
+			}
+
+			if _, err := b.Verify(file); err != nil {
+				t.Errorf("Unexpected error verifying %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(remotePath, "backup.tar.gz"), []byte("bakcup"), 0644); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			var mismatch *storage.ChecksumMismatchError
+			if _, err := b.Verify(file); !errors.As(err, &mismatch) {
+				t.Errorf("Expected checksum mismatch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestResumeUpload(t *testing.T) {
+	userPrivateKey, userKey := newPrivateKey(t)
+	_, hostKey := newPrivateKey(t)
+	target := &testServer{hostKey: hostKey, authorized: userKey.PublicKey()}
+	host, port, _ := net.SplitHostPort(target.start(t))
+	remotePath := t.TempDir()
+
+	var logs []string
+	backend, err := NewStorageBackend(Config{
+		HostName:     host,
+		Port:         port,
+		User:         "backup",
+		RemotePath:   remotePath,
+		IdentityFile: writeIdentity(t, userPrivateKey),
+	}, func(_ storage.LogLevel, _ string, msg string, params ...any) {
+		logs = append(logs, fmt.Sprintf(msg, params...))
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	b := backend.(*sshStorage)
+	defer b.close()
+	b.ResumeUploads(&storage.UploadSessions{Directory: t.TempDir()})
+
+	content := make([]byte, 256*1024)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "backup.tar.gz")
+	if err := os.WriteFile(file, content, 0644); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// The connection is dropped once the first 64KiB have been uploaded.
+	var read int
+	interrupt := true
+	b.LimitReadSize(64 * 1024)
+	b.ObserveReads(func(n int) {
+		if read += n; interrupt && read > 64*1024 {
+			_ = b.client.Close()
+		}
+	})
+	if err := b.Copy(file); err == nil {
+		t.Fatal("Expected the interrupted upload to fail")
+	}
+	partial, err := os.ReadFile(filepath.Join(remotePath, "backup.tar.gz.partial"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(partial) != 64*1024 || !bytes.Equal(partial, content[:len(partial)]) {
+		t.Fatalf("Unexpected partial upload of %d bytes", len(partial))
+	}
+
+	interrupt = false
+	if err := b.Copy(file); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if uploaded, _ := os.ReadFile(filepath.Join(remotePath, "backup.tar.gz")); !bytes.Equal(uploaded, content) {
+		t.Error("Unexpected content of resumed upload")
+	}
+	if !slices.Contains(logs, fmt.Sprintf("Resuming upload of `%s` at offset %d.", file, 64*1024)) {
+		t.Errorf("Expected upload to be resumed, got logs %v", logs)
+	}
+}
+
+func TestProxyJumpHostKeys(t *testing.T) {
+	userPrivateKey, userKey := newPrivateKey(t)
+	_, hostKey := newPrivateKey(t)
+	_, jumpHostKey := newPrivateKey(t)
+	_, otherKey := newPrivateKey(t)
+
+	tests := []struct {
+		name          string
+		knownJumpKey  ssh.PublicKey
+		fingerprint   string
+		expectedError bool
+	}{
+		{"jump host known", jumpHostKey.PublicKey(), ssh.FingerprintSHA256(hostKey.PublicKey()), false},
+		{"jump host key mismatch", otherKey.PublicKey(), ssh.FingerprintSHA256(hostKey.PublicKey()), true},
+		{"target fingerprint mismatch", jumpHostKey.PublicKey(), ssh.FingerprintSHA256(jumpHostKey.PublicKey()), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := &testServer{hostKey: hostKey, authorized: userKey.PublicKey()}
+			_, port, _ := net.SplitHostPort(target.start(t))
+			jump := &testServer{
+				hostKey:    jumpHostKey,
+				authorized: userKey.PublicKey(),
+				hosts:      map[string]string{"target.internal": "127.0.0.1"},
+			}
+			jumpAddr := jump.start(t)
+
+			knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+			lines := knownhosts.Line([]string{knownhosts.Normalize(jumpAddr)}, test.knownJumpKey) + "\n" +
+				knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort("target.internal", port))}, hostKey.PublicKey()) + "\n"
+			if err := os.WriteFile(knownHosts, []byte(lines), 0600); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			opts := Config{
+				HostName:            "target.internal",
+				Port:                port,
+				User:                "backup",
+				RemotePath:          t.TempDir(),
+				IdentityFile:        writeIdentity(t, userPrivateKey),
+				KnownHostsFile:      knownHosts,
+				HostKeyFingerprints: []string{test.fingerprint},
+				ProxyJump:           "jump@" + jumpAddr,
+			}
+
+			backend, err := NewStorageBackend(opts, func(storage.LogLevel, string, string, ...any) {})
+			if test.expectedError {
+				var mismatch *storage.HostKeyMismatchError
+				if !errors.As(err, &mismatch) {
+					t.Errorf("Expected host key mismatch, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			backend.(*sshStorage).close()
+		})
+	}
+}
+
+func TestParseProxyJump(t *testing.T) {
+	hosts, err := parseProxyJump("bastion, admin@jump.example.com:2222,[::1]", "backup")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := []jumpHost{
+		{user: "backup", addr: "bastion:22"},
+		{user: "admin", addr: "jump.example.com:2222"},
+		{user: "backup", addr: "[::1]:22"},
+	}
+	if len(hosts) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, hosts)
+	}
+	for i := range expected {
+		if hosts[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], hosts[i])
+		}
+	}
+	if _, err := parseProxyJump("@bastion", ""); err == nil {
+		t.Error("Expected error for missing user")
+	}
+}
+
+// testServer is a minimal SSH server serving the local file system using
+// SFTP and forwarding TCP connections for being used as a jump host.
+type testServer struct {
+	hostKey    ssh.Signer
+	authorized ssh.PublicKey
+	ca         ssh.PublicKey
+	// hosts maps host names to addresses when forwarding connections.
+	hosts map[string]string
+}
+
+func (s *testServer) start(t *testing.T) string {
+	t.Helper()
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return s.ca != nil && bytes.Equal(auth.Marshal(), s.ca.Marshal())
+		},
+		UserKeyFallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if s.authorized != nil && bytes.Equal(key.Marshal(), s.authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unauthorized")
+		},
+	}
+	config := &ssh.ServerConfig{PublicKeyCallback: checker.Authenticate}
+	config.AddHostKey(s.hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func (s *testServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	serverConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer serverConn.Close()
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go func() {
+				for req := range requests {
+					isSFTP := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+					_ = req.Reply(isSFTP, nil)
+					if isSFTP {
+						server, err := sftp.NewServer(channel)
+						if err == nil {
+							_ = server.Serve()
+						}
+						_ = channel.Close()
+					}
+				}
+			}()
+		case "direct-tcpip":
+			payload := newChannel.ExtraData()
+			hostLength := binary.BigEndian.Uint32(payload)
+			host := string(payload[4 : 4+hostLength])
+			port := binary.BigEndian.Uint32(payload[4+hostLength:])
+			if ip, ok := s.hosts[host]; ok {
+				host = ip
+			}
+			target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+			if err != nil {
+				_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				_ = target.Close()
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				_, _ = io.Copy(target, channel)
+				_ = target.Close()
+			}()
+			go func() {
+				_, _ = io.Copy(channel, target)
+				_ = channel.Close()
+			}()
+		default:
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func newPrivateKey(t *testing.T) (ed25519.PrivateKey, ssh.Signer) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return key, signer
+}
+
+func writeIdentity(t *testing.T, key ed25519.PrivateKey) string {
+	t.Helper()
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return file
+}
+
+// serveAgent serves an agent holding the given key, returning the path of
+// its socket.
+func serveAgent(t *testing.T, key ed25519.PrivateKey) string {
+	t.Helper()
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	// Socket paths are limited in length, so t.TempDir() cannot be used.
+	dir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = agent.ServeAgent(keyring, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return socket
+}
@@ -4,6 +4,12 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
 	"time"
 
 	"github.com/offen/docker-volume-backup/internal/errwrap"
@@ -16,10 +22,164 @@ type Backend interface {
 	Name() string
 }
 
+// CheckableBackend is implemented by backends that can verify that they are
+// able to access their destination without modifying it.
+type CheckableBackend interface {
+	Check() error
+}
+
+// RetryableBackend is implemented by backends that can tell whether an error
+// returned from one of their operations is transient, so that the operation
+// can be retried.
+type RetryableBackend interface {
+	Retryable(err error) bool
+}
+
+// IsRetryableStatus returns whether the given HTTP status code signals a
+// transient error.
+func IsRetryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// IsTransientNetworkError returns whether the given error was caused by
+// a timeout or an interrupted connection.
+func IsTransientNetworkError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// HostKeyMismatchError is returned by backends that verify the identity of
+// the remote host in case the host presented a key other than the expected
+// one, which might be caused by a man-in-the-middle attack.
+type HostKeyMismatchError struct {
+	Host        string
+	Fingerprint string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf(
+		"host key mismatch: %s presented key %s, which does not match the expected host key, this might be a man-in-the-middle attack",
+		e.Host, e.Fingerprint,
+	)
+}
+
 // StorageBackend is a generic type of storage. Everything here are common properties of all storage types.
 type StorageBackend struct {
 	DestinationPath string
 	Log             Log
+	Sessions        *UploadSessions
+	readObservers   []ReadObserver
+	maxReadSize     int
+	name            string
+	dryRun          bool
+}
+
+// NameableBackend is implemented by all backends that can be given a name
+// other than their type, e.g. when using multiple backends of the same type.
+type NameableBackend interface {
+	SetName(name string)
+}
+
+// SetName sets the name the backend is referred to by.
+func (b *StorageBackend) SetName(name string) {
+	b.name = name
+}
+
+// NameOr returns the name that has been set for the backend, or the given
+// default name in case none has been set.
+func (b *StorageBackend) NameOr(defaultName string) string {
+	if b.name == "" {
+		return defaultName
+	}
+	return b.name
+}
+
+// DryRunnableBackend is implemented by all backends that can report which
+// backups they would prune instead of deleting them.
+type DryRunnableBackend interface {
+	EnableDryRun()
+}
+
+// EnableDryRun makes all subsequent calls to DoPrune log the backups that
+// would be pruned instead of deleting them.
+func (b *StorageBackend) EnableDryRun() {
+	b.dryRun = true
+}
+
+// ReadObserver is called each time bytes of a file that is being uploaded
+// have been read. Observers may be called concurrently and may block in
+// order to limit the rate at which the file is uploaded.
+type ReadObserver func(n int)
+
+// ObservableBackend is implemented by all backends that report progress
+// while uploading.
+type ObservableBackend interface {
+	ObserveReads(o ReadObserver)
+	LimitReadSize(n int)
+}
+
+// ObserveReads registers an observer that is notified about the progress
+// of all subsequent uploads.
+func (b *StorageBackend) ObserveReads(o ReadObserver) {
+	b.readObservers = append(b.readObservers, o)
+}
+
+// LimitReadSize makes observers be notified at least every n bytes, so
+// that observers that block can do so in small increments.
+func (b *StorageBackend) LimitReadSize(n int) {
+	b.maxReadSize = n
+}
+
+// Reader wraps the given reader so that registered observers are notified
+// about all bytes that are read from it.
+func (b *StorageBackend) Reader(r io.Reader) io.Reader {
+	if len(b.readObservers) == 0 {
+		return r
+	}
+	return &observedReader{Reader: r, observers: b.readObservers, maxReadSize: b.maxReadSize}
+}
+
+// Progress returns a reader that notifies registered observers about the
+// length of each slice it is asked to read into. It can be passed to
+// clients that report upload progress by reading from a given reader.
+func (b *StorageBackend) Progress() io.Reader {
+	if len(b.readObservers) == 0 {
+		return nil
+	}
+	return &observedReader{observers: b.readObservers, maxReadSize: b.maxReadSize}
+}
+
+type observedReader struct {
+	io.Reader
+	observers   []ReadObserver
+	maxReadSize int
+}
+
+func (o *observedReader) Read(p []byte) (int, error) {
+	n, err := len(p), error(nil)
+	if o.Reader != nil {
+		if o.maxReadSize > 0 && len(p) > o.maxReadSize {
+			p = p[:o.maxReadSize]
+		}
+		n, err = o.Reader.Read(p)
+	}
+	for remaining := n; remaining > 0; {
+		chunk := remaining
+		if o.maxReadSize > 0 {
+			chunk = min(chunk, o.maxReadSize)
+		}
+		for _, observer := range o.observers {
+			observer(chunk)
+		}
+		remaining -= chunk
+	}
+	return n, err
 }
 
 type LogLevel int
@@ -35,20 +195,45 @@ type Log func(logType LogLevel, context string, msg string, params ...any)
 type PruneStats struct {
 	Total  uint
 	Pruned uint
+	// Locked is the number of backups that would have been pruned but are
+	// protected from being deleted.
+	Locked uint
+	// Refused is set in case pruning has been refused as it would have
+	// deleted all existing backups.
+	Refused bool
 }
 
 // DoPrune holds general control flow that applies to any kind of storage.
 // Callers can pass in a thunk that performs the actual deletion of files.
-func (b *StorageBackend) DoPrune(context string, lenMatches, lenCandidates int, deadline time.Time, doRemoveFiles func() error) error {
+// In dry run mode, the given matches are logged instead. The given stats
+// need to hold the total number of candidates and are updated with the
+// outcome.
+func (b *StorageBackend) DoPrune(context string, matches []string, stats *PruneStats, deadline time.Time, doRemoveFiles func() error) error {
+	lenMatches := len(matches)
+	lenCandidates := int(stats.Total)
 	if lenMatches != 0 && lenMatches != lenCandidates {
-		if err := doRemoveFiles(); err != nil {
-			return err
-		}
-
+		stats.Pruned = uint(lenMatches)
 		formattedDeadline, err := deadline.Local().MarshalText()
 		if err != nil {
 			return errwrap.Wrap(err, "error marshaling deadline")
 		}
+		if b.dryRun {
+			for _, match := range matches {
+				b.Log(LogLevelInfo, context, "Would prune %s.", match)
+			}
+			b.Log(LogLevelInfo, context,
+				"Would prune %d out of %d backups as they are older than the given deadline of %s.",
+				lenMatches,
+				lenCandidates,
+				string(formattedDeadline),
+			)
+			return nil
+		}
+
+		if err := doRemoveFiles(); err != nil {
+			return err
+		}
+
 		b.Log(LogLevelInfo, context,
 			"Pruned %d out of %d backups as they were older than the given deadline of %s.",
 			lenMatches,
@@ -58,8 +243,19 @@ func (b *StorageBackend) DoPrune(context string, lenMatches, lenCandidates int,
 	} else if lenMatches != 0 && lenMatches == lenCandidates {
 		b.Log(LogLevelWarning, context, "The current configuration would delete all %d existing backups.", lenMatches)
 		b.Log(LogLevelWarning, context, "Refusing to do so, please check your configuration.")
+		stats.Refused = true
 	} else {
 		b.Log(LogLevelInfo, context, "None of %d existing backups were pruned.", lenCandidates)
 	}
 	return nil
 }
+
+// Names returns the name of each of the given items, e.g. for passing
+// matches to DoPrune.
+func Names[T any](items []T, name func(T) string) []string {
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = name(item)
+	}
+	return names
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDoPrune(t *testing.T) {
+	tests := []struct {
+		name            string
+		dryRun          bool
+		matches         []string
+		lenCandidates   int
+		expectRemoved   bool
+		expectedMessage string
+		expectedStats   PruneStats
+	}{
+		{
+			"prune",
+			false,
+			[]string{"a", "b"},
+			3,
+			true,
+			"Pruned 2 out of 3 backups",
+			PruneStats{Total: 3, Pruned: 2},
+		},
+		{
+			"dry run",
+			true,
+			[]string{"a", "b"},
+			3,
+			false,
+			"Would prune 2 out of 3 backups",
+			PruneStats{Total: 3, Pruned: 2},
+		},
+		{
+			"refuse to delete all",
+			false,
+			[]string{"a", "b"},
+			2,
+			false,
+			"Refusing to do so",
+			PruneStats{Total: 2, Refused: true},
+		},
+		{
+			"nothing to prune",
+			true,
+			nil,
+			2,
+			false,
+			"None of 2 existing backups were pruned.",
+			PruneStats{Total: 2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var messages []string
+			b := &StorageBackend{
+				Log: func(_ LogLevel, _ string, msg string, params ...any) {
+					messages = append(messages, fmt.Sprintf(msg, params...))
+				},
+			}
+			if test.dryRun {
+				b.EnableDryRun()
+			}
+			var removed bool
+			stats := &PruneStats{Total: uint(test.lenCandidates)}
+			if err := b.DoPrune("test", test.matches, stats, time.Now(), func() error {
+				removed = true
+				return nil
+			}); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if *stats != test.expectedStats {
+				t.Errorf("Expected stats %v, got %v", test.expectedStats, *stats)
+			}
+			if removed != test.expectRemoved {
+				t.Errorf("Expected removal to be %v, got %v", test.expectRemoved, removed)
+			}
+			if !slices.ContainsFunc(messages, func(m string) bool { return strings.HasPrefix(m, test.expectedMessage) }) {
+				t.Errorf("Expected message %s, got %v", test.expectedMessage, messages)
+			}
+			if test.dryRun && len(test.matches) != 0 && !slices.Contains(messages, "Would prune a.") {
+				t.Errorf("Expected matches to be logged, got %v", messages)
+			}
+		})
+	}
+}
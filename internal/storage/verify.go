@@ -0,0 +1,103 @@
+// Copyright 2025 - offen.software <hioffen@posteo.de>
+// SPDX-License-Identifier: MPL-2.0
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/offen/docker-volume-backup/internal/errwrap"
+)
+
+// VerifiableBackend is implemented by backends that can verify that a file
+// they have stored is identical to the given local file.
+type VerifiableBackend interface {
+	Verify(file string) (*Verification, error)
+}
+
+// Verification describes how a stored file has been verified.
+type Verification struct {
+	// Method describes how the file has been verified, e.g. `SHA256`.
+	Method string
+	// Checksum is the checksum of the stored file as reported by the
+	// backend, if any.
+	Checksum string
+}
+
+// ChecksumMismatchError is returned when verifying a stored file in case it
+// does not match the local file.
+type ChecksumMismatchError struct {
+	Name     string
+	Method   string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf(
+		"stored file %s does not match the local file: expected %s %s, got %s",
+		e.Name, e.Method, e.Expected, e.Actual,
+	)
+}
+
+// Compare returns a ChecksumMismatchError in case the given values differ,
+// or a Verification otherwise.
+func Compare(name, method, expected, actual string) (*Verification, error) {
+	if expected != actual {
+		return nil, &ChecksumMismatchError{Name: name, Method: method, Expected: expected, Actual: actual}
+	}
+	return &Verification{Method: method, Checksum: actual}, nil
+}
+
+// Digest returns the digest of the given file using the given hash.
+func Digest(file string, h hash.Hash) (digest []byte, returnErr error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error opening file")
+	}
+	defer func() {
+		returnErr = errors.Join(returnErr, f.Close())
+	}()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, errwrap.Wrap(err, "error reading file")
+	}
+	return h.Sum(nil), nil
+}
+
+// PartDigest splits the given file into parts of the given size and returns
+// the digest of the concatenated digests of all parts, as well as the number
+// of parts. This is how checksums of multipart uploads are computed.
+func PartDigest(file string, partSize int64, newHash func() hash.Hash) (digest []byte, parts int, returnErr error) {
+	if partSize <= 0 {
+		return nil, 0, errwrap.Wrap(nil, fmt.Sprintf("invalid part size %d", partSize))
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, 0, errwrap.Wrap(err, "error opening file")
+	}
+	defer func() {
+		returnErr = errors.Join(returnErr, f.Close())
+	}()
+
+	h := newHash()
+	for {
+		part := newHash()
+		n, err := io.CopyN(part, f, partSize)
+		if err != nil && err != io.EOF {
+			return nil, 0, errwrap.Wrap(err, "error reading file")
+		}
+		if n == 0 {
+			break
+		}
+		h.Write(part.Sum(nil))
+		parts++
+		if n < partSize {
+			break
+		}
+	}
+	return h.Sum(nil), parts, nil
+}
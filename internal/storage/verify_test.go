@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPartDigest(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		partSize      int64
+		newHash       func() hash.Hash
+		expected      string
+		expectedParts int
+	}{
+		{"multiple parts", "hello world", 5, md5.New, "df349a9519959b17a605009540f4b31d", 3},
+		{"single part", "hello world", 11, md5.New, "241d8a27c836427bd7f04461b60e7359", 1},
+		{"dropbox content hash", "hello world", 4 * 1024 * 1024, sha256.New, "bc62d4b80d9e36da29c16c5d4d9f11731f36052c72401a76c23c0fb5a9b74423", 1},
+		{"empty", "", 4, sha256.New, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(file, []byte(test.content), 0644); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			digest, parts, err := PartDigest(file, test.partSize, test.newHash)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if hex.EncodeToString(digest) != test.expected {
+				t.Errorf("Expected digest %s, got %x", test.expected, digest)
+			}
+			if parts != test.expectedParts {
+				t.Errorf("Expected %d parts, got %d", test.expectedParts, parts)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	verification, err := Compare("backup.tar.gz", "SHA256", "abc", "abc")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if verification.Method != "SHA256" || verification.Checksum != "abc" {
+		t.Errorf("Unexpected verification %v", verification)
+	}
+
+	_, err = Compare("backup.tar.gz", "SHA256", "abc", "def")
+	var mismatch *ChecksumMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected mismatch, got %v", err)
+	}
+}
@@ -4,7 +4,11 @@
 package webdav
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"net/http"
 	"os"
@@ -61,7 +65,7 @@ func NewStorageBackend(opts Config, logFunc storage.Log) (storage.Backend, error
 
 // Name returns the name of the storage backend
 func (b *webDavStorage) Name() string {
-	return "WebDAV"
+	return b.NameOr("WebDAV")
 }
 
 // Copy copies the given file to the WebDav storage backend.
@@ -76,7 +80,12 @@ func (b *webDavStorage) Copy(file string) error {
 		return errwrap.Wrap(err, "error opening the file to be uploaded")
 	}
 
-	if err := b.client.WriteStream(path.Join(b.DestinationPath, name), r, 0644); err != nil {
+	info, err := r.Stat()
+	if err != nil {
+		return errwrap.Wrap(err, "error reading the file to be uploaded")
+	}
+
+	if err := b.client.WriteStreamWithLength(path.Join(b.DestinationPath, name), b.Reader(r), info.Size(), 0644); err != nil {
 		return errwrap.Wrap(err, "error uploading the file")
 	}
 	b.Log(storage.LogLevelInfo, b.Name(), "Uploaded a copy of backup '%s' to '%s' at path '%s'.", file, b.url, b.DestinationPath)
@@ -84,6 +93,46 @@ func (b *webDavStorage) Copy(file string) error {
 	return nil
 }
 
+// Check verifies that the remote path exists.
+func (b *webDavStorage) Check() error {
+	if _, err := b.client.Stat(b.DestinationPath); err != nil {
+		return errwrap.Wrap(err, fmt.Sprintf("error accessing %s", b.DestinationPath))
+	}
+	return nil
+}
+
+// Verify downloads the stored copy of the given file and compares its
+// checksum to the one of the given file.
+func (b *webDavStorage) Verify(file string) (verification *storage.Verification, returnErr error) {
+	_, name := path.Split(file)
+	expected, err := storage.Digest(file, sha256.New())
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error computing checksum of local file")
+	}
+	stream, err := b.client.ReadStream(path.Join(b.DestinationPath, name))
+	if err != nil {
+		return nil, errwrap.Wrap(err, "error downloading stored file")
+	}
+	defer func() {
+		returnErr = errors.Join(returnErr, stream.Close())
+	}()
+	h := sha256.New()
+	if _, err := io.Copy(h, stream); err != nil {
+		return nil, errwrap.Wrap(err, "error downloading stored file")
+	}
+	return storage.Compare(name, "SHA256", hex.EncodeToString(expected), hex.EncodeToString(h.Sum(nil)))
+}
+
+// Retryable returns whether the given error was caused by a transient
+// network issue or a server side error.
+func (b *webDavStorage) Retryable(err error) bool {
+	var statusErr gowebdav.StatusError
+	if errors.As(err, &statusErr) {
+		return storage.IsRetryableStatus(statusErr.Status)
+	}
+	return storage.IsTransientNetworkError(err)
+}
+
 // Prune rotates away backups according to the configuration and provided deadline for the WebDav storage backend.
 func (b *webDavStorage) Prune(deadline time.Time, pruningPrefix string) (*storage.PruneStats, error) {
 	candidates, err := b.client.ReadDir(b.DestinationPath)
@@ -104,11 +153,10 @@ func (b *webDavStorage) Prune(deadline time.Time, pruningPrefix string) (*storag
 	}
 
 	stats := &storage.PruneStats{
-		Total:  uint(numCandidates),
-		Pruned: uint(len(matches)),
+		Total: uint(numCandidates),
 	}
 
-	pruneErr := b.DoPrune(b.Name(), len(matches), numCandidates, deadline, func() error {
+	pruneErr := b.DoPrune(b.Name(), storage.Names(matches, fs.FileInfo.Name), stats, deadline, func() error {
 		for _, match := range matches {
 			if err := b.client.Remove(path.Join(b.DestinationPath, match.Name())); err != nil {
 				return errwrap.Wrap(err, "error removing file")
@@ -0,0 +1,43 @@
+#!/bin/sh
+
+set -e
+
+cd $(dirname $0)
+. ../util.sh
+current_test=$(basename $(pwd))
+
+export LOCAL_DIR=$(mktemp -d)
+
+docker compose up -d --quiet-pull
+sleep 5
+
+STATUS_CODE=$(curl -s -o /dev/null -w '%{http_code}' -X POST http://localhost:8080/v1/run)
+if [ "$STATUS_CODE" != "401" ]; then
+  fail "Expected unauthenticated request to be rejected, got status $STATUS_CODE."
+fi
+pass "Unauthenticated request was rejected."
+
+STATUS_CODE=$(curl -s -o /dev/null -w '%{http_code}' -X POST -H 'Authorization: Bearer test-token' 'http://localhost:8080/v1/run?config=nope')
+if [ "$STATUS_CODE" != "404" ]; then
+  fail "Expected unknown configuration to be rejected, got status $STATUS_CODE."
+fi
+pass "Unknown configuration was rejected."
+
+STATUS_CODE=$(curl -s -o /dev/null -w '%{http_code}' -X POST -H 'Authorization: Bearer test-token' 'http://localhost:8080/v1/run?wait=true')
+if [ "$STATUS_CODE" != "200" ]; then
+  fail "Expected triggered run to succeed, got status $STATUS_CODE."
+fi
+pass "Triggered run succeeded."
+
+expect_running_containers "2"
+
+if [ ! -f "$LOCAL_DIR/test.tar.gz" ]; then
+  fail "Could not find archive created by triggered run."
+fi
+pass "Found archive created by triggered run."
+
+LAST_TRIGGER=$(curl -sSL -H 'Authorization: Bearer test-token' http://localhost:8080/v1/status | jq -r '.configurations[0].lastRun.trigger')
+if [ "$LAST_TRIGGER" != "api" ]; then
+  fail "Unexpected status of last run: $LAST_TRIGGER"
+fi
+pass "Status reports the triggered run."
@@ -0,0 +1,41 @@
+#!/bin/sh
+
+set -e
+
+cd "$(dirname "$0")"
+. ../util.sh
+current_test=$(basename $(pwd))
+
+export LOCAL_DIR=$(mktemp -d)
+
+docker compose up -d --quiet-pull
+sleep 5
+
+docker compose exec -e VAULT_ADDR=http://127.0.0.1:8200 -e VAULT_TOKEN=test vault vault secrets enable transit
+docker compose exec -e VAULT_ADDR=http://127.0.0.1:8200 -e VAULT_TOKEN=test vault vault write -f transit/keys/backup
+
+docker compose exec backup backup
+
+expect_running_containers "3"
+
+if ! jq -e '.keys[0].wrappedKey | startswith("vault:v1:")' "$LOCAL_DIR/test.tar.gz.age.meta.json" > /dev/null; then
+  fail "Could not find wrapped data key in encryption metadata."
+fi
+pass "Found wrapped data key in encryption metadata."
+
+docker compose exec -e VAULT_ADDR=http://vault:8200 -e VAULT_TOKEN=test backup \
+  backup decrypt -o /archive/decrypted.tar.gz /archive/test.tar.gz.age
+
+TMP_DIR=$(mktemp -d)
+tar -xf "$LOCAL_DIR/decrypted.tar.gz" -C $TMP_DIR
+
+if [ ! -f $TMP_DIR/backup/app_data/offen.db ]; then
+  fail "Could not find expected file in untared archive."
+fi
+rm "$LOCAL_DIR/decrypted.tar.gz"
+
+pass "Found relevant files in decrypted and untared local backup."
+
+if [ ! -L "$LOCAL_DIR/test-latest.tar.gz.age" ]; then
+  fail "Could not find local symlink to latest encrypted backup."
+fi